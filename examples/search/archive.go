@@ -0,0 +1,282 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// maxArchiveDepth limits how many layers of compression/archives are unwrapped,
+// e.g. a zip inside a tar.gz is depth 3. This stops deliberately nested archives.
+const maxArchiveDepth = 4
+
+// errSizeLimit is returned by sizeLimitReader once more than the allowed number
+// of bytes has been read. It lets decompressed content honor --max-filesize
+// even though its size isn't known up front.
+var errSizeLimit = errors.New("decompressed size limit exceeded")
+
+// archiveFormat identifies a compressed stream or archive by its magic bytes.
+type archiveFormat int
+
+const (
+	formatPlain archiveFormat = iota
+	formatGzip
+	formatBzip2
+	formatZlib
+	formatZip
+	formatTar
+)
+
+// detectArchiveFormat inspects the first bytes of content to find out whether it
+// is compressed or an archive. Anything unrecognized is treated as plain content.
+func detectArchiveFormat(sample []byte) (format archiveFormat) {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0x1f, 0x8b}):
+		format = formatGzip
+	case bytes.HasPrefix(sample, []byte("BZh")) && len(sample) > 3 && sample[3] >= '1' && sample[3] <= '9':
+		format = formatBzip2
+	case bytes.HasPrefix(sample, []byte("PK\x03\x04")), bytes.HasPrefix(sample, []byte("PK\x05\x06")):
+		format = formatZip
+	case len(sample) >= 262 && string(sample[257:262]) == "ustar":
+		format = formatTar
+	case len(sample) >= 2 && sample[0] == 0x78 && (sample[1] == 0x01 || sample[1] == 0x9c || sample[1] == 0xda):
+		// zlib header with deflate and a standard compression level; the 0x5e
+		// level is left out because "x^" is too common at the start of text files
+		format = formatZlib
+	default:
+		format = formatPlain
+	}
+	return format
+}
+
+// searchCompressed searches content that may be compressed or an archive, used in
+// -z mode. Compressed streams are decompressed and searched again (so .tar.gz
+// works), archive entries are searched one by one and reported as
+// "archive.zip!/inner/path.txt", and plain content goes to searchReader.
+// Decompressed bytes count against --max-filesize, and searching a stream stops
+// once it is reached since its size is only known after decompressing it.
+func (ds *DirSearch) searchCompressed(ctx context.Context, displayPath string, r io.Reader, depth int) (err error) {
+	var reader *bufio.Reader
+	var sample []byte
+	var format archiveFormat
+	var decompressed io.Reader
+
+	reader = bufio.NewReaderSize(r, 64*1024)
+	sample, err = peekSample(reader, false)
+	if err != nil {
+		err = ds.skipArchiveError(displayPath, err)
+		goto end
+	}
+
+	format = detectArchiveFormat(sample)
+	if format != formatPlain && depth >= maxArchiveDepth {
+		ds.recordSkip(displayPath, skipNestedArchive)
+		goto end
+	}
+
+	switch format {
+	case formatGzip:
+		decompressed, err = gzip.NewReader(reader)
+	case formatBzip2:
+		decompressed = bzip2.NewReader(reader)
+	case formatZlib:
+		decompressed, err = zlib.NewReader(reader)
+	case formatZip:
+		err = ds.searchZip(ctx, displayPath, r, reader, depth)
+		goto end
+	case formatTar:
+		err = ds.searchTar(ctx, displayPath, reader, depth)
+		goto end
+	default:
+		// Decompressed streams are already size limited and archive entries were
+		// checked against their declared size, so plain content can be searched as-is
+		err = ds.skipArchiveError(displayPath, ds.searchReader(ctx, displayPath, reader))
+		goto end
+	}
+	if err != nil {
+		err = ds.skipArchiveError(displayPath, err)
+		goto end
+	}
+
+	if ds.verbose {
+		fmt.Printf("[TRACE] Decompressing %s\n", displayPath)
+	}
+	err = ds.searchCompressed(ctx, displayPath, ds.limitSize(decompressed), depth+1)
+
+end:
+	return err
+}
+
+// searchZip searches every regular file inside a zip archive. Zip needs random
+// access, so a regular *os.File is used directly while nested zips and pipes
+// such as standard input are read into memory (bounded by --max-filesize).
+func (ds *DirSearch) searchZip(ctx context.Context, displayPath string, original io.Reader, buffered io.Reader, depth int) (err error) {
+	var zr *zip.Reader
+	var readerAt io.ReaderAt
+	var size int64
+	var file *os.File
+	var isFile bool
+	var stat os.FileInfo
+	var data []byte
+
+	file, isFile = original.(*os.File)
+	if isFile {
+		stat, err = file.Stat()
+		if err != nil {
+			err = ds.skipArchiveError(displayPath, err)
+			goto end
+		}
+	}
+	if stat != nil && stat.Mode().IsRegular() {
+		readerAt, size = file, stat.Size()
+	} else {
+		data, err = io.ReadAll(ds.limitSize(buffered))
+		if err != nil {
+			err = ds.skipArchiveError(displayPath, err)
+			goto end
+		}
+		readerAt, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	zr, err = zip.NewReader(readerAt, size)
+	if err != nil {
+		err = ds.skipArchiveError(displayPath, err)
+		goto end
+	}
+
+	for _, entry := range zr.File {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		err = ds.searchZipEntry(ctx, displayPath+"!/"+entry.Name, entry, depth)
+		if err != nil {
+			goto end
+		}
+	}
+
+end:
+	return err
+}
+
+// searchZipEntry opens and searches a single zip entry, closing it afterwards.
+func (ds *DirSearch) searchZipEntry(ctx context.Context, displayPath string, entry *zip.File, depth int) (err error) {
+	var rc io.ReadCloser
+
+	if ds.opts.MaxFileSize > 0 && int64(entry.UncompressedSize64) > ds.opts.MaxFileSize {
+		ds.recordSkip(displayPath, skipLarge)
+		goto end
+	}
+
+	rc, err = entry.Open()
+	if err != nil {
+		err = ds.skipArchiveError(displayPath, err)
+		goto end
+	}
+	defer func() {
+		if closeErr := rc.Close(); closeErr != nil && err == nil {
+			err = ds.skipArchiveError(displayPath, closeErr)
+		}
+	}()
+
+	err = ds.searchCompressed(ctx, displayPath, rc, depth+1)
+
+end:
+	return err
+}
+
+// searchTar searches every regular file inside a (possibly decompressed) tar stream.
+func (ds *DirSearch) searchTar(ctx context.Context, displayPath string, r io.Reader, depth int) (err error) {
+	var tr *tar.Reader
+	var header *tar.Header
+
+	tr = tar.NewReader(r)
+	for {
+		header, err = tr.Next()
+		if err == io.EOF {
+			err = nil
+			goto end
+		}
+		if err != nil {
+			err = ds.skipArchiveError(displayPath, err)
+			goto end
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		entryPath := displayPath + "!/" + header.Name
+		if ds.opts.MaxFileSize > 0 && header.Size > ds.opts.MaxFileSize {
+			ds.recordSkip(entryPath, skipLarge)
+			continue
+		}
+
+		err = ds.searchCompressed(ctx, entryPath, tr, depth+1)
+		if err != nil {
+			goto end
+		}
+	}
+
+end:
+	return err
+}
+
+// skipArchiveError decides whether an error from reading compressed content
+// should stop the search. Cancellation does; corrupt or oversized archives are
+// skipped like any other unreadable file.
+func (ds *DirSearch) skipArchiveError(displayPath string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if errors.Is(err, errSizeLimit) {
+		ds.recordSkip(displayPath, skipLarge)
+	} else if ds.verbose {
+		fmt.Printf("[TRACE] Cannot read compressed content %s: %v\n", displayPath, err)
+	}
+	return nil
+}
+
+// limitSize wraps r so that reading more than --max-filesize bytes fails with
+// errSizeLimit. With no size limit r is returned unchanged.
+func (ds *DirSearch) limitSize(r io.Reader) io.Reader {
+	if ds.opts.MaxFileSize <= 0 {
+		return r
+	}
+	return &sizeLimitReader{r: r, remaining: ds.opts.MaxFileSize}
+}
+
+// sizeLimitReader is like io.LimitReader, but reports errSizeLimit instead of a
+// silent EOF so truncated content is never mistaken for a complete file.
+type sizeLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+// Read implements io.Reader.
+func (l *sizeLimitReader) Read(p []byte) (n int, err error) {
+	if l.remaining < 0 {
+		return 0, errSizeLimit
+	}
+	// Allow one byte past the limit so hitting it exactly isn't an error
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err = l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		n += int(l.remaining)
+		err = errSizeLimit
+	}
+	return n, err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+// zipArchive returns a zip archive holding files of the given content.
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestSearchZipStdin checks that -z finds matches in a zip archive on standard
+// input, whether it is a pipe or redirected from a file.
+func TestSearchZipStdin(t *testing.T) {
+	data := zipArchive(t, map[string]string{"inner/a.txt": "hay\nneedle\n", "b.txt": "hay\n"})
+	path := filepath.Join(t.TempDir(), "a.zip")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		stdin       func() *os.File
+		maxFileSize int64
+		want        []string
+	}{
+		{"file", func() *os.File {
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return file
+		}, 0, []string{stdinName + "!/inner/a.txt"}},
+		{"pipe", func() *os.File { return pipeOf(t, data) }, 0, []string{stdinName + "!/inner/a.txt"}},
+		{"pipe over the size limit", func() *os.File { return pipeOf(t, data) }, int64(len(data)) - 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin := os.Stdin
+			os.Stdin = tt.stdin()
+			defer func() {
+				os.Stdin.Close()
+				os.Stdin = stdin
+			}()
+
+			opts := DefaultOptions()
+			opts.SearchZip = true
+			opts.MaxFileSize = tt.maxFileSize
+			ds := NewDirSearch(nil, regexp.MustCompile("needle"), opts)
+			ds.AddFiles("-")
+			var got []string
+			ds.SetOutput(func(match Match) error {
+				got = append(got, match.FilePath)
+				return nil
+			})
+			if err := ds.Run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matches in %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// pipeOf returns the read end of a pipe that data is written to.
+func pipeOf(t *testing.T, data []byte) (r *os.File) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+	return r
+}
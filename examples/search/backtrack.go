@@ -0,0 +1,1690 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxBacktrackSteps bounds the work done by one FindAll call of the backtracking
+// engine. Patterns like (a+)+b can take exponential time on unlucky input; once
+// the budget is spent the search fails with errBacktrackLimit rather than
+// hanging the whole run or quietly missing matches.
+const maxBacktrackSteps = 50_000_000
+
+// maxBacktrackNesting bounds how deeply groups may nest, as in regexp/syntax, so
+// that parsing and matching never recurse deeply however long the pattern is.
+const maxBacktrackNesting = 1000
+
+// backtrackRegexp is a pure-Go backtracking regular expression engine, selected
+// with -P. On top of the RE2 syntax it understands lookahead (?=...) (?!...),
+// lookbehind (?<=...) (?<!...), backreferences \1 \k<name> (?P=name), atomic
+// groups (?>...) and possessive quantifiers a*+. Matching is leftmost-first like
+// RE2, and indexes are byte offsets into the input, so it's interchangeable with
+// *regexp.Regexp behind the Matcher interface.
+type backtrackRegexp struct {
+	expr  string
+	prog  []btInst
+	nregs int      // Registers prog uses
+	names []string // Capture group names; index 0 is the whole match
+}
+
+// btOp identifies the kind of a node in a parsed backtracking pattern.
+type btOp int
+
+const (
+	btEmpty          btOp = iota // Matches the empty string
+	btLiteral                    // A single rune
+	btAnyChar                    // . (dotNL decides whether it matches \n)
+	btClass                      // [...], \d, \pL and friends
+	btBeginText                  // \A, or ^ without (?m)
+	btEndText                    // \z, or $ without (?m)
+	btEndTextNewline             // \Z: end of text or before a final \n
+	btBeginLine                  // ^ with (?m)
+	btEndLine                    // $ with (?m)
+	btWordBoundary               // \b
+	btNoWordBoundary             // \B
+	btConcat                     // subs in sequence
+	btAlternate                  // First sub that leads to an overall match
+	btCapture                    // Numbered group around subs[0]
+	btRepeat                     // subs[0] between min and max times
+	btLookahead                  // (?=...) or (?!...)
+	btLookbehind                 // (?<=...) or (?<!...)
+	btAtomic                     // (?>...): never backtracks into subs[0]
+	btBackref                    // Text previously captured by group
+)
+
+// btNode is one node of a parsed pattern.
+type btNode struct {
+	op       btOp
+	r        rune      // btLiteral
+	class    *btRunes  // btClass
+	subs     []*btNode // Children of concatenations, alternations and groups
+	min, max int       // btRepeat; max is -1 when unbounded
+	greedy   bool      // btRepeat
+	group    int       // btCapture, btBackref
+	negate   bool      // btLookahead, btLookbehind
+	fold     bool      // Case-insensitive btLiteral, btClass, btBackref
+	dotNL    bool      // btAnyChar matches \n
+	width    int       // btLookbehind: maximum width of subs[0] in runes, -1 if unbounded
+}
+
+// btRunes is a character class: a set of rune ranges and Unicode tables,
+// optionally negated.
+type btRunes struct {
+	ranges    []rune // Pairs of inclusive lo, hi
+	tables    []*unicode.RangeTable
+	notTables []*unicode.RangeTable // \P{...} inside a class
+	negated   bool
+}
+
+// btFlags are the inline flags in effect while parsing: (?i), (?m), (?s), (?U).
+type btFlags struct {
+	fold      bool
+	multiline bool
+	dotNL     bool
+	ungreedy  bool
+}
+
+// btParser turns pattern text into a btNode tree.
+type btParser struct {
+	src      string
+	pos      int
+	flags    btFlags
+	names    []string
+	refNames []string // Named backreferences, resolved once all groups are known
+	refNodes []*btNode
+	maxRef   int
+	depth    int // Groups open at pos
+}
+
+// compileBacktrack parses expr and compiles it for the backtracking engine.
+func compileBacktrack(expr string) (re *backtrackRegexp, err error) {
+	var p *btParser
+	var tree *btNode
+	var c btCompiler
+
+	p = &btParser{src: expr, names: []string{""}}
+	tree, err = p.parseAlternation()
+	if err != nil {
+		goto end
+	}
+	if p.pos < len(p.src) {
+		// Only an unbalanced ')' stops the top-level alternation early
+		err = p.errorf("unexpected )")
+		goto end
+	}
+	err = p.resolveRefs()
+	if err != nil {
+		goto end
+	}
+	err = c.compile(tree)
+	if err != nil {
+		err = p.errorf("%v", err)
+		goto end
+	}
+	c.emit(btInst{op: btiSucceed})
+	re = &backtrackRegexp{expr: expr, prog: c.prog, nregs: c.nregs, names: p.names}
+
+end:
+	return re, err
+}
+
+// errorf reports a syntax error in the same shape as regexp/syntax.
+func (p *btParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("error parsing regexp: %s: `%s`", fmt.Sprintf(format, args...), p.src)
+}
+
+// resolveRefs checks that every backreference names a group that exists.
+func (p *btParser) resolveRefs() (err error) {
+	if p.maxRef >= len(p.names) {
+		err = p.errorf("invalid backreference \\%d", p.maxRef)
+		goto end
+	}
+	for i, name := range p.refNames {
+		group := -1
+		for j, groupName := range p.names {
+			if groupName == name && j > 0 {
+				group = j
+				break
+			}
+		}
+		if group < 0 {
+			err = p.errorf("invalid named backreference %q", name)
+			goto end
+		}
+		p.refNodes[i].group = group
+	}
+
+end:
+	return err
+}
+
+// more reports whether there is input left to parse.
+func (p *btParser) more() bool {
+	return p.pos < len(p.src)
+}
+
+// peek returns the next rune without consuming it.
+func (p *btParser) peek() (r rune) {
+	r, _ = utf8.DecodeRuneInString(p.src[p.pos:])
+	return r
+}
+
+// next consumes and returns the next rune.
+func (p *btParser) next() (r rune) {
+	var size int
+
+	r, size = utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += size
+	return r
+}
+
+// consume skips prefix if the remaining input starts with it.
+func (p *btParser) consume(prefix string) (ok bool) {
+	ok = strings.HasPrefix(p.src[p.pos:], prefix)
+	if ok {
+		p.pos += len(prefix)
+	}
+	return ok
+}
+
+// parseAlternation parses branches separated by | up to a closing ) or the end.
+func (p *btParser) parseAlternation() (node *btNode, err error) {
+	var branches []*btNode
+	var branch *btNode
+
+	for {
+		branch, err = p.parseConcat()
+		if err != nil {
+			goto end
+		}
+		branches = append(branches, branch)
+		if !p.consume("|") {
+			break
+		}
+	}
+
+	node = branches[0]
+	if len(branches) > 1 {
+		node = &btNode{op: btAlternate, subs: branches}
+	}
+
+end:
+	return node, err
+}
+
+// parseConcat parses a sequence of quantified atoms up to | or ).
+func (p *btParser) parseConcat() (node *btNode, err error) {
+	var items []*btNode
+	var item *btNode
+
+	for p.more() && p.peek() != '|' && p.peek() != ')' {
+		if p.consume(`\Q`) {
+			// Quoted text is a run of literals, and a quantifier after it
+			// applies to the last one only, as in Perl
+			quoted := p.parseQuoted()
+			if len(quoted) == 0 {
+				continue
+			}
+			items = append(items, quoted[:len(quoted)-1]...)
+			item, err = p.parseQuantifiers(quoted[len(quoted)-1])
+		} else {
+			item, err = p.parseQuantified()
+		}
+		if err != nil {
+			goto end
+		}
+		if item != nil {
+			items = append(items, item)
+		}
+	}
+
+	switch len(items) {
+	case 0:
+		node = &btNode{op: btEmpty}
+	case 1:
+		node = items[0]
+	default:
+		node = &btNode{op: btConcat, subs: items}
+	}
+
+end:
+	return node, err
+}
+
+// parseQuantified parses an atom followed by any number of quantifiers. It
+// returns a nil node for constructs that only change flags, such as (?i).
+func (p *btParser) parseQuantified() (node *btNode, err error) {
+	if strings.ContainsRune("*+?", p.peek()) {
+		err = p.errorf("missing argument to repetition operator: `%c`", p.peek())
+		goto end
+	}
+
+	node, err = p.parseAtom()
+	if err != nil || node == nil {
+		goto end
+	}
+	node, err = p.parseQuantifiers(node)
+
+end:
+	return node, err
+}
+
+// parseQuoted parses the text after \Q up to \E or the end of the pattern into
+// one literal node per rune.
+func (p *btParser) parseQuoted() (nodes []*btNode) {
+	var end int
+
+	end = strings.Index(p.src[p.pos:], `\E`)
+	if end < 0 {
+		end = len(p.src) - p.pos
+	}
+	for _, lit := range p.src[p.pos : p.pos+end] {
+		nodes = append(nodes, p.literal(lit))
+	}
+	p.pos = min(len(p.src), p.pos+end+2)
+	return nodes
+}
+
+// parseQuantifiers wraps node in the quantifiers that follow it, if any.
+func (p *btParser) parseQuantifiers(atom *btNode) (node *btNode, err error) {
+	var min, max int
+	var ok bool
+	var quantified bool
+
+	node = atom
+	for p.more() {
+		switch p.peek() {
+		case '*':
+			p.next()
+			min, max = 0, -1
+		case '+':
+			p.next()
+			min, max = 1, -1
+		case '?':
+			p.next()
+			min, max = 0, 1
+		case '{':
+			min, max, ok = p.parseBraces()
+			if !ok {
+				// Not a repeat count: the brace is a literal, as in RE2
+				goto end
+			}
+			if (max >= 0 && max < min) || min > 1000 || max > 1000 {
+				err = p.errorf("invalid repeat count")
+				goto end
+			}
+		default:
+			goto end
+		}
+		if quantified {
+			err = p.errorf("invalid nested repetition operator")
+			goto end
+		}
+		quantified = true
+
+		node = &btNode{op: btRepeat, subs: []*btNode{node}, min: min, max: max, greedy: !p.flags.ungreedy}
+		switch {
+		case p.consume("?"):
+			node.greedy = !node.greedy
+		case p.consume("+"):
+			// Possessive: an atomic group around a greedy repeat
+			node.greedy = true
+			node = &btNode{op: btAtomic, subs: []*btNode{node}}
+		}
+	}
+
+end:
+	return node, err
+}
+
+// parseBraces parses a {n}, {n,} or {n,m} repeat count, leaving the range for
+// the caller to check. If the text at the current position isn't one, nothing
+// is consumed and ok is false.
+func (p *btParser) parseBraces() (min, max int, ok bool) {
+	var start int
+	var digits int
+
+	start = p.pos
+	p.pos++
+	min, digits = p.parseInt()
+	if digits == 0 {
+		goto fail
+	}
+	max = min
+	if p.consume(",") {
+		max, digits = p.parseInt()
+		if digits == 0 {
+			max = -1
+		}
+	}
+	if !p.consume("}") {
+		goto fail
+	}
+	ok = true
+	return min, max, ok
+
+fail:
+	p.pos = start
+	return 0, 0, false
+}
+
+// parseInt parses a run of decimal digits, returning the value and digit count.
+func (p *btParser) parseInt() (n, digits int) {
+	for p.more() && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		if n < 1e6 {
+			n = n*10 + int(p.src[p.pos]-'0')
+		}
+		p.pos++
+		digits++
+	}
+	return n, digits
+}
+
+// parseAtom parses a single literal, class, anchor, escape or group.
+func (p *btParser) parseAtom() (node *btNode, err error) {
+	var r rune
+
+	r = p.next()
+	switch r {
+	case '(':
+		node, err = p.parseGroup()
+	case '[':
+		node, err = p.parseClass()
+	case '.':
+		node = &btNode{op: btAnyChar, dotNL: p.flags.dotNL}
+	case '^':
+		node = &btNode{op: btBeginText}
+		if p.flags.multiline {
+			node.op = btBeginLine
+		}
+	case '$':
+		node = &btNode{op: btEndText}
+		if p.flags.multiline {
+			node.op = btEndLine
+		}
+	case '\\':
+		node, err = p.parseEscape()
+	default:
+		node = p.literal(r)
+	}
+	return node, err
+}
+
+// literal returns a node matching r, honouring (?i).
+func (p *btParser) literal(r rune) *btNode {
+	return &btNode{op: btLiteral, r: r, fold: p.flags.fold && unicode.SimpleFold(r) != r}
+}
+
+// parseGroup parses the rest of a group after its opening parenthesis.
+func (p *btParser) parseGroup() (node *btNode, err error) {
+	var saved btFlags
+	var inner *btNode
+	var name string
+
+	saved = p.flags
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxBacktrackNesting {
+		err = p.errorf("expression nests too deeply")
+		goto end
+	}
+
+	switch {
+	case p.consume("?<="):
+		node = &btNode{op: btLookbehind}
+	case p.consume("?<!"):
+		node = &btNode{op: btLookbehind, negate: true}
+	case p.consume("?P<"), p.consume("?<"):
+		name, err = p.parseGroupName('>')
+		if err != nil {
+			goto end
+		}
+		node = p.newCapture(name)
+	case p.consume("?'"):
+		name, err = p.parseGroupName('\'')
+		if err != nil {
+			goto end
+		}
+		node = p.newCapture(name)
+	case p.consume("?P="):
+		name, err = p.parseGroupName(')')
+		if err != nil {
+			goto end
+		}
+		node = p.namedRef(name)
+		goto end
+	case p.consume("?:"):
+		node = &btNode{op: btConcat}
+	case p.consume("?="):
+		node = &btNode{op: btLookahead}
+	case p.consume("?!"):
+		node = &btNode{op: btLookahead, negate: true}
+	case p.consume("?>"):
+		node = &btNode{op: btAtomic}
+	case p.consume("?"):
+		node, err = p.parseFlags(saved)
+		if err != nil || node == nil {
+			// A bare (?flags) applies to the rest of the enclosing group
+			goto end
+		}
+	default:
+		node = p.newCapture("")
+	}
+
+	inner, err = p.parseAlternation()
+	if err != nil {
+		goto end
+	}
+	if !p.consume(")") {
+		err = p.errorf("missing closing )")
+		goto end
+	}
+	p.flags = saved
+
+	node.subs = []*btNode{inner}
+	if node.op == btConcat {
+		node = inner
+	}
+	if node.op == btLookbehind {
+		node.width = maxWidth(inner)
+	}
+
+end:
+	return node, err
+}
+
+// parseFlags parses inline flags after "(?". For (?flags) it updates the parser
+// and returns a nil node; for (?flags:...) it returns a non-capturing group node
+// whose flags are restored to saved when the group closes.
+func (p *btParser) parseFlags(saved btFlags) (node *btNode, err error) {
+	var on = true
+	var r rune
+
+	for p.more() {
+		r = p.next()
+		switch r {
+		case 'i':
+			p.flags.fold = on
+		case 'm':
+			p.flags.multiline = on
+		case 's':
+			p.flags.dotNL = on
+		case 'U':
+			p.flags.ungreedy = on
+		case '-':
+			if !on {
+				goto bad
+			}
+			on = false
+		case ')':
+			goto end
+		case ':':
+			node = &btNode{op: btConcat}
+			goto end
+		default:
+			goto bad
+		}
+	}
+
+bad:
+	p.flags = saved
+	err = p.errorf("invalid or unsupported Perl syntax: `(?%c`", r)
+
+end:
+	return node, err
+}
+
+// parseGroupName reads a group name up to the terminator.
+func (p *btParser) parseGroupName(terminator byte) (name string, err error) {
+	var i int
+
+	i = strings.IndexByte(p.src[p.pos:], terminator)
+	if i < 1 {
+		err = p.errorf("invalid named capture")
+		goto end
+	}
+	name = p.src[p.pos : p.pos+i]
+	for j := 0; j < len(name); j++ {
+		if !isWordByte(name[j]) {
+			err = p.errorf("invalid named capture: `%s`", name)
+			goto end
+		}
+	}
+	p.pos += i + 1
+
+end:
+	return name, err
+}
+
+// newCapture allocates the next group number.
+func (p *btParser) newCapture(name string) *btNode {
+	p.names = append(p.names, name)
+	return &btNode{op: btCapture, group: len(p.names) - 1}
+}
+
+// namedRef returns a backreference to a named group, resolved after parsing
+// since Perl allows referring to a group defined later in the pattern.
+func (p *btParser) namedRef(name string) (node *btNode) {
+	node = &btNode{op: btBackref, fold: p.flags.fold}
+	p.refNames = append(p.refNames, name)
+	p.refNodes = append(p.refNodes, node)
+	return node
+}
+
+// parseEscape parses the rest of an escape sequence after its backslash.
+func (p *btParser) parseEscape() (node *btNode, err error) {
+	var r rune
+	var class *btRunes
+	var name string
+
+	if !p.more() {
+		err = p.errorf("trailing backslash at end of expression")
+		goto end
+	}
+
+	r = p.peek()
+	switch {
+	case r >= '1' && r <= '9':
+		n, _ := p.parseInt()
+		node = &btNode{op: btBackref, group: n, fold: p.flags.fold}
+		p.maxRef = max(p.maxRef, n)
+		goto end
+	case r == 'k':
+		p.next()
+		switch {
+		case p.consume("<"):
+			name, err = p.parseGroupName('>')
+		case p.consume("{"):
+			name, err = p.parseGroupName('}')
+		case p.consume("'"):
+			name, err = p.parseGroupName('\'')
+		default:
+			err = p.errorf("invalid escape sequence: `\\k`")
+		}
+		if err == nil {
+			node = p.namedRef(name)
+		}
+		goto end
+	}
+
+	p.next()
+	switch r {
+	case 'A':
+		node = &btNode{op: btBeginText}
+		goto end
+	case 'z':
+		node = &btNode{op: btEndText}
+		goto end
+	case 'Z':
+		node = &btNode{op: btEndTextNewline}
+		goto end
+	case 'b':
+		node = &btNode{op: btWordBoundary}
+		goto end
+	case 'B':
+		node = &btNode{op: btNoWordBoundary}
+		goto end
+	}
+
+	p.pos -= utf8.RuneLen(r)
+	class = &btRunes{}
+	r, err = p.parseClassEscape(class)
+	if err != nil {
+		goto end
+	}
+	if r < 0 {
+		node = &btNode{op: btClass, class: class, fold: p.flags.fold}
+		goto end
+	}
+	node = p.literal(r)
+
+end:
+	return node, err
+}
+
+// parseClassEscape parses an escape that is valid both inside and outside
+// brackets, positioned just after the backslash. Class shorthands like \d or
+// \pL are added to class and r is -1; anything else returns the literal rune.
+func (p *btParser) parseClassEscape(class *btRunes) (r rune, err error) {
+	var table *unicode.RangeTable
+	var negate bool
+
+	r = p.next()
+	switch r {
+	case 'd':
+		class.ranges = append(class.ranges, '0', '9')
+	case 'D':
+		class.ranges = append(class.ranges, negateRanges([]rune{'0', '9'})...)
+	case 'w':
+		class.ranges = append(class.ranges, wordRanges...)
+	case 'W':
+		class.ranges = append(class.ranges, negateRanges(wordRanges)...)
+	case 's':
+		class.ranges = append(class.ranges, spaceRanges...)
+	case 'S':
+		class.ranges = append(class.ranges, negateRanges(spaceRanges)...)
+	case 'p', 'P':
+		negate = r == 'P'
+		table, err = p.parseUnicodeClass()
+		if err != nil {
+			goto end
+		}
+		if negate {
+			class.notTables = append(class.notTables, table)
+		} else {
+			class.tables = append(class.tables, table)
+		}
+	case 'n':
+		r = '\n'
+		goto end
+	case 't':
+		r = '\t'
+		goto end
+	case 'r':
+		r = '\r'
+		goto end
+	case 'f':
+		r = '\f'
+		goto end
+	case 'v':
+		r = '\v'
+		goto end
+	case 'a':
+		r = '\a'
+		goto end
+	case 'e':
+		r = 0x1b
+		goto end
+	case 'x':
+		r, err = p.parseHex()
+		goto end
+	case '0':
+		// \0 is NUL, optionally followed by up to two more octal digits
+		r = 0
+		for i := 0; i < 2 && p.more() && p.src[p.pos] >= '0' && p.src[p.pos] <= '7'; i++ {
+			r = r*8 + rune(p.src[p.pos]-'0')
+			p.pos++
+		}
+		goto end
+	default:
+		if r < utf8.RuneSelf && !isWordByte(byte(r)) {
+			// Escaped punctuation stands for itself
+			goto end
+		}
+		err = p.errorf("invalid escape sequence: `\\%c`", r)
+		goto end
+	}
+	r = -1
+
+end:
+	return r, err
+}
+
+// parseHex parses the digits of \xHH or \x{HHHH}.
+func (p *btParser) parseHex() (r rune, err error) {
+	var digits string
+	var n uint64
+
+	if p.consume("{") {
+		i := strings.IndexByte(p.src[p.pos:], '}')
+		if i < 1 {
+			err = p.errorf("invalid escape sequence: `\\x`")
+			goto end
+		}
+		digits = p.src[p.pos : p.pos+i]
+		p.pos += i + 1
+	} else {
+		if len(p.src)-p.pos < 2 {
+			err = p.errorf("invalid escape sequence: `\\x`")
+			goto end
+		}
+		digits = p.src[p.pos : p.pos+2]
+		p.pos += 2
+	}
+
+	_, err = fmt.Sscanf(digits, "%x", &n)
+	if err != nil || n > unicode.MaxRune || len(digits) > 8 {
+		err = p.errorf("invalid escape sequence: `\\x%s`", digits)
+		goto end
+	}
+	r = rune(n)
+
+end:
+	return r, err
+}
+
+// parseUnicodeClass parses the name after \p or \P: a single letter (\pL) or
+// a braced category or script name (\p{Greek}, \p{Lu}).
+func (p *btParser) parseUnicodeClass() (table *unicode.RangeTable, err error) {
+	var name string
+	var ok bool
+
+	if p.consume("{") {
+		i := strings.IndexByte(p.src[p.pos:], '}')
+		if i < 1 {
+			err = p.errorf("invalid character class range")
+			goto end
+		}
+		name = p.src[p.pos : p.pos+i]
+		p.pos += i + 1
+	} else if p.more() {
+		name = string(p.next())
+	}
+
+	if table, ok = unicode.Categories[name]; ok {
+		goto end
+	}
+	if table, ok = unicode.Scripts[name]; ok {
+		goto end
+	}
+	if name == "Any" {
+		table = &unicode.RangeTable{R32: []unicode.Range32{{Lo: 0, Hi: unicode.MaxRune, Stride: 1}}}
+		goto end
+	}
+	err = p.errorf("invalid character class range: `\\p{%s}`", name)
+
+end:
+	return table, err
+}
+
+// parseClass parses a bracketed class after its opening [.
+func (p *btParser) parseClass() (node *btNode, err error) {
+	var class *btRunes
+	var lo, hi rune
+	var first = true
+
+	class = &btRunes{}
+	if p.consume("^") {
+		class.negated = true
+	}
+
+	for {
+		if !p.more() {
+			err = p.errorf("missing closing ]")
+			goto end
+		}
+		if p.peek() == ']' && !first {
+			p.next()
+			break
+		}
+		first = false
+
+		if p.consume("[:") {
+			err = p.parsePosixClass(class)
+			if err != nil {
+				goto end
+			}
+			continue
+		}
+
+		lo, err = p.parseClassRune(class)
+		if err != nil {
+			goto end
+		}
+		if lo < 0 {
+			continue
+		}
+		hi = lo
+		if strings.HasPrefix(p.src[p.pos:], "-") && !strings.HasPrefix(p.src[p.pos:], "-]") {
+			p.next()
+			hi, err = p.parseClassRune(class)
+			if err != nil {
+				goto end
+			}
+			if hi < lo {
+				err = p.errorf("invalid character class range")
+				goto end
+			}
+		}
+		class.ranges = append(class.ranges, lo, hi)
+	}
+
+	node = &btNode{op: btClass, class: class, fold: p.flags.fold}
+
+end:
+	return node, err
+}
+
+// parseClassRune parses one literal rune inside brackets. Shorthand escapes such
+// as \d are added to class directly and reported as -1.
+func (p *btParser) parseClassRune(class *btRunes) (r rune, err error) {
+	r = p.next()
+	if r != '\\' {
+		goto end
+	}
+	if !p.more() {
+		err = p.errorf("trailing backslash at end of expression")
+		goto end
+	}
+	r, err = p.parseClassEscape(class)
+
+end:
+	return r, err
+}
+
+// posixClasses are the ASCII classes usable as [[:name:]].
+var posixClasses = map[string][]rune{
+	"alnum":  {'0', '9', 'A', 'Z', 'a', 'z'},
+	"alpha":  {'A', 'Z', 'a', 'z'},
+	"ascii":  {0, 0x7f},
+	"blank":  {'\t', '\t', ' ', ' '},
+	"cntrl":  {0, 0x1f, 0x7f, 0x7f},
+	"digit":  {'0', '9'},
+	"graph":  {'!', '~'},
+	"lower":  {'a', 'z'},
+	"print":  {' ', '~'},
+	"punct":  {'!', '/', ':', '@', '[', '`', '{', '~'},
+	"space":  {'\t', '\r', ' ', ' '},
+	"upper":  {'A', 'Z'},
+	"word":   {'0', '9', 'A', 'Z', '_', '_', 'a', 'z'},
+	"xdigit": {'0', '9', 'A', 'F', 'a', 'f'},
+}
+
+// parsePosixClass parses the rest of [:name:] or [:^name:] inside brackets.
+func (p *btParser) parsePosixClass(class *btRunes) (err error) {
+	var i int
+	var name string
+	var ranges []rune
+	var ok bool
+
+	i = strings.Index(p.src[p.pos:], ":]")
+	if i < 0 {
+		err = p.errorf("invalid character class range")
+		goto end
+	}
+	name = p.src[p.pos : p.pos+i]
+	p.pos += i + 2
+
+	ranges, ok = posixClasses[strings.TrimPrefix(name, "^")]
+	if !ok {
+		err = p.errorf("invalid character class range: `[:%s:]`", name)
+		goto end
+	}
+	if strings.HasPrefix(name, "^") {
+		ranges = negateRanges(ranges)
+	}
+	class.ranges = append(class.ranges, ranges...)
+
+end:
+	return err
+}
+
+var (
+	wordRanges  = []rune{'0', '9', 'A', 'Z', '_', '_', 'a', 'z'}
+	spaceRanges = []rune{'\t', '\n', '\v', '\r', ' ', ' '}
+)
+
+// negateRanges returns the complement of sorted, non-overlapping rune ranges.
+func negateRanges(ranges []rune) (result []rune) {
+	var next rune
+
+	for i := 0; i < len(ranges); i += 2 {
+		if ranges[i] > next {
+			result = append(result, next, ranges[i]-1)
+		}
+		next = ranges[i+1] + 1
+	}
+	if next <= unicode.MaxRune {
+		result = append(result, next, unicode.MaxRune)
+	}
+	return result
+}
+
+// maxWidth returns the most runes node can match, or -1 if there is no bound.
+// Lookbehind uses it to limit how far back it has to try.
+func maxWidth(node *btNode) (width int) {
+	switch node.op {
+	case btLiteral, btAnyChar, btClass:
+		width = 1
+	case btConcat:
+		for _, sub := range node.subs {
+			w := maxWidth(sub)
+			if w < 0 {
+				return -1
+			}
+			width += w
+		}
+	case btAlternate:
+		for _, sub := range node.subs {
+			w := maxWidth(sub)
+			if w < 0 {
+				return -1
+			}
+			width = max(width, w)
+		}
+	case btCapture, btAtomic:
+		width = maxWidth(node.subs[0])
+	case btRepeat:
+		width = maxWidth(node.subs[0])
+		if width != 0 && (width < 0 || node.max < 0) {
+			return -1
+		}
+		width *= node.max
+	case btBackref:
+		width = -1
+	}
+	return width
+}
+
+// matches reports whether the class contains r, ignoring case if fold is set.
+func (c *btRunes) matches(r rune, fold bool) (in bool) {
+	in = c.contains(r)
+	if fold && !in {
+		for f := unicode.SimpleFold(r); f != r && !in; f = unicode.SimpleFold(f) {
+			in = c.contains(f)
+		}
+	}
+	return in != c.negated
+}
+
+// contains reports whether r is in the class, ignoring negation and case.
+func (c *btRunes) contains(r rune) bool {
+	for i := 0; i < len(c.ranges); i += 2 {
+		if c.ranges[i] <= r && r <= c.ranges[i+1] {
+			return true
+		}
+	}
+	for _, table := range c.tables {
+		if unicode.Is(table, r) {
+			return true
+		}
+	}
+	for _, table := range c.notTables {
+		if !unicode.Is(table, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the source text of the pattern.
+func (re *backtrackRegexp) String() string {
+	return re.expr
+}
+
+// SubexpNames returns the capture group names, "" for unnamed groups.
+func (re *backtrackRegexp) SubexpNames() []string {
+	return re.names
+}
+
+// FindAllStringSubmatchIndex returns the indexes of up to n successive
+// non-overlapping matches in s (all if n < 0), with the same layout and
+// empty-match rules as the regexp package. If the step budget runs out it
+// returns the matches found until then; findAll also reports that.
+func (re *backtrackRegexp) FindAllStringSubmatchIndex(s string, n int) (result [][]int) {
+	result, _ = re.findAll(s, n)
+	return result
+}
+
+// findAll is FindAllStringSubmatchIndex, returning errBacktrackLimit when the
+// step budget ran out before all of s was searched.
+func (re *backtrackRegexp) findAll(s string, n int) (result [][]int, err error) {
+	var m *btMachine
+	var pos int
+	var prevEnd = -1
+	var end int
+	var matched bool
+
+	m = &btMachine{
+		prog:  re.prog,
+		input: s,
+		caps:  make([]int, 2*len(re.names)),
+		regs:  make([]int, re.nregs),
+	}
+	for pos <= len(s) && (n < 0 || len(result) < n) {
+		for i := range m.caps {
+			m.caps[i] = -1
+		}
+		m.stack = m.stack[:0]
+		end, matched = m.run(0, pos, -1)
+		if m.err != nil {
+			err = m.err
+			break
+		}
+		// An empty match right after the previous match doesn't count
+		if !matched || (end == pos && pos == prevEnd) {
+			pos += runeWidth(s, pos)
+			continue
+		}
+
+		m.caps[0], m.caps[1] = pos, end
+		result = append(result, append([]int(nil), m.caps...))
+		prevEnd = end
+		if end > pos {
+			pos = end
+		} else {
+			pos += runeWidth(s, pos)
+		}
+	}
+	return result, err
+}
+
+// runeWidth returns the size of the rune at pos, or 1 at the end of s so that
+// a search loop still advances past it.
+func runeWidth(s string, pos int) (size int) {
+	size = 1
+	if pos < len(s) {
+		_, size = utf8.DecodeRuneInString(s[pos:])
+	}
+	return size
+}
+
+// btInstOp identifies the kind of an instruction of a compiled pattern.
+type btInstOp uint8
+
+const (
+	btiRune       btInstOp = iota // Match one rune with node (a literal, . or class)
+	btiRuneRepeat                 // node min to max times, giving back one rune at a time
+	btiAssert                     // Zero-width test node.op: an anchor or word boundary
+	btiSplit                      // Continue at x, or at y if that fails
+	btiJmp                        // Continue at x
+	btiSetReg                     // Remember pos in register reg
+	btiGroupEnd                   // Record group n as running from register reg to pos
+	btiIterEnd                    // End of a loop iteration that started at register reg
+	btiBackref                    // Text last captured by node.group
+	btiLook                       // Lookaround node with its sub-program at pc+1, then x
+	btiAtomic                     // First match of the sub-program at pc+1, then x
+	btiSucceed                    // End of the pattern or of a sub-program
+)
+
+// btEmptyMode decides what an empty iteration of an unbounded loop does. Like
+// RE2, only the first iteration may match nothing, which keeps (a*)* finite.
+// Bounded repeats are unrolled into copies, each of which may match nothing.
+type btEmptyMode uint8
+
+const (
+	btEmptyFails      btEmptyMode = iota // It fails: not iterating was tried too
+	btEmptyExitsFirst                    // It ends the loop if it was the first, else fails
+)
+
+// btInst is one instruction of a compiled pattern.
+type btInst struct {
+	op       btInstOp
+	node     *btNode // btiRune, btiRuneRepeat, btiAssert, btiBackref, btiLook
+	x, y     int     // Branch targets
+	n        int     // btiGroupEnd: group; btiIterEnd: register holding where the loop started
+	reg      int     // btiSetReg, btiGroupEnd, btiIterEnd
+	min, max int     // btiRuneRepeat; max is -1 when unbounded
+	greedy   bool    // btiRuneRepeat
+	empty    btEmptyMode
+}
+
+// maxBacktrackProg bounds the size of a compiled pattern. Counted repeats are
+// expanded, so nesting them could otherwise take unbounded memory.
+const maxBacktrackProg = 100_000
+
+// btCompiler turns a btNode tree into a program for btMachine.
+type btCompiler struct {
+	prog  []btInst
+	nregs int
+}
+
+// emit appends an instruction and returns its index.
+func (c *btCompiler) emit(inst btInst) int {
+	c.prog = append(c.prog, inst)
+	return len(c.prog) - 1
+}
+
+// newReg allocates a register.
+func (c *btCompiler) newReg() int {
+	c.nregs++
+	return c.nregs - 1
+}
+
+// compile appends the code for node.
+func (c *btCompiler) compile(node *btNode) (err error) {
+	var jumps []int
+
+	if len(c.prog) > maxBacktrackProg {
+		return fmt.Errorf("expression too large")
+	}
+
+	switch node.op {
+	case btEmpty:
+	case btLiteral, btAnyChar, btClass:
+		c.emit(btInst{op: btiRune, node: node})
+	case btBeginText, btEndText, btEndTextNewline, btBeginLine, btEndLine, btWordBoundary, btNoWordBoundary:
+		c.emit(btInst{op: btiAssert, node: node})
+	case btConcat:
+		for _, sub := range node.subs {
+			if err = c.compile(sub); err != nil {
+				break
+			}
+		}
+	case btAlternate:
+		for i, sub := range node.subs {
+			if i == len(node.subs)-1 {
+				err = c.compile(sub)
+				break
+			}
+			split := c.emit(btInst{op: btiSplit})
+			c.prog[split].x = split + 1
+			if err = c.compile(sub); err != nil {
+				break
+			}
+			jumps = append(jumps, c.emit(btInst{op: btiJmp}))
+			c.prog[split].y = len(c.prog)
+		}
+		for _, jump := range jumps {
+			c.prog[jump].x = len(c.prog)
+		}
+	case btCapture:
+		// The start is only recorded once the group ends, so a backreference
+		// inside the group still sees the previous iteration's text
+		reg := c.newReg()
+		c.emit(btInst{op: btiSetReg, reg: reg})
+		if err = c.compile(node.subs[0]); err == nil {
+			c.emit(btInst{op: btiGroupEnd, n: node.group, reg: reg})
+		}
+	case btRepeat:
+		err = c.compileRepeat(node)
+	case btLookahead, btLookbehind, btAtomic:
+		op := btiLook
+		if node.op == btAtomic {
+			op = btiAtomic
+		}
+		at := c.emit(btInst{op: op, node: node})
+		if err = c.compile(node.subs[0]); err == nil {
+			c.emit(btInst{op: btiSucceed})
+			c.prog[at].x = len(c.prog)
+		}
+	case btBackref:
+		c.emit(btInst{op: btiBackref, node: node})
+	}
+	return err
+}
+
+// compileRepeat appends the code for a repeat: a single instruction for a
+// repeated rune like .* or \w+, and otherwise the required copies of the body
+// followed by a loop (unbounded) or a chain of optional copies (bounded). Only
+// loops over bodies that can match nothing need the empty iteration check; as in
+// RE2 an optional copy may match nothing, so (a*){1,2} ends with an empty group.
+func (c *btCompiler) compileRepeat(node *btNode) (err error) {
+	var sub *btNode
+	var checkEmpty bool
+	var entry int
+	var iter int
+	var loop int
+	var mode btEmptyMode
+	var splits []int
+	var ends []int
+	var exit int
+
+	sub = node.subs[0]
+	if isSingleRune(sub) {
+		c.emit(btInst{op: btiRuneRepeat, node: sub, min: node.min, max: node.max, greedy: node.greedy})
+		return nil
+	}
+	for range node.min {
+		if err = c.compile(sub); err != nil {
+			return err
+		}
+	}
+	checkEmpty = minWidth(sub) == 0
+
+	if node.max < 0 {
+		mode = btEmptyFails
+		if checkEmpty && node.min == 0 {
+			mode, entry = btEmptyExitsFirst, c.newReg()
+			c.emit(btInst{op: btiSetReg, reg: entry})
+		}
+		if checkEmpty {
+			iter = c.newReg()
+		}
+		loop = c.emit(btInst{op: btiSplit})
+		if checkEmpty {
+			c.emit(btInst{op: btiSetReg, reg: iter})
+		}
+		if err = c.compile(sub); err != nil {
+			return err
+		}
+		if checkEmpty {
+			ends = append(ends, c.emit(btInst{op: btiIterEnd, reg: iter, n: entry, x: loop, empty: mode}))
+		} else {
+			c.emit(btInst{op: btiJmp, x: loop})
+		}
+		splits = append(splits, loop)
+	} else {
+		for i := node.min; i < node.max; i++ {
+			splits = append(splits, c.emit(btInst{op: btiSplit}))
+			if err = c.compile(sub); err != nil {
+				return err
+			}
+		}
+	}
+
+	exit = len(c.prog)
+	for _, split := range splits {
+		if node.greedy {
+			c.prog[split].x, c.prog[split].y = split+1, exit
+		} else {
+			c.prog[split].x, c.prog[split].y = exit, split+1
+		}
+	}
+	for _, end := range ends {
+		c.prog[end].y = exit
+	}
+	return nil
+}
+
+// minWidth returns the fewest runes node can match.
+func minWidth(node *btNode) (width int) {
+	switch node.op {
+	case btLiteral, btAnyChar, btClass:
+		width = 1
+	case btConcat:
+		for _, sub := range node.subs {
+			width += minWidth(sub)
+		}
+	case btAlternate:
+		width = minWidth(node.subs[0])
+		for _, sub := range node.subs[1:] {
+			width = min(width, minWidth(sub))
+		}
+	case btCapture, btAtomic:
+		width = minWidth(node.subs[0])
+	case btRepeat:
+		width = minWidth(node.subs[0]) * node.min
+	}
+	return width
+}
+
+// errBacktrackLimit is returned when matching a line takes more steps than
+// maxBacktrackSteps, rather than silently returning fewer matches.
+var errBacktrackLimit = fmt.Errorf("pattern needs too much backtracking (more than %d steps); simplify it or search without -P", maxBacktrackSteps)
+
+// btEntryKind identifies the kind of a backtrack stack entry.
+type btEntryKind uint8
+
+const (
+	btEntryBranch   btEntryKind = iota // Resume at pc, pos
+	btEntryCap                         // Undo: caps[pc] was old
+	btEntryReg                         // Undo: regs[pc] was old
+	btEntryGiveBack                    // Greedy rune repeat at pos, may give back runes down to old
+	btEntryTakeMore                    // Lazy rune repeat of instruction pc at pos, old runes so far
+)
+
+// btEntry is a backtrack stack entry: a choice point to resume from, or a
+// change to undo on the way back to one. It is kept small because a long
+// input can leave one or two entries per character on the stack.
+type btEntry struct {
+	kind btEntryKind
+	pc   int32
+	pos  int
+	old  int
+}
+
+// btMachine holds the state of one findAll call. It runs a compiled pattern
+// with an explicit stack of choice points on the heap, so the length of the
+// input doesn't affect the depth of Go calls; only lookaround and atomic groups
+// nest, as deeply as the pattern does.
+type btMachine struct {
+	prog  []btInst
+	input string
+	caps  []int
+	regs  []int
+	stack []btEntry
+	steps int
+	err   error
+}
+
+// run executes the program from pc at pos until a btiSucceed accepts, and
+// returns the position it was reached at. If wantEnd isn't negative only a
+// match ending there is accepted. After a match the entries run pushed stay on
+// the stack for the caller to backtrack into, keep or discard; after a failure
+// the stack is back as it was.
+func (m *btMachine) run(pc, pos, wantEnd int) (end int, ok bool) {
+	var base int
+	var inst *btInst
+	var failed bool
+
+	base = len(m.stack)
+	for {
+		if m.step() {
+			m.unwind(base)
+			return -1, false
+		}
+
+		inst = &m.prog[pc]
+		failed = false
+		switch inst.op {
+		case btiRune:
+			size := m.matchOne(inst.node, pos)
+			failed = size == 0
+			pc, pos = pc+1, pos+size
+		case btiRuneRepeat:
+			pos, failed = m.runeRepeat(pc, pos)
+			pc++
+		case btiAssert:
+			failed = !m.assert(inst.node.op, pos)
+			pc++
+		case btiSplit:
+			m.push(btEntry{kind: btEntryBranch, pc: int32(inst.y), pos: pos})
+			pc = inst.x
+		case btiJmp:
+			pc = inst.x
+		case btiSetReg:
+			m.push(btEntry{kind: btEntryReg, pc: int32(inst.reg), old: m.regs[inst.reg]})
+			m.regs[inst.reg] = pos
+			pc++
+		case btiGroupEnd:
+			m.setCap(2*inst.n, m.regs[inst.reg])
+			m.setCap(2*inst.n+1, pos)
+			pc++
+		case btiIterEnd:
+			pc, failed = m.iterEnd(inst, pos)
+		case btiBackref:
+			size, matched := m.matchBackref(inst.node, pos)
+			failed = !matched
+			pc, pos = pc+1, pos+size
+		case btiLook:
+			failed = !m.lookaround(inst, pc, pos)
+			pc = inst.x
+		case btiAtomic:
+			lookBase := len(m.stack)
+			var matched bool
+			pos, matched = m.run(pc+1, pos, -1)
+			failed = !matched
+			if matched {
+				m.cut(lookBase)
+			}
+			pc = inst.x
+		case btiSucceed:
+			if wantEnd < 0 || pos == wantEnd {
+				return pos, true
+			}
+			failed = true
+		}
+
+		if failed {
+			if pc, pos, ok = m.backtrack(base); !ok {
+				return -1, false
+			}
+		}
+	}
+}
+
+// step counts one step against the budget, reporting true once it is spent.
+func (m *btMachine) step() (exhausted bool) {
+	m.steps++
+	if m.steps > maxBacktrackSteps && m.err == nil {
+		m.err = errBacktrackLimit
+	}
+	return m.err != nil
+}
+
+// push adds an entry to the backtrack stack.
+func (m *btMachine) push(e btEntry) {
+	m.stack = append(m.stack, e)
+}
+
+// setCap sets a capture slot, recording how to undo it.
+func (m *btMachine) setCap(slot, value int) {
+	m.push(btEntry{kind: btEntryCap, pc: int32(slot), old: m.caps[slot]})
+	m.caps[slot] = value
+}
+
+// backtrack pops entries down to base, undoing the changes they record, until
+// it finds a choice point, and returns where to resume.
+func (m *btMachine) backtrack(base int) (pc, pos int, ok bool) {
+	var e btEntry
+	var inst *btInst
+	var size int
+
+	for len(m.stack) > base {
+		e = m.stack[len(m.stack)-1]
+		m.stack = m.stack[:len(m.stack)-1]
+		switch e.kind {
+		case btEntryCap:
+			m.caps[e.pc] = e.old
+		case btEntryReg:
+			m.regs[e.pc] = e.old
+		case btEntryBranch:
+			return int(e.pc), e.pos, true
+		case btEntryGiveBack:
+			_, size = utf8.DecodeLastRuneInString(m.input[e.old:e.pos])
+			e.pos -= size
+			if e.pos > e.old {
+				m.push(e)
+			}
+			return int(e.pc), e.pos, true
+		case btEntryTakeMore:
+			inst = &m.prog[e.pc]
+			if inst.max >= 0 && e.old >= inst.max {
+				continue
+			}
+			if size = m.matchOne(inst.node, e.pos); size == 0 {
+				continue
+			}
+			e.pos, e.old = e.pos+size, e.old+1
+			m.push(e)
+			return int(e.pc) + 1, e.pos, true
+		}
+	}
+	return 0, 0, false
+}
+
+// unwind pops entries down to base, undoing their changes and dropping choice
+// points: everything a sub-match did is forgotten.
+func (m *btMachine) unwind(base int) {
+	for len(m.stack) > base {
+		e := m.stack[len(m.stack)-1]
+		m.stack = m.stack[:len(m.stack)-1]
+		switch e.kind {
+		case btEntryCap:
+			m.caps[e.pc] = e.old
+		case btEntryReg:
+			m.regs[e.pc] = e.old
+		}
+	}
+}
+
+// cut drops the choice points above base but keeps the undo records, so a
+// sub-match can't be backtracked into while what it captured is still undone
+// if the match fails further out. That is what atomic groups and lookaround do.
+func (m *btMachine) cut(base int) {
+	var kept int
+
+	kept = base
+	for _, e := range m.stack[base:] {
+		if e.kind == btEntryCap || e.kind == btEntryReg {
+			m.stack[kept] = e
+			kept++
+		}
+	}
+	m.stack = m.stack[:kept]
+}
+
+// runeRepeat matches a repeated single rune at pos. Greedy repeats take as many
+// runes as they can and push an entry that gives them back one at a time; lazy
+// ones take the minimum and push an entry that takes one more each time.
+func (m *btMachine) runeRepeat(pc, pos int) (end int, failed bool) {
+	var inst *btInst
+	var count int
+	var lo int
+	var size int
+
+	inst = &m.prog[pc]
+	end = pos
+	for ; count < inst.min || (inst.greedy && (inst.max < 0 || count < inst.max)); count++ {
+		if count == inst.min {
+			lo = end
+		}
+		if size = m.matchOne(inst.node, end); size == 0 {
+			break
+		}
+		end += size
+	}
+	if count < inst.min {
+		return end, true
+	}
+	if count == inst.min {
+		lo = end
+	}
+
+	switch {
+	case !inst.greedy:
+		m.push(btEntry{kind: btEntryTakeMore, pc: int32(pc), pos: end, old: count})
+	case end > lo:
+		m.push(btEntry{kind: btEntryGiveBack, pc: int32(pc + 1), pos: end, old: lo})
+	}
+	return end, false
+}
+
+// iterEnd ends an iteration of a loop: the loop goes on at inst.x unless the
+// iteration matched nothing, which inst.empty handles.
+func (m *btMachine) iterEnd(inst *btInst, pos int) (pc int, failed bool) {
+	if pos != m.regs[inst.reg] {
+		return inst.x, false
+	}
+	if inst.empty == btEmptyExitsFirst {
+		// Only the first iteration can start where the loop did
+		return inst.y, m.regs[inst.reg] != m.regs[inst.n]
+	}
+	return 0, true
+}
+
+// lookaround reports whether the assertion at pc holds at pos (or, negated,
+// doesn't). Captures set by a positive assertion are kept, those of a negative
+// one discarded.
+func (m *btMachine) lookaround(inst *btInst, pc, pos int) (holds bool) {
+	var base int
+	var matched bool
+
+	base = len(m.stack)
+	if inst.node.op == btLookahead {
+		_, matched = m.run(pc+1, pos, -1)
+	} else {
+		matched = m.matchBehind(inst, pc, pos)
+	}
+	if m.err != nil {
+		return false
+	}
+	if matched {
+		if inst.node.negate {
+			m.unwind(base)
+		} else {
+			m.cut(base)
+		}
+	}
+	return matched != inst.node.negate
+}
+
+// matchBehind reports whether the lookbehind's body matches text ending exactly
+// at pos, trying the closest start positions first.
+func (m *btMachine) matchBehind(inst *btInst, pc, pos int) bool {
+	var limit int
+
+	if inst.node.width >= 0 {
+		limit = max(0, pos-inst.node.width*utf8.UTFMax)
+	}
+	for start := pos; start >= limit; start-- {
+		if start < len(m.input) && start < pos && !utf8.RuneStart(m.input[start]) {
+			continue
+		}
+		if _, ok := m.run(pc+1, start, pos); ok {
+			return true
+		}
+		if m.err != nil {
+			break
+		}
+	}
+	return false
+}
+
+// assert tests a zero-width assertion at pos.
+func (m *btMachine) assert(op btOp, pos int) bool {
+	switch op {
+	case btBeginText:
+		return pos == 0
+	case btEndText:
+		return pos == len(m.input)
+	case btEndTextNewline:
+		return pos == len(m.input) || (pos == len(m.input)-1 && m.input[pos] == '\n')
+	case btBeginLine:
+		return pos == 0 || m.input[pos-1] == '\n'
+	case btEndLine:
+		return pos == len(m.input) || m.input[pos] == '\n'
+	case btWordBoundary, btNoWordBoundary:
+		return m.atWordBoundary(pos) == (op == btWordBoundary)
+	}
+	return false
+}
+
+// matchOne matches a single-rune node at pos, returning the number of bytes it
+// consumed or 0 if it doesn't match.
+func (m *btMachine) matchOne(node *btNode, pos int) (size int) {
+	var r rune
+	var ok bool
+
+	if pos >= len(m.input) {
+		return 0
+	}
+	r, size = utf8.DecodeRuneInString(m.input[pos:])
+	switch node.op {
+	case btLiteral:
+		ok = r == node.r || (node.fold && equalFoldRune(r, node.r))
+	case btAnyChar:
+		ok = r != '\n' || node.dotNL
+	case btClass:
+		ok = node.class.matches(r, node.fold)
+	}
+	if !ok {
+		size = 0
+	}
+	return size
+}
+
+// matchBackref matches the text last captured by the group at pos, returning
+// its length in the input. A group that hasn't taken part in the match makes
+// the backreference fail, as in Perl.
+func (m *btMachine) matchBackref(node *btNode, pos int) (size int, ok bool) {
+	start, end := m.caps[2*node.group], m.caps[2*node.group+1]
+	if start < 0 {
+		return 0, false
+	}
+	captured := m.input[start:end]
+	if !node.fold {
+		return len(captured), strings.HasPrefix(m.input[pos:], captured)
+	}
+
+	// Case-folded text can differ in byte length, so compare rune by rune
+	p := pos
+	for _, want := range captured {
+		if p >= len(m.input) {
+			return 0, false
+		}
+		r, size := utf8.DecodeRuneInString(m.input[p:])
+		if r != want && !equalFoldRune(r, want) {
+			return 0, false
+		}
+		p += size
+	}
+	return p - pos, true
+}
+
+// atWordBoundary reports whether pos sits between a word and a non-word byte.
+func (m *btMachine) atWordBoundary(pos int) bool {
+	before := pos > 0 && isWordByte(m.input[pos-1])
+	after := pos < len(m.input) && isWordByte(m.input[pos])
+	return before != after
+}
+
+// isSingleRune reports whether node always matches exactly one rune without
+// side effects, which lets repeats of it skip general backtracking.
+func isSingleRune(node *btNode) bool {
+	return node.op == btLiteral || node.op == btAnyChar || node.op == btClass
+}
+
+// equalFoldRune reports whether a and b are equal under simple case folding.
+func equalFoldRune(a, b rune) bool {
+	for f := unicode.SimpleFold(a); f != a; f = unicode.SimpleFold(f) {
+		if f == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestBacktrackMatchesRE2 checks that on syntax both engines accept, the
+// backtracking engine finds the same matches and groups as regexp.
+func TestBacktrackMatchesRE2(t *testing.T) {
+	patterns := []string{
+		`a`, `abc`, `a.c`, `(?s)a.c`, `a*`, `a+`, `a?`, `a*?`, `a+?`, `a??`,
+		`a{2}`, `a{2,}`, `a{2,3}`, `a{0,2}?`, `x{1000}`, `(a)(b)?`, `(a|ab)(c|bcd)`,
+		`(a*)*`, `(a*)+`, `(a|)+`, `(a?)*b`, `(?:ab)*c`, `(?:a|b)*?c`, `[a-c]+`,
+		`[^a-c]+`, `[[:alpha:]]+`, `\d+\.\d*`, `\w+@\w+\.com`, `\bfoo\b`, `\Bo\B`,
+		`^a`, `a$`, `(?m)^\w+$`, `\Aab`, `ab\z`, `(?i)héllo`, `(?i)[a-z]+`,
+		`\pL+`, `\p{Greek}+`, `\PL+`, `[\d\s]+`, `(?U)a+`, `(?P<word>\w+)\s(?P<num>\d+)`,
+		`(a(b(c)))`, `(?:(a)|b)+`, `.*`, `.+?x`, `[\x{100}-\x{200}]`, `\x41`,
+		`(foo|foobar)bar`, `(?:x*)*`, `a\.b`, `\Qa.b\E+`, `\Qa*\E`,
+		`(a*){1,2}`, `(a*){2,3}`, `(a*){0,2}`, `(a|){1,3}`, `(a?){2}`, `(a*){1,2}?`,
+		`(a*?){1,2}`, `(?:(a)|b*){1,3}`, `(b|a*){2}c`, `(a*)+?`, `(a|b*)*c`,
+	}
+	inputs := []string{
+		"", "a", "aa", "aaa", "abc", "abcd", "aXc a\nc", "abab abc ababc", "aab bcd abcd",
+		"foo foobar foobarbar", "x" + strings.Repeat("x", 1000), "héllo HÉLLO Héllo",
+		"12.5 and 3. and .7", "mail bob@example.com now", "ab\ncd\nef", "αβγ abc δ",
+		"a.b a.ba.b axb", "a* aa*", "xxy xy y", "ĀĒ ż", "A b",
+	}
+
+	for _, pattern := range patterns {
+		want := regexp.MustCompile(pattern)
+		got, err := compileBacktrack(pattern)
+		if err != nil {
+			t.Errorf("compileBacktrack(%q): %v", pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.SubexpNames(), want.SubexpNames()) {
+			t.Errorf("%q: SubexpNames() = %q, want %q", pattern, got.SubexpNames(), want.SubexpNames())
+		}
+		for _, input := range inputs {
+			gotFound, err := got.findAll(input, -1)
+			wantFound := want.FindAllStringSubmatchIndex(input, -1)
+			if err != nil || !reflect.DeepEqual(gotFound, wantFound) {
+				t.Errorf("%q on %q: got %v, %v; want %v", pattern, input, gotFound, err, wantFound)
+			}
+		}
+	}
+}
+
+// TestBacktrackExtensions covers the syntax only the backtracking engine has.
+func TestBacktrackExtensions(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    [][]int
+	}{
+		// Lookahead and lookbehind
+		{`foo(?=bar)`, "foobaz foobar", [][]int{{7, 10}}},
+		{`foo(?!bar)`, "foobar foobaz", [][]int{{7, 10}}},
+		{`(?<=\$)\d+`, "12 $34 5$6", [][]int{{4, 6}, {9, 10}}},
+		{`(?<!\$)\b\d+`, "12 $34", [][]int{{0, 2}}},
+		{`(?<=ab|c)x`, "abx cx bx", [][]int{{2, 3}, {5, 6}}},
+		{`(?<=é)x`, "éx ex", [][]int{{2, 3}}},
+		{`(?=(\w+))\w`, "ab", [][]int{{0, 1, 0, 2}, {1, 2, 1, 2}}},
+		{`(?!(a))\w`, "ab", [][]int{{1, 2, -1, -1}}},
+		// Backreferences
+		{`(\w)\1`, "abccd eef", [][]int{{2, 4, 2, 3}, {6, 8, 6, 7}}},
+		{`(?P<q>['"]).*?\k<q>`, `say "hi" or 'yo'`, [][]int{{4, 8, 4, 5}, {12, 16, 12, 13}}},
+		{`(?i)(a)\1`, "aA", [][]int{{0, 2, 0, 1}}},
+		{`(a)|\1b`, "b", nil},
+		{`(a\1?)+`, "aaa", [][]int{{0, 3, 1, 3}}},
+		// Atomic groups and possessive quantifiers never give back
+		{`(?>a+)a`, "aaa", nil},
+		{`a++a`, "aaa", nil},
+		{`a*+b`, "aab", [][]int{{0, 3}}},
+		{`(?:ab)++c`, "ababc abab", [][]int{{0, 5}}},
+		{`(?>(a)|ab)c`, "abc", nil},
+		// Quoted text takes quantifiers on its last rune only
+		{`\Qa.b\E+`, "a.ba.b a.bb", [][]int{{0, 3}, {3, 6}, {7, 11}}},
+	}
+
+	for _, tt := range tests {
+		re, err := compileBacktrack(tt.pattern)
+		if err != nil {
+			t.Errorf("compileBacktrack(%q): %v", tt.pattern, err)
+			continue
+		}
+		got, err := re.findAll(tt.input, -1)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q on %q: got %v, %v; want %v", tt.pattern, tt.input, got, err, tt.want)
+		}
+	}
+}
+
+// TestBacktrackLongInput checks that matching doesn't grow the Go stack with
+// the input: these used to crash with a fatal stack overflow.
+func TestBacktrackLongInput(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+	}{
+		{`(?:ab)*c`, strings.Repeat("ab", 500_000) + "c"},
+		{`(?:\w+\s)*X`, strings.Repeat("a b ", 250_000) + "X"},
+		{`(ab)+?c`, strings.Repeat("ab", 200_000) + "c"},
+		{`(?:ab|\n)*c`, strings.Repeat(strings.Repeat("ab", 50)+"\n", 50_000) + "c"},
+		{`(?:(?=a)ab)*c`, strings.Repeat("ab", 200_000) + "c"},
+	}
+
+	for _, tt := range tests {
+		re, err := compileBacktrack(tt.pattern)
+		if err != nil {
+			t.Fatalf("compileBacktrack(%q): %v", tt.pattern, err)
+		}
+		got, err := re.findAll(tt.input, -1)
+		want := [][]int{{0, len(tt.input)}}
+		if strings.Contains(tt.pattern, "(ab)") {
+			want[0] = append(want[0], len(tt.input)-3, len(tt.input)-1)
+		}
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%q on %d bytes: got %v, %v; want %v", tt.pattern, len(tt.input), got, err, want)
+		}
+	}
+}
+
+// TestBacktrackLimit checks that running out of steps is an error rather than
+// fewer matches.
+func TestBacktrackLimit(t *testing.T) {
+	re, err := compileBacktrack(`(a|a)+b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = re.findAll(strings.Repeat("a", 40), -1)
+	if !errors.Is(err, errBacktrackLimit) {
+		t.Errorf("findAll error = %v, want errBacktrackLimit", err)
+	}
+	_, err = findAll(re, strings.Repeat("a", 40), -1)
+	if !errors.Is(err, errBacktrackLimit) {
+		t.Errorf("findAll via Matcher error = %v, want errBacktrackLimit", err)
+	}
+}
+
+// TestBacktrackSyntaxErrors checks that invalid patterns are rejected.
+func TestBacktrackSyntaxErrors(t *testing.T) {
+	for _, pattern := range []string{
+		`(`, `)`, `a**`, `*a`, `[a`, `\2(a)`, `(?<n>a)\k<m>`, `\p{Nope}`, `a{2,1}`, `x{1001}`,
+		`(?z)`, `\`, `x\Q\E*`, strings.Repeat("(", 1001) + strings.Repeat(")", 1001), `((a{1000}){1000}){1000}`,
+	} {
+		if _, err := compileBacktrack(pattern); err == nil {
+			t.Errorf("compileBacktrack(%q) succeeded, want an error", pattern)
+		}
+	}
+}
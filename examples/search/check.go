@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// checkRule is one rule of a rules file: a pattern that must not appear in the
+// files it applies to.
+type checkRule struct {
+	ID       string   `json:"id"`
+	Regex    string   `json:"regex"`
+	Include  globList `json:"include"`  // Only files matching one of these; all files if empty
+	Exclude  globList `json:"exclude"`  // Never files matching one of these
+	Severity string   `json:"severity"` // error (the default), warning or note
+	Message  string   `json:"message"`
+
+	pattern Matcher
+}
+
+// checkRulesFile is the content of a rules file.
+type checkRulesFile struct {
+	Rules []checkRule `json:"rules"`
+}
+
+// globList is a list of globs that may also be written as a single string.
+type globList []string
+
+// ruleIDPattern is what rule IDs look like, so they can be listed in comments.
+var ruleIDPattern = regexp.MustCompile(`^[A-Za-z0-9][\w.-]*$`)
+
+// suppressPattern finds a suppression comment and the rule IDs it lists, e.g.
+// "// search:ignore no-println, todo-ticket".
+var suppressPattern = regexp.MustCompile(`search:ignore\b(?:[ \t]+([A-Za-z0-9][\w.-]*(?:[ \t]*,[ \t]*[A-Za-z0-9][\w.-]*)*))?`)
+
+// runCheck implements `search check`: it searches the paths (the current
+// directory by default) for every rule of a rules file at once and reports each
+// violation, failing if there were any.
+//
+// Rules files are JSON when their name ends in .json and YAML otherwise:
+//
+//	rules:
+//	  - id: no-println
+//	    regex: 'fmt\.Print(ln|f)?\('
+//	    include: "*.go"
+//	    exclude: ["*_test.go", "cmd/**"]
+//	    severity: warning
+//	    message: Use the logger in library code
+//
+// Globs without a slash match file names, others whole paths relative to the
+// current directory, where ** matches any number of directories. A line is
+// exempt from rules listed in a "search:ignore rule-id, ..." comment on it or
+// on the line above, and from all rules if the comment lists none.
+func runCheck(args []string) (err error) {
+	var rulesPath string
+	var rest []string
+	var paths []string
+	var opts Options
+	var rules []checkRule
+	var pattern Matcher
+	var root Root
+	var isFile bool
+	var roots []Root
+	var files []string
+	var workDir string
+	var ds *DirSearch
+	var report *sarifReport
+	var violations int
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	rest, err = parseCheckOptions(args, &rulesPath)
+	if err != nil {
+		goto end
+	}
+	opts = DefaultOptions()
+	paths, err = parseOptions(rest, &opts)
+	if err != nil {
+		goto end
+	}
+	if rulesPath == "" {
+		err = fmt.Errorf("usage: %s check --rules=FILE [--sarif] [-v] [-L] [-P] [--max-depth=N] [--max-filesize=SIZE] [--binary=skip|text|without-match] [--encoding=ENC] [--color=auto|always|never] [path...]", os.Args[0])
+		goto end
+	}
+	err = validateOptions(&opts)
+	if err == nil && (opts.Replacing || opts.OnlyMatching || opts.Extracting || opts.Multiline || opts.GoScope != GoScopeAll || opts.Watch || opts.Tail || opts.Vimgrep) {
+		err = fmt.Errorf("check reports rule violations line by line and cannot be combined with --replace, -o, --extract, -U, --go-scope, --watch, --tail or --vimgrep")
+	}
+	if err != nil {
+		goto end
+	}
+
+	rules, err = loadCheckRules(rulesPath, opts.PCRE)
+	if err != nil {
+		goto end
+	}
+	// Matching any rule finds the candidate lines in one traversal; which rules
+	// they violate is decided per line by the output function
+	pattern = ruleSet(rules)
+
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	for _, pathArg := range paths {
+		root, isFile, err = splitPathArg(pathArg)
+		if err != nil {
+			goto end
+		}
+		if isFile {
+			files = append(files, root.Dir)
+			continue
+		}
+		roots = append(roots, root)
+	}
+	workDir, err = os.Getwd()
+	if err != nil {
+		goto end
+	}
+	colors, err = themeFor(opts)
+	if err != nil {
+		goto end
+	}
+	if opts.Hyperlink {
+		linkHost = hyperlinkHost()
+	}
+
+	ds = NewDirSearch(roots, pattern, opts)
+	ds.AddFiles(files...)
+	if opts.SARIF {
+		report = &sarifReport{}
+		for _, rule := range rules {
+			report.addRule(rule.ID, rule.Message, rule.Severity)
+		}
+	}
+	ds.SetOutput(func(match Match) error {
+		if !match.IsMatch || match.Binary {
+			return nil
+		}
+		relPath := checkPath(workDir, match.FilePath)
+		for _, rule := range rules {
+			span, violated, err := rule.violation(relPath, match)
+			if err != nil {
+				return fmt.Errorf("%s: line %d: %s: %w", match.FilePath, match.LineNumber, rule.ID, err)
+			}
+			if !violated {
+				continue
+			}
+			violations++
+			match.Spans, match.Column = [][2]int{span}, span[0]+1
+			if report != nil {
+				report.add(rule.ID, rule.Message, match)
+			} else {
+				printViolation(rule, match)
+			}
+		}
+		return nil
+	})
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	err = setupSignalHandler(cancel)
+	if err != nil {
+		goto end
+	}
+
+	err = ds.Run(ctx)
+	if err == nil && report != nil {
+		err = report.write(os.Stdout)
+	}
+	if err == nil && violations > 0 {
+		err = fmt.Errorf("%d rule violation(s) found", violations)
+	}
+
+end:
+	return err
+}
+
+// parseCheckOptions takes the check subcommand's own options out of args and
+// returns the rest, which are parsed like those of a search.
+func parseCheckOptions(args []string, rulesPath *string) (rest []string, err error) {
+	var name string
+	var value string
+	var hasValue bool
+
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue = strings.Cut(args[i], "=")
+		switch name {
+		case "--":
+			rest = append(rest, args[i:]...)
+			goto end
+		case "--rules":
+			*rulesPath, err = optionValue(args, &i, name, value, hasValue)
+		default:
+			rest = append(rest, args[i])
+		}
+		if err != nil {
+			goto end
+		}
+	}
+
+end:
+	return rest, err
+}
+
+// loadCheckRules reads and validates a rules file, compiling each rule's regex
+// with the engine selected by pcre and filling in defaults.
+func loadCheckRules(rulesPath string, pcre bool) (rules []checkRule, err error) {
+	var data []byte
+	var value any
+	var file checkRulesFile
+	var decoder *json.Decoder
+	var seen map[string]bool
+
+	data, err = os.ReadFile(rulesPath)
+	if err != nil {
+		goto end
+	}
+	if !strings.EqualFold(filepath.Ext(rulesPath), ".json") {
+		// Decoding the YAML's JSON form applies the same field checks to both
+		value, err = parseYAML(data)
+		if err == nil {
+			data, err = json.Marshal(value)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %w", rulesPath, err)
+			goto end
+		}
+	}
+	decoder = json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&file)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", rulesPath, err)
+		goto end
+	}
+	if len(file.Rules) == 0 {
+		err = fmt.Errorf("%s: no rules", rulesPath)
+		goto end
+	}
+
+	seen = make(map[string]bool)
+	for i := range file.Rules {
+		rule := &file.Rules[i]
+		err = rule.prepare(pcre)
+		if err == nil && seen[rule.ID] {
+			err = fmt.Errorf("duplicate rule id %q", rule.ID)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: rule %d: %w", rulesPath, i+1, err)
+			goto end
+		}
+		seen[rule.ID] = true
+	}
+	rules = file.Rules
+
+end:
+	return rules, err
+}
+
+// prepare validates the rule, compiles its regex and fills in defaults.
+func (rule *checkRule) prepare(pcre bool) (err error) {
+	switch {
+	case !ruleIDPattern.MatchString(rule.ID):
+		err = fmt.Errorf("invalid id %q (want letters, digits, '_', '.' and '-', starting with a letter or digit)", rule.ID)
+	case rule.Regex == "":
+		err = fmt.Errorf("%s: regex is required", rule.ID)
+	}
+	if err != nil {
+		goto end
+	}
+
+	rule.pattern, err = compilePattern(rule.Regex, pcre)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", rule.ID, err)
+		goto end
+	}
+	for _, glob := range slices.Concat(rule.Include, rule.Exclude) {
+		if _, err = path.Match(glob, ""); err != nil {
+			err = fmt.Errorf("%s: invalid glob %q", rule.ID, glob)
+			goto end
+		}
+	}
+
+	switch rule.Severity {
+	case "":
+		rule.Severity = "error"
+	case "error", "warning", "note":
+	default:
+		err = fmt.Errorf("%s: invalid severity %q (want error, warning or note)", rule.ID, rule.Severity)
+	}
+	if rule.Message == "" {
+		rule.Message = "Forbidden pattern " + rule.Regex
+	}
+
+end:
+	return err
+}
+
+// violation reports whether the match's line violates the rule, and where: the
+// rule must apply to the file (relPath, slash-separated), find its regex on the
+// line, and not be suppressed there.
+func (rule *checkRule) violation(relPath string, match Match) (span [2]int, violated bool, err error) {
+	var found [][]int
+
+	if len(rule.Include) > 0 && !slices.ContainsFunc(rule.Include, func(glob string) bool { return matchGlob(glob, relPath) }) {
+		goto end
+	}
+	if slices.ContainsFunc(rule.Exclude, func(glob string) bool { return matchGlob(glob, relPath) }) {
+		goto end
+	}
+	found, err = findAll(rule.pattern, match.Line, 1)
+	if err != nil || len(found) == 0 || suppresses(match.Line, rule.ID) || suppresses(match.Before, rule.ID) {
+		goto end
+	}
+	span, violated = [2]int{found[0][0], found[0][1]}, true
+
+end:
+	return span, violated, err
+}
+
+// suppresses reports whether line has a search:ignore comment covering ruleID:
+// one listing it, or one listing no rules at all.
+func suppresses(line, ruleID string) bool {
+	var found []string
+
+	found = suppressPattern.FindStringSubmatch(line)
+	if found == nil {
+		return false
+	}
+	if found[1] == "" {
+		return true
+	}
+	for id := range strings.SplitSeq(found[1], ",") {
+		if strings.TrimSpace(id) == ruleID {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleSet is a Matcher finding wherever any of its rules' patterns matches. Each
+// pattern runs on its own rather than as one alternation, which would renumber
+// capture groups under backreferences and reject group names used twice. It
+// only reports whole matches, without groups.
+type ruleSet []checkRule
+
+// FindAllStringSubmatchIndex returns the matches of all rules in order of their
+// start, leaving out those overlapping an earlier one.
+func (rs ruleSet) FindAllStringSubmatchIndex(s string, n int) [][]int {
+	found, _ := rs.findAll(s, n)
+	return found
+}
+
+// findAll is FindAllStringSubmatchIndex, failing when a rule's pattern does.
+func (rs ruleSet) findAll(s string, n int) (found [][]int, err error) {
+	var ruleFound [][]int
+	var end int
+
+	for _, rule := range rs {
+		ruleFound, err = findAll(rule.pattern, s, n)
+		if err != nil {
+			goto end
+		}
+		for _, loc := range ruleFound {
+			found = append(found, loc[:2])
+		}
+	}
+	slices.SortStableFunc(found, func(a, b []int) int { return cmp.Compare(a[0], b[0]) })
+
+	end = -1
+	found = slices.DeleteFunc(found, func(loc []int) (overlaps bool) {
+		overlaps = loc[0] < end || (loc[0] == end && loc[0] == loc[1])
+		if !overlaps {
+			end = loc[1]
+		}
+		return overlaps
+	})
+	if n >= 0 && len(found) > n {
+		found = found[:n]
+	}
+
+end:
+	return found, err
+}
+
+// SubexpNames reports no capture groups.
+func (rs ruleSet) SubexpNames() []string {
+	return []string{""}
+}
+
+// String returns a pattern matching wherever any of the rules does. It is only
+// shown and used to query the index, for which the alternation is accurate.
+func (rs ruleSet) String() string {
+	var alternatives []string
+
+	for _, rule := range rs {
+		alternatives = append(alternatives, "(?:"+rule.Regex+")")
+	}
+	return strings.Join(alternatives, "|")
+}
+
+// checkPath returns the path globs are matched against: the displayed path
+// relative to workDir, with forward slashes. Files inside archives are matched
+// as the archive.
+func checkPath(workDir, displayPath string) string {
+	var rel string
+	var err error
+
+	displayPath, _, _ = strings.Cut(displayPath, "!/")
+	rel, err = filepath.Rel(workDir, absPath(displayPath))
+	if err != nil {
+		rel = displayPath
+	}
+	return filepath.ToSlash(rel)
+}
+
+// matchGlob reports whether the slash-separated relPath matches glob. A glob
+// without a slash is matched against the file name, as in .gitignore; other
+// globs against the whole path, where a ** element matches any number of
+// directories.
+func matchGlob(glob, relPath string) (matched bool) {
+	if !strings.Contains(glob, "/") {
+		matched, _ = path.Match(glob, path.Base(relPath))
+		return matched
+	}
+	return matchGlobElems(strings.Split(strings.TrimPrefix(glob, "/"), "/"), strings.Split(relPath, "/"))
+}
+
+// matchGlobElems matches path elements against glob elements.
+func matchGlobElems(glob, elems []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := range len(elems) + 1 {
+				if matchGlobElems(glob[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(glob[0], elems[0]); !matched {
+			return false
+		}
+		glob, elems = glob[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+// printViolation prints a violation like a compiler diagnostic, which editors
+// and CI log parsers understand: path:line:column: severity: message [rule-id].
+func printViolation(rule checkRule, match Match) {
+	fmt.Printf("%s:%s:%s: %s: %s [%s]\n",
+		linkPath(match.FilePath, match.LineNumber, paint(colors.path, match.FilePath)),
+		paint(colors.lineNumber, strconv.Itoa(match.LineNumber)),
+		paint(colors.lineNumber, strconv.Itoa(match.Column)),
+		rule.Severity, rule.Message, rule.ID)
+}
+
+// UnmarshalJSON accepts a single glob as well as a list of them.
+func (g *globList) UnmarshalJSON(data []byte) (err error) {
+	var single string
+	var list []string
+
+	if string(data) == "null" {
+		goto end
+	}
+	if json.Unmarshal(data, &single) == nil {
+		*g = globList{single}
+		goto end
+	}
+	err = json.Unmarshal(data, &list)
+	*g = list
+
+end:
+	return err
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadCheckRules(t *testing.T) {
+	t.Chdir(writeTree(t, map[string]string{
+		"rules.yaml": "rules:\n  - id: no-println\n    regex: 'fmt\\.Println'\n    include: \"*.go\"\n  - id: todo\n    regex: TODO\n    exclude: [\"docs/**\", \"*.md\"]\n    severity: note\n    message: Link a ticket\n",
+		"rules.json": `{"rules": [{"id": "no-println", "regex": "fmt\\.Println", "include": "*.go"}, {"id": "todo", "regex": "TODO", "exclude": ["docs/**", "*.md"], "severity": "note", "message": "Link a ticket"}]}`,
+	}))
+
+	var loaded [][]checkRule
+	for _, path := range []string{"rules.yaml", "rules.json"} {
+		rules, err := loadCheckRules(path, false)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		for i := range rules {
+			rules[i].pattern = nil
+		}
+		loaded = append(loaded, rules)
+	}
+	want := []checkRule{
+		{ID: "no-println", Regex: `fmt\.Println`, Include: globList{"*.go"}, Severity: "error", Message: `Forbidden pattern fmt\.Println`},
+		{ID: "todo", Regex: "TODO", Exclude: globList{"docs/**", "*.md"}, Severity: "note", Message: "Link a ticket"},
+	}
+	for i, rules := range loaded {
+		if !reflect.DeepEqual(rules, want) {
+			t.Errorf("file %d: rules = %+v, want %+v", i, rules, want)
+		}
+	}
+}
+
+func TestLoadCheckRulesErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{"rules: []\n", "no rules"},
+		{"other: x\n", "unknown field"},
+		{"rules:\n  - id: a\n    regex: x\n    level: error\n", "unknown field"},
+		{"rules:\n  - id: a\n    regex: x\n  - id: a\n    regex: y\n", `rule 2: duplicate rule id "a"`},
+		{"rules:\n  - id: '-a'\n    regex: x\n", "rule 1: invalid id"},
+		{"rules:\n  - id: a b\n    regex: x\n", "rule 1: invalid id"},
+		{"rules:\n  - id: a\n", "rule 1: a: regex is required"},
+		{"rules:\n  - id: a\n    regex: '('\n", "rule 1: a:"},
+		{"rules:\n  - id: a\n    regex: x\n    severity: fatal\n", `invalid severity "fatal"`},
+		{"rules:\n  - id: a\n    regex: x\n    include: '['\n", `invalid glob "["`},
+		{"rules:\n  - id: a\n    regex: x\n  bad\n", "line 4"},
+	}
+
+	for _, tt := range tests {
+		t.Chdir(writeTree(t, map[string]string{"rules.yaml": tt.content}))
+		_, err := loadCheckRules("rules.yaml", false)
+		if err == nil || !strings.Contains(err.Error(), tt.err) || !strings.HasPrefix(err.Error(), "rules.yaml: ") {
+			t.Errorf("%q: error = %v, want rules.yaml: ...%s", tt.content, err, tt.err)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		glob    string
+		path    string
+		matched bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/tool/main.go", true},
+		{"*_test.go", "pkg/a_test.go", true},
+		{"*.go", "main.golang", false},
+		{"cmd/*", "cmd/main.go", true},
+		{"cmd/*", "cmd/tool/main.go", false},
+		{"/cmd/*", "cmd/main.go", true},
+		{"cmd/**", "cmd/tool/main.go", true},
+		{"cmd/**", "cmd", true},
+		{"cmd/**", "pkg/cmd/main.go", false},
+		{"**/testdata/*", "testdata/x.txt", true},
+		{"**/testdata/*", "a/b/testdata/x.txt", true},
+		{"**/testdata/*", "a/b/testdata/c/x.txt", false},
+		{"a/**/b/*.go", "a/b/x.go", true},
+		{"a/**/b/*.go", "a/x/y/b/x.go", true},
+		{"a/**/b/*.go", "a/x/y/c/x.go", false},
+		{"**/*.go", "x.go", true},
+		{"**", "any/thing", true},
+		{"a/**/**/b", "a/b", true},
+		{"a/**/**/b", "a/x/y/z/b", true},
+	}
+
+	for _, tt := range tests {
+		if matched := matchGlob(tt.glob, tt.path); matched != tt.matched {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.glob, tt.path, matched, tt.matched)
+		}
+	}
+}
+
+func TestSuppresses(t *testing.T) {
+	tests := []struct {
+		line       string
+		ruleID     string
+		suppressed bool
+	}{
+		{"x // search:ignore", "todo", true},
+		{"x # search:ignore todo", "todo", true},
+		{"x // search:ignore no-println, todo", "todo", true},
+		{"x // search:ignore no-println,todo", "todo", true},
+		{"x // search:ignore no-println", "todo", false},
+		{"x // search:ignore todo-ticket", "todo", false},
+		{"x // search:ignored", "todo", false},
+		{"x // TODO", "todo", false},
+	}
+
+	for _, tt := range tests {
+		if suppressed := suppresses(tt.line, tt.ruleID); suppressed != tt.suppressed {
+			t.Errorf("suppresses(%q, %q) = %v, want %v", tt.line, tt.ruleID, suppressed, tt.suppressed)
+		}
+	}
+}
+
+// TestRuleSet checks that each rule matches with its own pattern, so group
+// numbers and names are those of the rule.
+func TestRuleSet(t *testing.T) {
+	var rules []checkRule
+	for _, regex := range []string{`(\w+)-(\w+)`, `(\w)\1`, `(?P<x>a)b\k<x>`, `(?P<x>z)`} {
+		rule := checkRule{ID: "r", Regex: regex}
+		if err := rule.prepare(true); err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+
+	tests := []struct {
+		line string
+		want [][]int
+	}{
+		{"x-y", [][]int{{0, 3}}},
+		{"a bb c", [][]int{{2, 4}}},
+		{"aba", [][]int{{0, 3}}},
+		{"z aab", [][]int{{0, 1}, {2, 4}}},
+		{"nothing", nil},
+	}
+	for _, tt := range tests {
+		found, err := findAll(ruleSet(rules), tt.line, -1)
+		if err != nil || !reflect.DeepEqual(found, tt.want) {
+			t.Errorf("ruleSet on %q = %v, %v; want %v", tt.line, found, err, tt.want)
+		}
+	}
+}
+
+func TestRunCheck(t *testing.T) {
+	t.Chdir(writeTree(t, map[string]string{
+		"rules.yaml": "rules:\n" +
+			"  - id: no-println\n    regex: 'fmt\\.Println'\n    include: \"*.go\"\n    exclude: \"*_test.go\"\n" +
+			"  - id: doubled\n    regex: '\\b(\\w+) \\1\\b'\n    severity: warning\n    message: Doubled word\n",
+		"main.go":      "package main\n\nfunc main() {\n\tfmt.Println(\"the the end\")\n}\n",
+		"main_test.go": "package main\n\nfunc f() { fmt.Println() }\n",
+		"quiet.go":     "package main\n\n// search:ignore no-println\nfunc g() { fmt.Println() }\n",
+		"docs/a.md":    "fmt.Println is fine in docs, and and so on\n",
+	}))
+
+	output, err := captureStdout(t, func() error { return runCheck([]string{"--rules=rules.yaml", "-P", "--color=never"}) })
+	if err == nil || err.Error() != "3 rule violation(s) found" {
+		t.Errorf("runCheck error = %v, want 3 violations", err)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for _, want := range []string{
+		"main.go:4:2: error: Forbidden pattern fmt\\.Println [no-println]",
+		"main.go:4:15: warning: Doubled word [doubled]",
+		"docs/a.md:1:30: warning: Doubled word [doubled]",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output %q is missing %q", output, want)
+		}
+	}
+	if len(lines) != 3 {
+		t.Errorf("output has %d lines, want 3: %q", len(lines), output)
+	}
+
+	// Without violations check succeeds
+	output, err = captureStdout(t, func() error { return runCheck([]string{"--rules=rules.yaml", "-P", "main_test.go"}) })
+	if err != nil || output != "" {
+		t.Errorf("check of main_test.go = %q, %v; want no output and no error", output, err)
+	}
+
+	if _, err = captureStdout(t, func() error { return runCheck([]string{"./"}) }); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Errorf("check without --rules = %v, want usage", err)
+	}
+}
+
+func TestRunCheckSARIF(t *testing.T) {
+	t.Chdir(writeTree(t, map[string]string{
+		"rules.json": `{"rules": [{"id": "todo", "regex": "TODO", "severity": "note"}, {"id": "fixme", "regex": "FIXME"}]}`,
+		"src/a.txt":  "ok\nTODO: x\n",
+	}))
+
+	output, err := captureStdout(t, func() error { return runCheck([]string{"--rules", "rules.json", "--sarif", "src/"}) })
+	if err == nil || err.Error() != "1 rule violation(s) found" {
+		t.Errorf("runCheck error = %v, want 1 violation", err)
+	}
+	var log sarifLog
+	if err = json.Unmarshal([]byte(output), &log); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, output)
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 || len(run.Results) != 1 {
+		t.Fatalf("got %d rules and %d results, want 2 and 1", len(run.Tool.Driver.Rules), len(run.Results))
+	}
+	if result := run.Results[0]; result.RuleID != "todo" || result.RuleIndex != 0 || result.Level != "note" ||
+		result.Locations[0].PhysicalLocation.ArtifactLocation.URI != "src/a.txt" || result.Locations[0].PhysicalLocation.Region.StartLine != 2 {
+		t.Errorf("result = %+v", result)
+	}
+}
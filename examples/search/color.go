@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ColorMode selects when output is colored (--color).
+type ColorMode string
+
+const (
+	ColorAuto   ColorMode = "auto"   // Only when stdout is a terminal and NO_COLOR is unset
+	ColorAlways ColorMode = "always" // Also when piped, e.g. into less -R
+	ColorNever  ColorMode = "never"
+)
+
+// colorTheme holds the SGR parameters, such as "1;31" for bold red, used for
+// each part of the output. An empty field leaves that part uncolored.
+type colorTheme struct {
+	path       string // File path headers
+	lineNumber string // Line numbers in front of lines
+	match      string // The matched text within a line
+	context    string // Lines shown around a match
+	removed    string // Old lines of a replacement, and matches gone in --watch mode
+	added      string // New lines of a replacement
+}
+
+// defaultColors is the theme used when coloring, before --colors changes it.
+var defaultColors = colorTheme{path: "35", lineNumber: "32", match: "31", removed: "31", added: "32"}
+
+// colors is the theme output is printed with. It is set once by main from
+// --color and --colors; the zero value prints no escape sequences at all.
+var colors colorTheme
+
+// colorNames maps the names accepted by --colors to SGR parameters.
+var colorNames = map[string]string{
+	"bold": "1", "dim": "2", "italic": "3", "underline": "4",
+	"black": "30", "red": "31", "green": "32", "yellow": "33",
+	"blue": "34", "magenta": "35", "cyan": "36", "white": "37",
+}
+
+// parseColorMode validates the value of the --color option.
+func parseColorMode(s string) (mode ColorMode, err error) {
+	mode = ColorMode(s)
+	switch mode {
+	case ColorAuto, ColorAlways, ColorNever:
+	default:
+		err = fmt.Errorf("invalid --color mode %q (want auto, always or never)", s)
+	}
+	return mode, err
+}
+
+// themeFor returns the theme for the options: none unless coloring is on, and
+// otherwise the default theme changed by --colors. In auto mode color is used
+// only on a terminal, and not when the NO_COLOR environment variable is set
+// (https://no-color.org) or TERM is "dumb".
+func themeFor(opts Options) (theme colorTheme, err error) {
+	switch opts.Color {
+	case ColorNever:
+		goto end
+	case ColorAuto:
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" || !isTerminal(os.Stdout) {
+			goto end
+		}
+	}
+	theme = defaultColors
+	err = parseColorSpecs(opts.Colors, &theme)
+
+end:
+	return theme, err
+}
+
+// parseColorSpecs applies a --colors value to theme. It is a comma-separated
+// list of PART:STYLE, where PART is path, line, match or context and STYLE is
+// "none" or attributes joined by "+", each a name such as bold or red or a raw
+// SGR number, e.g. "path:bold+blue,match:4+33,context:none".
+func parseColorSpecs(specs string, theme *colorTheme) (err error) {
+	var part string
+	var style string
+	var ok bool
+	var field *string
+	var params []string
+
+	for item := range strings.SplitSeq(specs, ",") {
+		if item == "" {
+			continue
+		}
+		part, style, ok = strings.Cut(item, ":")
+		switch part {
+		case "path":
+			field = &theme.path
+		case "line":
+			field = &theme.lineNumber
+		case "match":
+			field = &theme.match
+		case "context":
+			field = &theme.context
+		default:
+			ok = false
+		}
+		if !ok {
+			err = fmt.Errorf("invalid --colors entry %q (want path, line, match or context, then a colon and a style)", item)
+			goto end
+		}
+
+		params = params[:0]
+		if style != "none" {
+			for attr := range strings.SplitSeq(style, "+") {
+				param, known := colorNames[attr]
+				if !known {
+					if n, numErr := strconv.Atoi(attr); numErr == nil && n >= 0 && n <= 255 {
+						param, known = attr, true
+					}
+				}
+				if !known {
+					err = fmt.Errorf("invalid --colors style %q in %q (want names like bold or red, SGR numbers, or none)", attr, item)
+					goto end
+				}
+				params = append(params, param)
+			}
+		}
+		*field = strings.Join(params, ";")
+	}
+
+end:
+	return err
+}
+
+// paint wraps text in the escape sequences of style, or returns it unchanged
+// when the style is empty.
+func paint(style, text string) string {
+	if style == "" {
+		return text
+	}
+	return "\033[" + style + "m" + text + "\033[0m"
+}
+
+// isTerminal reports whether f is a terminal (a character device).
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
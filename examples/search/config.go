@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Where default options come from, in increasing order of precedence; options
+// on the command line override them all.
+const (
+	configEnv         = "SEARCH_OPTS" // Options split like a shell would
+	projectConfigName = ".searchrc"   // Nearest one above the first searched path
+)
+
+// configSource is one place default options were read from.
+type configSource struct {
+	name string   // File path or environment variable, for messages
+	args []string // Options in command line form
+}
+
+// loadConfig collects the default options that apply to a search of startPath:
+// the user's config file ($XDG_CONFIG_HOME/search/config or the platform's
+// equivalent), the nearest .searchrc found walking up from startPath, and the
+// SEARCH_OPTS environment variable, in that order.
+//
+// Config files hold one argument per line, so values may contain spaces, e.g.
+// "--max-filesize=10M" or "--binary" followed by "text" on the next line.
+// Blank lines and lines starting with # are ignored. A boolean option set there
+// can be turned off on the command line with its --no- form, e.g. --no-follow,
+// and an option with a value by giving another value.
+//
+// Config only applies to plain searches: the index, serve, lsp and check
+// subcommands depend on their own arguments alone.
+func loadConfig(startPath string) (sources []configSource, err error) {
+	var configDir string
+	var path string
+	var args []string
+
+	if configDir, err = os.UserConfigDir(); err == nil {
+		path = filepath.Join(configDir, "search", "config")
+		args, err = readConfigFile(path)
+		if err != nil {
+			goto end
+		}
+		if args != nil {
+			sources = append(sources, configSource{name: path, args: args})
+		}
+	}
+	err = nil
+
+	path = findProjectConfig(startPath)
+	if path != "" {
+		args, err = readConfigFile(path)
+		if err != nil {
+			goto end
+		}
+		sources = append(sources, configSource{name: path, args: args})
+	}
+
+	if value := os.Getenv(configEnv); value != "" {
+		args, err = splitShellWords(value)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", configEnv, err)
+			goto end
+		}
+		sources = append(sources, configSource{name: configEnv, args: args})
+	}
+
+end:
+	return sources, err
+}
+
+// applyConfig parses the options of each source into opts in order, so later
+// sources override earlier ones. Sources may only contain options, and not the
+// ones that write files or take the paths to search from elsewhere: a .searchrc
+// in a checked out repository must not be able to make a search rewrite files.
+func applyConfig(sources []configSource, opts *Options) (err error) {
+	var positional []string
+	var name string
+
+	for _, source := range sources {
+		positional, err = parseOptions(source.args, opts)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", source.name, err)
+			goto end
+		}
+		if len(positional) > 0 {
+			err = fmt.Errorf("%s: only options are allowed, found %q", source.name, positional[0])
+			goto end
+		}
+		if name = commandLineOnlyOption(opts); name != "" {
+			err = fmt.Errorf("%s: %s is only accepted on the command line", source.name, name)
+			goto end
+		}
+	}
+
+end:
+	return err
+}
+
+// commandLineOnlyOption returns the name of an option set in opts that config
+// may not set, or "" if there is none.
+func commandLineOnlyOption(opts *Options) (name string) {
+	switch {
+	case opts.Replacing:
+		name = "--replace"
+	case opts.Write:
+		name = "--write"
+	case opts.DryRun:
+		name = "--dry-run"
+	case opts.PreserveMtime:
+		name = "--preserve-mtime"
+	case opts.FilesFrom != "":
+		name = "--files-from"
+	}
+	return name
+}
+
+// readConfigFile reads the arguments in a config file. A missing file is not an
+// error and gives no arguments.
+func readConfigFile(path string) (args []string, err error) {
+	var file *os.File
+	var scanner *bufio.Scanner
+
+	file, err = os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		goto end
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	args = []string{}
+	scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args = append(args, line)
+	}
+	err = scanner.Err()
+
+end:
+	return args, err
+}
+
+// findProjectConfig returns the nearest .searchrc in the directory of startPath
+// or any directory above it, or "" if there is none. startPath may be a
+// directory, a file or a glob like src/*.go.
+func findProjectConfig(startPath string) (path string) {
+	var dir string
+
+	dir, _ = expandTilde(startPath)
+	if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	dir = absPath(dir)
+	for {
+		candidate := filepath.Join(dir, projectConfigName)
+		if stat, err := os.Stat(candidate); err == nil && stat.Mode().IsRegular() {
+			path = candidate
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return path
+}
+
+// splitShellWords splits s into words at unquoted whitespace. Single quotes keep
+// everything literally, double quotes allow \" and \\, and outside quotes a
+// backslash escapes any character, as in a POSIX shell.
+func splitShellWords(s string) (words []string, err error) {
+	var word strings.Builder
+	var inWord bool
+	var quote rune
+	var escaped bool
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			if quote == '"' && r != '"' && r != '\\' {
+				word.WriteByte('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	switch {
+	case quote != 0:
+		err = fmt.Errorf("unterminated %c quote", quote)
+	case escaped:
+		err = fmt.Errorf("trailing backslash")
+	case inWord:
+		words = append(words, word.String())
+	}
+	return words, err
+}
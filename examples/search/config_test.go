@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestApplyConfigRejectsCommandLineOnly checks that config can't make a search
+// write files or read its paths from elsewhere.
+func TestApplyConfigRejectsCommandLineOnly(t *testing.T) {
+	for _, args := range [][]string{
+		{"--replace=x"}, {"--replace", "x"}, {"--write"}, {"--dry-run"}, {"--preserve-mtime"},
+		{"--files-from=list.txt"}, {"-0", "--files-from", "-"},
+	} {
+		opts := DefaultOptions()
+		err := applyConfig([]configSource{{name: ".searchrc", args: args}}, &opts)
+		if err == nil || !strings.Contains(err.Error(), "only accepted on the command line") {
+			t.Errorf("applyConfig(%q) = %v, want a command line only error", args, err)
+		}
+	}
+
+	opts := DefaultOptions()
+	err := applyConfig([]configSource{{name: configEnv, args: []string{"-L", "--max-filesize=1M", "-0"}}}, &opts)
+	if err != nil || !opts.FollowSymlinks || opts.MaxFileSize != 1<<20 || !opts.NullSeparated {
+		t.Errorf("applyConfig of search options = %v, options %+v", err, opts)
+	}
+}
+
+// TestNegatedOptions checks that the --no- forms undo a boolean set earlier,
+// as when the command line overrides a config file.
+func TestNegatedOptions(t *testing.T) {
+	opts := DefaultOptions()
+	_, err := parseOptions([]string{"-L", "--absolute", "--hyperlink", "-z", "-P", "-p", "--sarif"}, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parseOptions([]string{"--no-follow", "--no-absolute", "--no-hyperlink", "--no-search-zip", "--no-pcre", "--no-show-function", "--no-sarif"}, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts != DefaultOptions() {
+		t.Errorf("after negating every option: %+v, want the defaults", opts)
+	}
+}
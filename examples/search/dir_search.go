@@ -3,37 +3,95 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strings"
+	"sync"
 
 	"golang.org/x/sync/errgroup"
 )
 
+// stdinName is the path reported for matches read from standard input.
+const stdinName = "(standard input)"
+
 // DirSearch encapsulates all the state and configuration needed for a directory search.
 // This eliminates prop drilling and provides a clean, testable interface.
 type DirSearch struct {
-	searchDir     string
-	glob          string
-	pattern       *regexp.Regexp
+	roots         []Root
+	files         []string
+	explicit      map[fileID]struct{} // Added files that walking a root may reach too
+	pattern       Matcher
+	filters       []fileFilter
 	workerLimiter chan struct{}
 	matchChan     chan Match
+	visitedMu     sync.Mutex
+	visited       map[fileID]struct{} // Directories searched (with FollowSymlinks) and files rewritten
+	skippedMu     sync.Mutex
+	skipped       map[string]int     // Count of skipped paths per reason
+	results       map[string][]Match // Matches per display path, kept for --watch to diff against
+	output        func(Match) error  // Called by the output handler for every match
+	opts          Options
 	verbose       bool
 }
 
 // NewDirSearch creates a new directory search instance with the specified configuration.
-func NewDirSearch(searchDir, glob string, pattern *regexp.Regexp, maxWorkers int, verbose bool) *DirSearch {
-	return &DirSearch{
-		searchDir:     searchDir,
-		glob:          glob,
+// Roots nested inside other roots that already cover them are dropped, and where
+// roots overlap otherwise each file is searched by only one of them.
+func NewDirSearch(roots []Root, pattern Matcher, opts Options) (ds *DirSearch) {
+	ds = &DirSearch{
+		roots:         dedupeRoots(roots, opts.MaxDepth),
+		visited:       make(map[fileID]struct{}),
+		skipped:       make(map[string]int),
 		pattern:       pattern,
-		workerLimiter: make(chan struct{}, maxWorkers),
-		matchChan:     make(chan Match, maxWorkers),
-		verbose:       verbose,
+		filters:       buildFilters(opts),
+		workerLimiter: make(chan struct{}, opts.MaxWorkers),
+		matchChan:     make(chan Match, opts.MaxWorkers),
+		opts:          opts,
+		verbose:       opts.Verbose,
+		output:        printMatch,
+	}
+	if opts.Vimgrep {
+		ds.output = printVimgrep
+	}
+	if opts.Watch {
+		ds.results = make(map[string][]Match)
+	}
+	return ds
+}
+
+// AddFiles adds individual files to be searched in addition to the directory trees.
+// They are always searched, even if they don't match a glob or are symlinks, and
+// "-" means standard input. Walking a root skips the files added here, so each is
+// searched once. With no roots passed to NewDirSearch only these files are searched.
+func (ds *DirSearch) AddFiles(paths ...string) {
+	for _, path := range paths {
+		ds.files = append(ds.files, path)
+		if path == "-" || !slices.ContainsFunc(ds.roots, func(root Root) bool {
+			return root.selects(path, ds.opts.MinDepth, ds.opts.MaxDepth)
+		}) {
+			continue
+		}
+		if id, err := fileIDOf(path); err == nil {
+			if ds.explicit == nil {
+				ds.explicit = make(map[fileID]struct{})
+			}
+			ds.explicit[id] = struct{}{}
+		}
 	}
 }
 
+// SetOutput replaces printing matches with calling fn for each of them. It is
+// only ever called from the output handler goroutine, so fn needs no locking,
+// and an error it returns stops the search and is returned by Run.
+func (ds *DirSearch) SetOutput(fn func(Match) error) {
+	ds.output = fn
+}
+
 // Run executes the directory search and returns any error encountered.
 // It coordinates the overall search operation by setting up:
 // 1. Context and cancellation handling
@@ -45,7 +103,7 @@ func (ds *DirSearch) Run(ctx context.Context) (err error) {
 	var g *errgroup.Group
 
 	if ds.verbose {
-		fmt.Printf("[TRACE] Starting search in %s with pattern %s\n", ds.searchDir, ds.pattern.String())
+		fmt.Printf("[TRACE] Starting search in %d roots and %d files with pattern %s\n", len(ds.roots), len(ds.files), ds.pattern.String())
 	}
 
 	// Create cancellable context for coordinating shutdown
@@ -76,7 +134,7 @@ func (ds *DirSearch) Run(ctx context.Context) (err error) {
 			}
 			close(ds.matchChan)
 		}()
-		return ds.searchDirectory(ctx, ds.searchDir)
+		return ds.searchTargets(ctx)
 	})
 
 	if ds.verbose {
@@ -87,8 +145,55 @@ func (ds *DirSearch) Run(ctx context.Context) (err error) {
 
 	if ds.verbose {
 		fmt.Printf("[TRACE] All goroutines completed\n")
+		for reason, count := range ds.Skipped() {
+			fmt.Printf("[TRACE] Skipped %d: %s\n", count, reason)
+		}
+	}
+
+	return err
+}
+
+// searchTargets searches the explicitly added files and every root concurrently,
+// sharing the same worker limit for file searches.
+func (ds *DirSearch) searchTargets(ctx context.Context) (err error) {
+	var g *errgroup.Group
+
+	g, ctx = errgroup.WithContext(ctx)
+
+	if ds.opts.UseIndex {
+		ds.loadRootIndexes()
+	}
+
+	for _, path := range ds.files {
+		capturedPath := path // Capture by value
+		g.Go(func() error {
+			ds.workerLimiter <- struct{}{}
+			defer func() {
+				<-ds.workerLimiter
+			}()
+			if capturedPath == "-" {
+				return ds.searchStdin(ctx)
+			}
+			return ds.searchFile(ctx, capturedPath, ds.fileDisplayPath(capturedPath))
+		})
+	}
+
+	for i := range ds.roots {
+		root := &ds.roots[i]
+		if ds.opts.OneFileSystem {
+			root.dev, err = deviceOf(root.Dir)
+			root.hasDev = err == nil
+			if err != nil && ds.verbose {
+				fmt.Printf("[TRACE] Cannot find filesystem of %s, not limiting search to it: %v\n", root.Dir, err)
+			}
+			err = nil
+		}
+		g.Go(func() error {
+			return ds.searchDirectory(ctx, root, root.Dir, 0)
+		})
 	}
 
+	err = g.Wait()
 	return err
 }
 
@@ -99,7 +204,9 @@ func (ds *DirSearch) Run(ctx context.Context) (err error) {
 //
 // This function demonstrates the concurrent directory traversal pattern
 // where each directory level manages its own set of worker goroutines.
-func (ds *DirSearch) searchDirectory(ctx context.Context, dir string) (err error) {
+//
+// depth is how far dir is below the root, which itself has depth 0.
+func (ds *DirSearch) searchDirectory(ctx context.Context, root *Root, dir string, depth int) (err error) {
 	var g *errgroup.Group
 	var entries []os.DirEntry
 
@@ -118,6 +225,13 @@ func (ds *DirSearch) searchDirectory(ctx context.Context, dir string) (err error
 	default:
 	}
 
+	// When following symlinks the same directory can be reached more than once,
+	// e.g. through a link to ".." - only search each physical directory once
+	if ds.opts.FollowSymlinks && !ds.firstVisit(dir) {
+		ds.recordSkip(dir, skipVisited)
+		return nil
+	}
+
 	// Create errgroup for this directory level's goroutines
 	// The context from errgroup will be cancelled if any child goroutine fails
 	g, ctx = errgroup.WithContext(ctx)
@@ -138,7 +252,7 @@ func (ds *DirSearch) searchDirectory(ctx context.Context, dir string) (err error
 	}
 
 	// Process each directory entry (files and subdirectories)
-	err = ds.processDirectoryEntries(ctx, g, dir, entries)
+	err = ds.processDirectoryEntries(ctx, g, root, dir, depth, entries)
 	if err != nil {
 		if ds.verbose {
 			fmt.Printf("[TRACE] Error processing entries in %s: %v\n", dir, err)
@@ -162,6 +276,33 @@ end:
 	return err
 }
 
+// firstVisit records a directory (or file) as visited and reports whether this is
+// the first time it has been seen. Paths are identified by device and inode, so
+// different paths leading to the same place through symlinks are recognized.
+func (ds *DirSearch) firstVisit(path string) (first bool) {
+	var id fileID
+	var seen bool
+	var err error
+
+	id, err = fileIDOf(path)
+	if err != nil {
+		// Can't identify it; let ReadDir report the problem
+		first = true
+		goto end
+	}
+
+	ds.visitedMu.Lock()
+	defer ds.visitedMu.Unlock()
+	_, seen = ds.visited[id]
+	if !seen {
+		ds.visited[id] = struct{}{}
+	}
+	first = !seen
+
+end:
+	return first
+}
+
 // processDirectoryEntries iterates through directory entries and spawns goroutines
 // for subdirectories and matching files. This is where the core concurrency happens.
 //
@@ -169,9 +310,23 @@ end:
 // 1. Goroutine closure variable capture (must capture loop variables by value)
 // 2. Worker limiting using channel-based semaphores
 // 3. Selective processing (skip certain directories, match files by glob)
-func (ds *DirSearch) processDirectoryEntries(ctx context.Context, g *errgroup.Group, dir string, entries []os.DirEntry) (err error) {
+//
+// Symlinks found while walking are skipped unless FollowSymlinks is set; paths
+// given explicitly (roots and added files) are always followed.
+//
+// Entries are one level deeper than dir. Files are only searched between
+// MinDepth and MaxDepth, and directories are only entered if their contents
+// can still be within MaxDepth.
+func (ds *DirSearch) processDirectoryEntries(ctx context.Context, g *errgroup.Group, root *Root, dir string, dirDepth int, entries []os.DirEntry) (err error) {
 	var fullPath string
 	var matched bool
+	var isDir bool
+	var mode os.FileMode
+	var stat os.FileInfo
+	var dev uint64
+	var depth int
+
+	depth = dirDepth + 1
 
 	// Iterate through each entry in the directory
 	for _, entry := range entries {
@@ -186,36 +341,124 @@ func (ds *DirSearch) processDirectoryEntries(ctx context.Context, g *errgroup.Gr
 
 		// Build full path for this entry
 		fullPath = filepath.Join(dir, entry.Name())
+		isDir = entry.IsDir()
+		mode = entry.Type()
+		stat = nil
+
+		// Symlinks are only followed with -L/--follow. By default they are skipped
+		// whether they point at a file or a directory, so both behave the same
+		if entry.Type()&os.ModeSymlink != 0 {
+			if !ds.opts.FollowSymlinks {
+				ds.recordSkip(fullPath, skipSymlink)
+				continue
+			}
+			stat, err = os.Stat(fullPath)
+			if err != nil {
+				ds.recordSkip(fullPath, skipBrokenSymlink)
+				err = nil
+				continue
+			}
+			isDir = stat.IsDir()
+			mode = stat.Mode()
+		}
+
+		// FIFOs, sockets and devices can block or never end; judge them by the
+		// directory entry so they are never even opened
+		if reason := specialFileReason(mode); reason != "" {
+			ds.recordSkip(fullPath, reason)
+			continue
+		}
 
 		// Handle directories: recurse into subdirectories
-		if entry.IsDir() {
+		if isDir {
 			// Skip directories we don't want to search (optimization)
 			if shouldSkipDirectory(entry.Name()) {
 				continue
 			}
 
+			// Nothing below this directory would be within --max-depth
+			if ds.opts.MaxDepth > 0 && depth >= ds.opts.MaxDepth {
+				continue
+			}
+
+			// Don't cross into other mounts such as /proc or network filesystems
+			if ds.opts.OneFileSystem && root.hasDev {
+				dev, err = deviceOf(fullPath)
+				if err != nil || dev != root.dev {
+					ds.recordSkip(fullPath, skipOtherFilesystem)
+					err = nil
+					continue
+				}
+			}
+
 			// Spawn goroutine for recursive directory search
 			// CRITICAL: Must capture fullPath by value to avoid closure bug
 			// Without this pattern, all goroutines would search the same directory
 			capturedPath := fullPath // Capture by value
 			g.Go(func() error {
 				// Recursively search the subdirectory - no worker limiting for directory traversal
-				return ds.searchDirectory(ctx, capturedPath)
+				return ds.searchDirectory(ctx, root, capturedPath, depth)
 			})
 			continue
 		}
 
-		// Handle files: check if they match the glob pattern
-		matched, err = filepath.Match(ds.glob, entry.Name())
+		// Handle files: only those within the depth limits are searched
+		if depth < ds.opts.MinDepth || (ds.opts.MaxDepth > 0 && depth > ds.opts.MaxDepth) {
+			continue
+		}
+
+		// Check if the file matches the glob pattern
+		matched, err = filepath.Match(root.Glob, entry.Name())
 		if err != nil {
 			goto end
 		}
 
-		// Skip files that don't match the pattern
-		if !matched {
+		// Skip files that don't match the pattern or that a root nested in this
+		// one searches instead
+		if !matched || (len(root.inner) > 0 && root.leaves(fullPath, ds.opts.MinDepth, ds.opts.MaxDepth)) {
 			continue
 		}
 
+		// Apply the metadata filters (--newer, --min-size, --owner, ...) before
+		// spawning anything, so filtered files never take up a worker slot
+		if len(ds.filters) > 0 {
+			if stat == nil {
+				stat, err = entry.Info()
+				if err != nil {
+					// The file vanished since the directory was read
+					err = nil
+					continue
+				}
+			}
+			if !ds.keepFile(stat) {
+				continue
+			}
+		}
+
+		// With --index, files that can't contain the pattern are never opened
+		if root.index != nil {
+			if stat == nil {
+				stat, err = entry.Info()
+				if err != nil {
+					err = nil
+					continue
+				}
+			}
+			if !root.index.mayMatch(fullPath, stat) {
+				ds.recordSkip(fullPath, skipIndexed)
+				continue
+			}
+		}
+
+		// Files that were also given explicitly are searched as given
+		if len(ds.explicit) > 0 {
+			if id, idErr := fileIDOf(fullPath); idErr == nil {
+				if _, ok := ds.explicit[id]; ok {
+					continue
+				}
+			}
+		}
+
 		// Spawn goroutine to search this file
 		// Same closure pattern as directories to avoid variable capture bug
 		capturedPath := fullPath // Capture by value
@@ -226,7 +469,7 @@ func (ds *DirSearch) processDirectoryEntries(ctx context.Context, g *errgroup.Gr
 				<-ds.workerLimiter
 			}()
 			// Search the file for matches
-			return ds.searchFile(ctx, capturedPath)
+			return ds.searchFile(ctx, capturedPath, root.displayPath(capturedPath, ds.opts.AbsolutePaths))
 		})
 	}
 
@@ -240,14 +483,11 @@ end:
 // 2. Binary file detection
 // 3. Streaming file processing with context cancellation
 // 4. Error handling without failing the entire search
-func (ds *DirSearch) searchFile(ctx context.Context, filePath string) (err error) {
+//
+// filePath is used to open the file while displayPath is how it's reported.
+func (ds *DirSearch) searchFile(ctx context.Context, filePath, displayPath string) (err error) {
 	var file *os.File
-	var scanner *bufio.Scanner
-	var lines []string
-	var lineNum int
 	var stat os.FileInfo
-	var isTextFile bool
-	var buf []byte
 
 	if ds.verbose {
 		fmt.Printf("[TRACE] Searching file: %s\n", filePath)
@@ -273,6 +513,7 @@ func (ds *DirSearch) searchFile(ctx context.Context, filePath string) (err error
 		}
 		// Skip files we can't stat (broken symlinks, permission issues, etc.)
 		// Don't fail the entire search for one problematic file
+		err = nil
 		goto end
 	}
 
@@ -284,10 +525,28 @@ func (ds *DirSearch) searchFile(ctx context.Context, filePath string) (err error
 		goto end
 	}
 
-	if stat.Size() > 50*1024*1024 {
-		if ds.verbose {
-			fmt.Printf("[TRACE] Skipping large file: %s (%d bytes)\n", filePath, stat.Size())
-		}
+	// Files reaching here were named explicitly or already checked while walking.
+	// Named pipes are allowed so process substitution like <(git diff) works,
+	// but sockets and devices are never searched
+	if reason := specialFileReason(stat.Mode()); reason != "" && reason != skipNamedPipe {
+		ds.recordSkip(filePath, reason)
+		goto end
+	}
+
+	if ds.opts.MaxFileSize > 0 && stat.Mode().IsRegular() && stat.Size() > ds.opts.MaxFileSize {
+		ds.recordSkip(filePath, skipLarge)
+		goto end
+	}
+
+	// In --replace mode the whole file is rewritten rather than scanned
+	if ds.opts.Replacing {
+		err = ds.replaceFile(ctx, filePath, displayPath, stat)
+		goto end
+	}
+
+	// With --tail the file is kept open and read as it grows
+	if ds.opts.Tail {
+		err = ds.followFile(ctx, filePath, displayPath)
 		goto end
 	}
 
@@ -298,6 +557,7 @@ func (ds *DirSearch) searchFile(ctx context.Context, filePath string) (err error
 			fmt.Printf("[TRACE] Cannot open file %s: %v\n", filePath, err)
 		}
 		// Skip files we can't open (permissions, broken symlinks, etc.)
+		err = nil
 		goto end
 	}
 	// Ensure file is closed, even if errors occur
@@ -308,35 +568,133 @@ func (ds *DirSearch) searchFile(ctx context.Context, filePath string) (err error
 		}
 	}()
 
-	// Check if file appears to be text (binary file detection)
-	isTextFile, err = isLikelyTextFile(file)
-	if err != nil || !isTextFile {
+	// In -z mode compressed files and archives are unpacked before searching
+	if ds.opts.SearchZip {
+		err = ds.searchCompressed(ctx, displayPath, file, 0)
+		goto end
+	}
+
+	err = ds.searchReader(ctx, displayPath, file)
+
+end:
+	return err
+}
+
+// fileDisplayPath returns how an explicitly added file is reported: as given,
+// or absolute when requested.
+func (ds *DirSearch) fileDisplayPath(filePath string) string {
+	if ds.opts.AbsolutePaths {
+		return absPath(filePath)
+	}
+	return filePath
+}
+
+// searchStdin searches standard input, reported as "(standard input)" like grep does.
+// Stdin is streamed, so there is no size check; -z still decompresses it.
+func (ds *DirSearch) searchStdin(ctx context.Context) (err error) {
+	if ds.verbose {
+		fmt.Printf("[TRACE] Searching standard input\n")
+	}
+	if ds.opts.Replacing {
+		err = ds.replaceStdin(ctx)
+		goto end
+	}
+	if ds.opts.SearchZip {
+		err = ds.searchCompressed(ctx, stdinName, os.Stdin, 0)
+		goto end
+	}
+	err = ds.searchReader(ctx, stdinName, os.Stdin)
+
+end:
+	return err
+}
+
+// searchReader scans already-opened content line by line and sends a Match for
+// every line matching the pattern. It is separate from searchFile so that content
+// which does not come straight from a regular file can share the same scanner.
+//
+// Content in UTF-16 or a single-byte encoding is transcoded to UTF-8 before
+// matching (see detectEncoding), so matched lines are always printed as UTF-8.
+//
+// Binary detection happens in two stages: the first 512 bytes are sampled before
+// scanning starts, and every scanned line is checked for null bytes so files with
+// a text header followed by binary data are still recognized. What happens to a
+// binary file depends on the --binary mode.
+func (ds *DirSearch) searchReader(ctx context.Context, filePath string, r io.Reader) (err error) {
+	var reader *bufio.Reader
+	var scanner *bufio.Scanner
+	var sample []byte
+	var encoding Encoding
+	var bomLen int
+	var lines []string
+	var lineNum int
+	var isBinary bool
+	var found [][]int
+	var buf []byte
+	var headingRule *regexp.Regexp
+	var lastHeading heading
+	var following bool
+
+	// Buffer the input so the encoding and binary checks can peek without consuming anything
+	_, following = r.(*tailReader)
+	reader = bufio.NewReaderSize(r, 64*1024)
+	sample, err = peekSample(reader, following)
+	if err != nil {
 		if ds.verbose {
-			if err != nil {
-				fmt.Printf("[TRACE] Error checking if %s is text: %v\n", filePath, err)
-			} else {
-				fmt.Printf("[TRACE] Skipping binary file: %s\n", filePath)
-			}
+			fmt.Printf("[TRACE] Error checking if %s is text: %v\n", filePath, err)
 		}
+		err = nil
 		goto end
 	}
 
-	// Reset file position after checking (seek back to beginning)
-	_, err = file.Seek(0, 0)
-	if err != nil {
+	// Transcode to UTF-8 first so UTF-16 text isn't mistaken for binary
+	encoding, bomLen = detectEncoding(sample, ds.opts.Encoding)
+	if encoding != EncodingUTF8 || bomLen > 0 {
 		if ds.verbose {
-			fmt.Printf("[TRACE] Cannot seek file %s: %v\n", filePath, err)
+			fmt.Printf("[TRACE] Decoding %s as %s\n", filePath, encoding)
+		}
+		_, err = reader.Discard(bomLen)
+		if err != nil {
+			goto end
 		}
+		reader = bufio.NewReaderSize(newDecoder(reader, encoding), 64*1024)
+		sample, err = peekSample(reader, following)
+		if err != nil {
+			err = nil
+			goto end
+		}
+	}
+
+	// Check if file appears to be text (binary file detection)
+	isBinary = ds.opts.Binary != BinaryText && !isLikelyText(sample)
+	if isBinary && ds.opts.Binary == BinarySkip {
+		ds.recordSkip(filePath, skipBinary)
 		goto end
 	}
 
+	// Go-aware search tokenizes and parses the whole file first
+	if !isBinary && isGoSource(filePath) && (ds.opts.GoScope != GoScopeAll || ds.opts.GoEnclosing) {
+		err = ds.searchGo(ctx, filePath, reader)
+		goto end
+	}
+
+	// Multiline patterns need the whole content instead of one line at a time
+	if ds.opts.Multiline {
+		err = ds.searchMultiline(ctx, filePath, reader, isBinary)
+		goto end
+	}
+
+	if ds.opts.ShowFunction {
+		headingRule = headingRuleFor(filePath)
+	}
+
 	// Set up scanner with increased buffer for long lines
-	scanner = bufio.NewScanner(file)
-	buf = make([]byte, 0, 64*1024) // Initial buffer size
-	scanner.Buffer(buf, 1024*1024) // Max token size (1MB)
+	scanner = bufio.NewScanner(reader)
+	buf = make([]byte, 0, 64*1024)   // Initial buffer size
+	scanner.Buffer(buf, maxLineSize) // Max token size
 
-	// Track lines for context (before/after match)
-	lines = make([]string, 0)
+	// Track the previous and current lines for context (before/after match)
+	lines = make([]string, 0, 2)
 	lineNum = 0
 
 	// Scan file line by line
@@ -356,9 +714,34 @@ func (ds *DirSearch) searchFile(ctx context.Context, filePath string) (err error
 		lineNum++
 		line := scanner.Text()
 		lines = append(lines, line)
+		if len(lines) > 2 {
+			// Only the previous line is needed for context, don't hold the whole file
+			lines = lines[1:]
+		}
 
-		// Check if current line matches the pattern
-		if !ds.pattern.MatchString(line) {
+		// Keep checking for binary data beyond the initial sample
+		if !isBinary && ds.opts.Binary != BinaryText && strings.IndexByte(line, 0) >= 0 {
+			isBinary = true
+			if ds.opts.Binary == BinarySkip {
+				if ds.verbose {
+					fmt.Printf("[TRACE] Binary data found at line %d, skipping rest of file: %s\n", lineNum, filePath)
+				}
+				goto end
+			}
+		}
+
+		// Remember the latest heading for -p; a matching heading line is its own
+		if headingRule != nil && headingRule.MatchString(line) {
+			lastHeading = heading{line: lineNum, text: strings.TrimSpace(line)}
+		}
+
+		// Find every match on the line; the spans drive highlighting and -o/--extract
+		found, err = findAll(ds.pattern, line, -1)
+		if err != nil {
+			err = fmt.Errorf("%s: line %d: %w", filePath, lineNum, err)
+			goto end
+		}
+		if len(found) == 0 {
 			continue
 		}
 
@@ -366,9 +749,19 @@ func (ds *DirSearch) searchFile(ctx context.Context, filePath string) (err error
 			fmt.Printf("[TRACE] Found match in %s at line %d\n", filePath, lineNum)
 		}
 
+		// Binary files only get a single notice instead of their (unprintable) lines
+		if isBinary {
+			err = ds.sendBinaryMatch(ctx, filePath)
+			goto end
+		}
+
 		// Send match result to output handler
 		// This demonstrates channel communication between goroutines
-		err = ds.sendMatch(ctx, filePath, lineNum, lines, len(lines)-1)
+		if ds.opts.OnlyMatching || ds.opts.Extracting {
+			err = ds.sendExtracts(ctx, filePath, lineNum, line, found)
+		} else {
+			err = ds.sendMatch(ctx, filePath, lineNum, lines, len(lines)-1, matchSpans(found), lastHeading)
+		}
 		if err != nil {
 			if ds.verbose {
 				fmt.Printf("[TRACE] Error sending match for %s: %v\n", filePath, err)
@@ -377,9 +770,13 @@ func (ds *DirSearch) searchFile(ctx context.Context, filePath string) (err error
 		}
 	}
 
-	// Check for scanner errors (EOF is normal, others are problems)
+	// Check for scanner errors (EOF is normal, others are problems). A line too
+	// long to scan only ends the search of this file
 	err = scanner.Err()
-	if err != nil {
+	if errors.Is(err, bufio.ErrTooLong) {
+		ds.recordSkip(filePath, skipLongLine)
+		err = nil
+	} else if err != nil {
 		if ds.verbose {
 			fmt.Printf("[TRACE] Scanner error for %s: %v\n", filePath, err)
 		}
@@ -391,10 +788,39 @@ end:
 	return err
 }
 
+// maxLineSize is the longest line searched line by line. A longer line ends the
+// search of its file, as it could otherwise take any amount of memory.
+const maxLineSize = 1024 * 1024
+
+// sampleSize is how much of the content the encoding and binary checks look at.
+const sampleSize = 512
+
+// peekSample returns up to the first sampleSize bytes buffered in reader without
+// consuming them. Content shorter than that is not an error. With partial set, as
+// for a followed file that may not grow to sampleSize for a long time, it settles
+// for whatever the first read returns.
+func peekSample(reader *bufio.Reader, partial bool) (sample []byte, err error) {
+	if partial {
+		_, err = reader.Peek(1)
+		sample, _ = reader.Peek(min(reader.Buffered(), sampleSize))
+		goto end
+	}
+	sample, err = reader.Peek(sampleSize)
+
+end:
+	if err == io.EOF || err == bufio.ErrBufferFull {
+		err = nil
+	}
+	return sample, err
+}
+
 // sendMatch creates a Match struct and sends it to the output handler.
 // It includes context (before/after lines) and handles channel communication
 // with proper cancellation support.
-func (ds *DirSearch) sendMatch(ctx context.Context, filePath string, lineNum int, lines []string, matchIndex int) (err error) {
+//
+// spans holds the byte offsets of each match within the line, for highlighting,
+// and h the heading shown above it with -p (none if h.line is 0).
+func (ds *DirSearch) sendMatch(ctx context.Context, filePath string, lineNum int, lines []string, matchIndex int, spans [][2]int, h heading) (err error) {
 
 	var match Match
 	var before string
@@ -414,11 +840,19 @@ func (ds *DirSearch) sendMatch(ctx context.Context, filePath string, lineNum int
 	match = Match{
 		FilePath:   filePath,
 		LineNumber: lineNum,
+		EndLine:    lineNum,
 		Line:       lines[matchIndex],
 		Before:     before,
 		After:      after,
+		Spans:      spans,
 		IsMatch:    true,
 	}
+	if len(spans) > 0 {
+		match.Column = spans[0][0] + 1
+	}
+	if h.line > 0 {
+		match.Enclosing, match.EnclosingLine = h.text, h.line
+	}
 
 	// Send to output handler with cancellation support
 	// This demonstrates non-blocking channel communication
@@ -435,9 +869,67 @@ end:
 	return err
 }
 
+// sendExtracts sends one result per match on the line instead of the line itself:
+// the matched text with -o, or the --extract template expanded with the match's
+// capture groups. Empty matches are skipped, like grep -o does.
+func (ds *DirSearch) sendExtracts(ctx context.Context, filePath string, lineNum int, line string, found [][]int) (err error) {
+	var match Match
+
+	for _, m := range found {
+		if m[0] == m[1] {
+			continue
+		}
+
+		match = Match{FilePath: filePath, LineNumber: lineNum, EndLine: lineNum, Column: m[0] + 1, IsMatch: true}
+		if ds.opts.Extracting {
+			match.Line = expandTemplate(ds.pattern, ds.opts.Extract, line, m)
+		} else {
+			match.Line = line[m[0]:m[1]]
+			match.Spans = [][2]int{{0, len(match.Line)}}
+		}
+
+		select {
+		case ds.matchChan <- match:
+		case <-ctx.Done():
+			err = ctx.Err()
+			goto end
+		}
+	}
+
+end:
+	return err
+}
+
+// matchSpans reduces submatch indexes from FindAllStringSubmatchIndex to the
+// start and end of each whole match.
+func matchSpans(found [][]int) (spans [][2]int) {
+	spans = make([][2]int, len(found))
+	for i, m := range found {
+		spans[i] = [2]int{m[0], m[1]}
+	}
+	return spans
+}
+
+// sendBinaryMatch tells the output handler that a binary file contains a match.
+// Only used in --binary=without-match mode, where the matching lines themselves
+// are not printed.
+func (ds *DirSearch) sendBinaryMatch(ctx context.Context, filePath string) (err error) {
+	select {
+	case ds.matchChan <- Match{FilePath: filePath, IsMatch: true, Binary: true}:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return err
+}
+
 // outputHandler receives match results and prints them.
 // Accesses matchChan via receiver.
+// In --replace mode it also totals the per-file summaries and prints the
+// grand total once all files are done.
 func (ds *DirSearch) outputHandler(ctx context.Context) (err error) {
+	var replacedFiles int
+	var replacements int
+
 	if ds.verbose {
 		fmt.Printf("[TRACE] Output handler started\n")
 	}
@@ -449,15 +941,25 @@ func (ds *DirSearch) outputHandler(ctx context.Context) (err error) {
 				if ds.verbose {
 					fmt.Printf("[TRACE] Match channel closed, output handler exiting\n")
 				}
+				if ds.opts.Replacing {
+					printReplaceTotal(replacedFiles, replacements, ds.opts.Write)
+				}
 				goto end
 			}
+			if !match.IsMatch && match.Replacements > 0 {
+				replacedFiles++
+				replacements += match.Replacements
+			}
 			if ds.verbose {
 				fmt.Printf("[TRACE] Received match from %s:%d\n", match.FilePath, match.LineNumber)
 			}
-			err = printMatch(match)
+			if ds.results != nil {
+				ds.results[match.FilePath] = append(ds.results[match.FilePath], match)
+			}
+			err = ds.output(match)
 			if err != nil {
 				if ds.verbose {
-					fmt.Printf("[TRACE] Error printing match: %v\n", err)
+					fmt.Printf("[TRACE] Error outputting match: %v\n", err)
 				}
 				goto end
 			}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestLongLine checks that a line longer than the scanner takes only ends the
+// search of its file, also for binary files searched as text.
+func TestLongLine(t *testing.T) {
+	long := strings.Repeat("x", maxLineSize+1)
+	dir := writeTree(t, map[string]string{
+		"a.txt": "needle\n" + long + "\nneedle\n",
+		"b.bin": "\x00\x01" + long + "needle\n",
+		"c.txt": "hay\nneedle\n",
+	})
+
+	for _, mode := range []BinaryMode{BinarySkip, BinaryText, BinaryWithoutMatch} {
+		opts := DefaultOptions()
+		opts.Binary = mode
+		ds := NewDirSearch([]Root{{Dir: dir, Glob: "*", Display: dir}}, regexp.MustCompile("needle"), opts)
+		var lines []string
+		ds.SetOutput(func(match Match) error {
+			lines = append(lines, fmt.Sprintf("%s:%d", filepath.Base(match.FilePath), match.LineNumber))
+			return nil
+		})
+		if err := ds.Run(context.Background()); err != nil {
+			t.Errorf("--binary=%s: Run returned %v", mode, err)
+			continue
+		}
+		slices.Sort(lines)
+		if want := []string{"a.txt:1", "c.txt:2"}; !slices.Equal(lines, want) {
+			t.Errorf("--binary=%s: matches %q, want %q", mode, lines, want)
+		}
+		if ds.Skipped()[skipLongLine] == 0 {
+			t.Errorf("--binary=%s: skipped %v, want a long line", mode, ds.Skipped())
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding names the character encoding used to decode file content before matching.
+type Encoding string
+
+const (
+	// EncodingAuto sniffs byte order marks and UTF-16 patterns, falling back to
+	// Windows-1252 for content that is not valid UTF-8 (the default).
+	EncodingAuto Encoding = "auto"
+	// EncodingUTF8 treats content as UTF-8, only stripping a leading BOM.
+	EncodingUTF8 Encoding = "utf-8"
+	// EncodingUTF16LE decodes little-endian UTF-16, as written by most Windows tools.
+	EncodingUTF16LE Encoding = "utf-16le"
+	// EncodingUTF16BE decodes big-endian UTF-16.
+	EncodingUTF16BE Encoding = "utf-16be"
+	// EncodingLatin1 decodes ISO-8859-1, where every byte is its own code point.
+	EncodingLatin1 Encoding = "latin1"
+	// EncodingWindows1252 decodes the Windows "ANSI" code page, a superset of Latin-1.
+	EncodingWindows1252 Encoding = "windows-1252"
+)
+
+// parseEncoding validates the value of the --encoding option, accepting common aliases.
+func parseEncoding(s string) (enc Encoding, err error) {
+	switch strings.ToLower(s) {
+	case "auto":
+		enc = EncodingAuto
+	case "utf-8", "utf8":
+		enc = EncodingUTF8
+	case "utf-16le", "utf16le", "utf-16", "utf16":
+		enc = EncodingUTF16LE
+	case "utf-16be", "utf16be":
+		enc = EncodingUTF16BE
+	case "latin1", "latin-1", "iso-8859-1", "iso8859-1":
+		enc = EncodingLatin1
+	case "windows-1252", "cp1252":
+		enc = EncodingWindows1252
+	default:
+		err = fmt.Errorf("unsupported --encoding %q (want auto, utf-8, utf-16le, utf-16be, latin1 or windows-1252)", s)
+	}
+	return enc, err
+}
+
+// detectEncoding decides how to decode content given a sample from its start.
+// A byte order mark always wins and its length is returned so it can be discarded.
+// Otherwise an explicitly requested encoding is used as-is, while EncodingAuto
+// looks for the NUL-byte pattern of ASCII text stored as UTF-16 and for invalid
+// UTF-8, which usually means a legacy single-byte encoding.
+func detectEncoding(sample []byte, requested Encoding) (enc Encoding, bomLen int) {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}):
+		enc, bomLen = EncodingUTF8, 3
+		goto end
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		enc, bomLen = EncodingUTF16LE, 2
+		goto end
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		enc, bomLen = EncodingUTF16BE, 2
+		goto end
+	}
+
+	if requested != EncodingAuto {
+		enc = requested
+		goto end
+	}
+
+	enc = guessUTF16(sample)
+	if enc != "" {
+		goto end
+	}
+
+	enc = EncodingUTF8
+	if bytes.IndexByte(sample, 0) < 0 && !validUTF8Prefix(sample) {
+		enc = EncodingWindows1252
+	}
+
+end:
+	return enc, bomLen
+}
+
+// guessUTF16 recognizes BOM-less UTF-16 by where the NUL bytes are: mostly-ASCII
+// text has a zero in every high byte, so NULs cluster on either the odd (LE) or
+// even (BE) offsets. Returns "" when the sample doesn't look like UTF-16.
+func guessUTF16(sample []byte) (enc Encoding) {
+	var evenNulls int
+	var oddNulls int
+	var pairs int
+
+	pairs = len(sample) / 2
+	if pairs < 4 {
+		goto end
+	}
+	for i := 0; i+1 < len(sample); i += 2 {
+		if sample[i] == 0 {
+			evenNulls++
+		}
+		if sample[i+1] == 0 {
+			oddNulls++
+		}
+	}
+
+	// Require most high bytes to be zero and almost no low bytes
+	switch {
+	case oddNulls*10 >= pairs*7 && evenNulls*20 <= pairs:
+		enc = EncodingUTF16LE
+	case evenNulls*10 >= pairs*7 && oddNulls*20 <= pairs:
+		enc = EncodingUTF16BE
+	}
+
+end:
+	return enc
+}
+
+// validUTF8Prefix reports whether sample is valid UTF-8, ignoring a multi-byte
+// sequence cut off at the end of the sample.
+func validUTF8Prefix(sample []byte) (valid bool) {
+	valid = len(sample) == 0
+	for i := 0; i < 3 && len(sample) > 0; i++ {
+		if utf8.Valid(sample) {
+			valid = true
+			break
+		}
+		sample = sample[:len(sample)-1]
+	}
+	return valid
+}
+
+// newDecoder wraps r so that reading from it yields UTF-8 regardless of enc.
+// UTF-8 content is returned unchanged. Line terminators survive transcoding,
+// so line numbers match those of the original file.
+func newDecoder(r io.Reader, enc Encoding) (decoder io.Reader) {
+	switch enc {
+	case EncodingUTF16LE:
+		decoder = &utf16Decoder{src: r}
+	case EncodingUTF16BE:
+		decoder = &utf16Decoder{src: r, bigEndian: true}
+	case EncodingLatin1:
+		decoder = &singleByteDecoder{src: r, table: &latin1Table}
+	case EncodingWindows1252:
+		decoder = &singleByteDecoder{src: r, table: &windows1252Table}
+	default:
+		decoder = r
+	}
+	return decoder
+}
+
+// utf16Decoder transcodes a UTF-16 stream into UTF-8. Invalid code units and
+// unpaired surrogates are replaced with U+FFFD rather than failing the search.
+type utf16Decoder struct {
+	src       io.Reader
+	bigEndian bool
+	in        []byte // Raw bytes not yet decoded (an odd byte or a split surrogate pair)
+	out       []byte // Decoded UTF-8 not yet returned to the caller
+	err       error  // Error from src, returned once out is drained
+}
+
+// Read implements io.Reader.
+func (d *utf16Decoder) Read(p []byte) (n int, err error) {
+	for len(d.out) == 0 && d.err == nil {
+		d.fill()
+	}
+	n = copy(p, d.out)
+	d.out = d.out[n:]
+	if len(d.out) == 0 && n == 0 {
+		err = d.err
+	}
+	return n, err
+}
+
+// fill reads the next chunk from src and decodes every complete code unit in it.
+func (d *utf16Decoder) fill() {
+	var chunk [4096]byte
+	var n int
+	var i int
+
+	n, d.err = d.src.Read(chunk[:])
+	d.in = append(d.in, chunk[:n]...)
+
+	for i = 0; i+1 < len(d.in); i += 2 {
+		r := rune(d.unit(d.in[i:]))
+		if r < 0xD800 || r > 0xDBFF {
+			// Not a high surrogate: either a plain code point or an unpaired low surrogate
+			if utf16.IsSurrogate(r) {
+				r = utf8.RuneError
+			}
+			d.out = utf8.AppendRune(d.out, r)
+			continue
+		}
+		if i+3 >= len(d.in) {
+			if d.err == nil {
+				// Wait for the rest of the surrogate pair
+				break
+			}
+			d.out = utf8.AppendRune(d.out, utf8.RuneError)
+			continue
+		}
+		pair := utf16.DecodeRune(r, rune(d.unit(d.in[i+2:])))
+		if pair != utf8.RuneError {
+			i += 2
+		}
+		d.out = utf8.AppendRune(d.out, pair)
+	}
+	d.in = append(d.in[:0], d.in[i:]...)
+
+	// A dangling odd byte at end of input can't be decoded
+	if d.err != nil && len(d.in) > 0 {
+		d.out = utf8.AppendRune(d.out, utf8.RuneError)
+		d.in = d.in[:0]
+	}
+}
+
+// unit reads one 16-bit code unit in the decoder's byte order.
+func (d *utf16Decoder) unit(b []byte) uint16 {
+	if d.bigEndian {
+		return uint16(b[0])<<8 | uint16(b[1])
+	}
+	return uint16(b[1])<<8 | uint16(b[0])
+}
+
+// singleByteDecoder transcodes an 8-bit encoding into UTF-8 using a lookup table.
+type singleByteDecoder struct {
+	src   io.Reader
+	table *[256]rune
+	out   []byte
+}
+
+// Read implements io.Reader.
+func (d *singleByteDecoder) Read(p []byte) (n int, err error) {
+	var chunk [4096]byte
+	var m int
+
+	if len(d.out) == 0 {
+		m, err = d.src.Read(chunk[:])
+		for _, b := range chunk[:m] {
+			d.out = utf8.AppendRune(d.out, d.table[b])
+		}
+	}
+	n = copy(p, d.out)
+	d.out = d.out[n:]
+	if len(d.out) > 0 {
+		// More decoded data is pending; report the error on a later call
+		err = nil
+	}
+	return n, err
+}
+
+// latin1Table maps ISO-8859-1 bytes to their identical Unicode code points.
+var latin1Table = func() (table [256]rune) {
+	for i := range table {
+		table[i] = rune(i)
+	}
+	return table
+}()
+
+// windows1252Table is Latin-1 with the C1 control range replaced by the
+// punctuation and letters Windows puts there. Undefined bytes map to U+FFFD.
+var windows1252Table = func() (table [256]rune) {
+	table = latin1Table
+	copy(table[0x80:0xA0], []rune{
+		'€', '�', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '�', 'Ž', '�',
+		'�', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '�', 'ž', 'Ÿ',
+	})
+	return table
+}()
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Matcher is the regular expression engine a DirSearch matches with. It exposes
+// match indexes rather than just a yes/no answer so that highlighting, -o,
+// --extract and --replace work the same whatever engine is selected.
+//
+// *regexp.Regexp (RE2, the default) satisfies it directly; -P selects the
+// backtracking engine in backtrack.go, which adds lookaround and backreferences.
+type Matcher interface {
+	// FindAllStringSubmatchIndex returns, for up to n successive non-overlapping
+	// matches (all if n < 0), the start/end byte offsets of the whole match
+	// followed by those of each capture group (-1 if the group didn't take part).
+	FindAllStringSubmatchIndex(s string, n int) [][]int
+	// SubexpNames returns the names of the capture groups, with "" for unnamed
+	// groups and for index 0, the whole match.
+	SubexpNames() []string
+	// String returns the source text of the pattern.
+	String() string
+}
+
+// compilePattern compiles expr with the selected engine: RE2 by default, or the
+// backtracking engine when pcre is set.
+func compilePattern(expr string, pcre bool) (m Matcher, err error) {
+	if pcre {
+		m, err = compileBacktrack(expr)
+		goto end
+	}
+	m, err = regexp.Compile(expr)
+
+end:
+	return m, err
+}
+
+// expandTemplate builds the text for one match from a template, the way
+// regexp.Regexp.Expand does: $1 or ${1} is a numbered group, $name or ${name}
+// a named one, and $$ a literal dollar sign. References to groups that don't
+// exist or didn't take part in the match expand to nothing.
+func expandTemplate(m Matcher, template, src string, match []int) (result string) {
+	var sb strings.Builder
+	var name string
+	var rest string
+	var ok bool
+
+	for {
+		i := strings.IndexByte(template, '$')
+		if i < 0 {
+			break
+		}
+		sb.WriteString(template[:i])
+		template = template[i:]
+
+		if strings.HasPrefix(template, "$$") {
+			sb.WriteByte('$')
+			template = template[2:]
+			continue
+		}
+
+		name, rest, ok = templateReference(template)
+		if !ok {
+			// Malformed reference: keep the dollar sign literally
+			sb.WriteByte('$')
+			template = template[1:]
+			continue
+		}
+		template = rest
+		sb.WriteString(groupText(m, name, src, match))
+	}
+	sb.WriteString(template)
+	result = sb.String()
+	return result
+}
+
+// templateReference parses the group reference at the start of template, which
+// begins with "$", returning the group name or number and the text after it.
+func templateReference(template string) (name string, rest string, ok bool) {
+	var i int
+
+	template = template[1:]
+	if strings.HasPrefix(template, "{") {
+		i = strings.IndexByte(template, '}')
+		if i < 1 {
+			goto end
+		}
+		name, rest, ok = template[1:i], template[i+1:], true
+		goto end
+	}
+
+	for i < len(template) && isWordByte(template[i]) {
+		i++
+	}
+	if i == 0 {
+		goto end
+	}
+	name, rest, ok = template[:i], template[i:], true
+
+end:
+	return name, rest, ok
+}
+
+// groupText returns the text captured by the group with the given name or number.
+func groupText(m Matcher, name, src string, match []int) (text string) {
+	var index int = -1
+
+	if n, ok := parseGroupNumber(name); ok {
+		index = n
+	} else {
+		for i, groupName := range m.SubexpNames() {
+			if groupName == name && i > 0 {
+				index = i
+				break
+			}
+		}
+	}
+
+	if index < 0 || 2*index+1 >= len(match) || match[2*index] < 0 {
+		goto end
+	}
+	text = src[match[2*index]:match[2*index+1]]
+
+end:
+	return text
+}
+
+// parseGroupNumber parses a decimal group number, rejecting anything else.
+func parseGroupNumber(s string) (n int, ok bool) {
+	if s == "" {
+		goto end
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			goto end
+		}
+		n = n*10 + int(s[i]-'0')
+		if n > 1e6 {
+			goto end
+		}
+	}
+	ok = true
+
+end:
+	return n, ok
+}
+
+// fallibleMatcher is a Matcher that can give up before finding every match, as
+// the backtracking engine does once its step budget is spent.
+type fallibleMatcher interface {
+	Matcher
+	findAll(s string, n int) ([][]int, error)
+}
+
+// findAll is m.FindAllStringSubmatchIndex, but fails instead of returning only
+// some of the matches when m gives up.
+func findAll(m Matcher, s string, n int) (found [][]int, err error) {
+	if fm, ok := m.(fallibleMatcher); ok {
+		return fm.findAll(s, n)
+	}
+	return m.FindAllStringSubmatchIndex(s, n), nil
+}
+
+// replaceAllTemplate replaces every match in src with the expanded template and
+// returns the new text along with the number of replacements made.
+func replaceAllTemplate(m Matcher, src, template string) (result string, count int, err error) {
+	var sb strings.Builder
+	var last int
+	var found [][]int
+
+	found, err = findAll(m, src, -1)
+	if err != nil || len(found) == 0 {
+		result = src
+		goto end
+	}
+	for _, match := range found {
+		sb.WriteString(src[last:match[0]])
+		sb.WriteString(expandTemplate(m, template, src, match))
+		last = match[1]
+	}
+	sb.WriteString(src[last:])
+	result, count = sb.String(), len(found)
+
+end:
+	return result, count, err
+}
+
+// isWordByte reports whether b is an ASCII word character: [0-9A-Za-z_].
+func isWordByte(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
@@ -0,0 +1,40 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// fileID identifies a file or directory. Without device and inode numbers the
+// fully resolved path is used, which still collapses symlinks to the same target.
+type fileID struct {
+	path string
+}
+
+// fileIDOf returns the identity of path, following symlinks.
+func fileIDOf(path string) (id fileID, err error) {
+	var resolved string
+
+	resolved, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		goto end
+	}
+	id.path, err = filepath.Abs(resolved)
+
+end:
+	return id, err
+}
+
+// deviceOf is not supported on this platform, so --one-file-system has no effect.
+func deviceOf(path string) (dev uint64, err error) {
+	err = errors.New("device numbers are not available on this platform")
+	return dev, err
+}
+
+// ownerOf is not supported on this platform, so --owner matches no files.
+func ownerOf(info os.FileInfo) (uid int, ok bool) {
+	return uid, ok
+}
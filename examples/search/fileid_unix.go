@@ -0,0 +1,62 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileID uniquely identifies a file or directory on this machine.
+type fileID struct {
+	dev uint64 // Device the file lives on
+	ino uint64 // Inode number within that device
+}
+
+// fileIDOf returns the device and inode of path, following symlinks.
+func fileIDOf(path string) (id fileID, err error) {
+	var stat os.FileInfo
+
+	stat, err = os.Stat(path)
+	if err != nil {
+		goto end
+	}
+	id, err = fileIDFromInfo(stat)
+
+end:
+	return id, err
+}
+
+// fileIDFromInfo extracts the device and inode from already-fetched file info.
+func fileIDFromInfo(info os.FileInfo) (id fileID, err error) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		err = fmt.Errorf("no device/inode information for %s", info.Name())
+		goto end
+	}
+	id = fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+
+end:
+	return id, err
+}
+
+// deviceOf returns the device number of the filesystem path lives on.
+func deviceOf(path string) (dev uint64, err error) {
+	var id fileID
+
+	id, err = fileIDOf(path)
+	dev = id.dev
+	return dev, err
+}
+
+// ownerOf returns the user ID owning the file described by info.
+func ownerOf(info os.FileInfo) (uid int, ok bool) {
+	var st *syscall.Stat_t
+
+	st, ok = info.Sys().(*syscall.Stat_t)
+	if ok {
+		uid = int(st.Uid)
+	}
+	return uid, ok
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileFilter decides from a file's metadata whether it should be searched.
+// Filters run while walking, before a goroutine or worker slot is used for the file.
+type fileFilter func(info os.FileInfo) (keep bool)
+
+// buildFilters turns the metadata options into a list of filters that must all
+// accept a file for it to be searched. Returns nil when no filter is configured.
+func buildFilters(opts Options) (filters []fileFilter) {
+	if !opts.Newer.IsZero() {
+		filters = append(filters, func(info os.FileInfo) bool {
+			return info.ModTime().After(opts.Newer)
+		})
+	}
+	if !opts.Older.IsZero() {
+		filters = append(filters, func(info os.FileInfo) bool {
+			return info.ModTime().Before(opts.Older)
+		})
+	}
+	if opts.MinSize > 0 {
+		filters = append(filters, func(info os.FileInfo) bool {
+			return info.Size() >= opts.MinSize
+		})
+	}
+	if opts.MaxSize > 0 {
+		filters = append(filters, func(info os.FileInfo) bool {
+			return info.Size() <= opts.MaxSize
+		})
+	}
+	if opts.Owner >= 0 {
+		filters = append(filters, func(info os.FileInfo) bool {
+			uid, ok := ownerOf(info)
+			return ok && uid == opts.Owner
+		})
+	}
+	return filters
+}
+
+// keepFile reports whether every configured filter accepts the file.
+func (ds *DirSearch) keepFile(info os.FileInfo) (keep bool) {
+	keep = true
+	for _, filter := range ds.filters {
+		if !filter(info) {
+			keep = false
+			break
+		}
+	}
+	return keep
+}
+
+// parseTimeSpec interprets the value of --newer or --older. It accepts a duration
+// back from now ("90m", "24h", "2d", "1w"), a date or timestamp ("2024-05-01",
+// "2024-05-01 13:00:00", RFC 3339), or the path of a reference file whose
+// modification time is used.
+func parseTimeSpec(s string) (t time.Time, err error) {
+	var duration time.Duration
+	var stat os.FileInfo
+
+	duration, err = parseAge(s)
+	if err == nil {
+		t = time.Now().Add(-duration)
+		goto end
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		t, err = time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			goto end
+		}
+	}
+
+	stat, err = os.Stat(s)
+	if err != nil {
+		err = fmt.Errorf("invalid time %q (want a duration like 2d, a date like 2006-01-02, or an existing file)", s)
+		goto end
+	}
+	t = stat.ModTime()
+
+end:
+	return t, err
+}
+
+// parseAge parses a duration, adding "d" (days) and "w" (weeks) to the units
+// understood by time.ParseDuration.
+func parseAge(s string) (duration time.Duration, err error) {
+	var count int
+	var unit time.Duration
+
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	default:
+		duration, err = time.ParseDuration(s)
+		goto end
+	}
+
+	count, err = strconv.Atoi(s[:len(s)-1])
+	if err != nil {
+		goto end
+	}
+	duration = time.Duration(count) * unit
+
+end:
+	return duration, err
+}
+
+// parseOwner resolves the value of --owner, a user name or numeric user ID.
+func parseOwner(s string) (uid int, err error) {
+	var usr *user.User
+
+	uid, err = strconv.Atoi(s)
+	if err == nil {
+		if uid < 0 {
+			err = fmt.Errorf("invalid --owner %q (want a user name or a non-negative user ID)", s)
+		}
+		goto end
+	}
+
+	usr, err = user.Lookup(s)
+	if err != nil {
+		goto end
+	}
+	uid, err = strconv.Atoi(usr.Uid)
+	if err != nil {
+		err = fmt.Errorf("user %s has no numeric user ID", s)
+	}
+
+end:
+	return uid, err
+}
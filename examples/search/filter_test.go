@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os/user"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseOwner(t *testing.T) {
+	for _, s := range []string{"0", "1000", "+7"} {
+		want, _ := strconv.Atoi(s)
+		if uid, err := parseOwner(s); err != nil || uid != want {
+			t.Errorf("parseOwner(%q) = %d, %v; want %d", s, uid, err, want)
+		}
+	}
+
+	for _, s := range []string{"-1", "-5"} {
+		if _, err := parseOwner(s); err == nil || !strings.Contains(err.Error(), "non-negative") {
+			t.Errorf("parseOwner(%q) error = %v, want a non-negative user ID error", s, err)
+		}
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	want, err := strconv.Atoi(current.Uid)
+	if err != nil {
+		t.Skipf("user %s has no numeric user ID", current.Username)
+	}
+	if uid, err := parseOwner(current.Username); err != nil || uid != want {
+		t.Errorf("parseOwner(%q) = %d, %v; want %d", current.Username, uid, err, want)
+	}
+}
+
+func TestParseOptionsOwner(t *testing.T) {
+	opts := DefaultOptions()
+	if _, err := parseOptions([]string{"--owner=-5"}, &opts); err == nil {
+		t.Errorf("--owner=-5 was accepted, owner %d", opts.Owner)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// followPollInterval is how often a followed file is checked for new data,
+// truncation and rotation once everything written so far has been read.
+const followPollInterval = 250 * time.Millisecond
+
+// tailReader reads a file like `tail -F`: at the end of the data it waits for
+// more instead of returning io.EOF. It only reports the end when the context is
+// cancelled or the file was truncated or replaced, after switching to reading
+// the new content from its start, so the caller can scan it again.
+type tailReader struct {
+	ctx       context.Context
+	path      string
+	file      *os.File
+	offset    int64 // Bytes read from file
+	restarted bool  // The last io.EOF was because of truncation or rotation
+	verbose   bool
+}
+
+// followFile searches a file and then keeps searching what is appended to it
+// until ctx is cancelled, starting over when the file is truncated or rotated.
+// It reuses searchReader for the scanning, so matches are reported as usual.
+func (ds *DirSearch) followFile(ctx context.Context, filePath, displayPath string) (err error) {
+	var tail *tailReader
+
+	tail = &tailReader{ctx: ctx, path: filePath, verbose: ds.verbose}
+	tail.file, err = os.Open(filePath)
+	if err != nil {
+		if ds.verbose {
+			fmt.Printf("[TRACE] Cannot open file %s: %v\n", filePath, err)
+		}
+		err = nil
+		goto end
+	}
+	defer func() {
+		if closeErr := tail.file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	for {
+		tail.restarted = false
+		err = ds.searchReader(ctx, displayPath, tail)
+		if err != nil {
+			goto end
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			goto end
+		}
+		// Anything else that ended the scan early, such as a binary file being
+		// skipped, also ends following it
+		if !tail.restarted {
+			goto end
+		}
+	}
+
+end:
+	return err
+}
+
+// Read implements io.Reader, waiting at the end of the data as described above.
+func (t *tailReader) Read(p []byte) (n int, err error) {
+	var restart bool
+
+	for {
+		n, err = t.file.Read(p)
+		t.offset += int64(n)
+		if n > 0 {
+			err = nil
+			goto end
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			goto end
+		}
+
+		// Everything written so far has been read
+		err = io.EOF
+		select {
+		case <-t.ctx.Done():
+			goto end
+		case <-time.After(followPollInterval):
+		}
+
+		restart, err = t.reopen()
+		if err != nil {
+			goto end
+		}
+		if restart {
+			t.restarted, err = true, io.EOF
+			goto end
+		}
+	}
+
+end:
+	return n, err
+}
+
+// reopen handles a truncated file by reading it again from the start, and a
+// rotated one, whose path now names a different file, by switching to the new
+// file once the rest of the old one has been read. While the path doesn't exist
+// the old file is kept, as `tail -F` does.
+func (t *tailReader) reopen() (restart bool, err error) {
+	var stat os.FileInfo
+	var current os.FileInfo
+	var file *os.File
+
+	stat, err = t.file.Stat()
+	if err != nil {
+		goto end
+	}
+
+	if stat.Size() < t.offset {
+		if t.verbose {
+			fmt.Printf("[TRACE] %s was truncated, reading it from the start\n", t.path)
+		}
+		_, err = t.file.Seek(0, io.SeekStart)
+		t.offset, restart = 0, true
+		goto end
+	}
+
+	current, err = os.Stat(t.path)
+	if err != nil || os.SameFile(stat, current) || stat.Size() > t.offset {
+		err = nil
+		goto end
+	}
+
+	file, err = os.Open(t.path)
+	if err != nil {
+		// Replaced again in between; try on the next poll
+		err = nil
+		goto end
+	}
+	if t.verbose {
+		fmt.Printf("[TRACE] %s was replaced, following the new file\n", t.path)
+	}
+	err = t.file.Close()
+	t.file, t.offset, restart = file, 0, true
+
+end:
+	return restart, err
+}
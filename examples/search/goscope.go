@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io"
+	"sort"
+	"strings"
+)
+
+// GoScope restricts matches in .go files to one kind of token (--go-scope).
+type GoScope string
+
+const (
+	// GoScopeAll matches anywhere, like any other file (the default).
+	GoScopeAll GoScope = ""
+	// GoScopeCode matches outside comments and string or rune literals.
+	GoScopeCode GoScope = "code"
+	// GoScopeComments matches only inside comments.
+	GoScopeComments GoScope = "comments"
+	// GoScopeStrings matches only inside string and rune literals.
+	GoScopeStrings GoScope = "strings"
+	// GoScopeIdentifiers matches only inside identifiers.
+	GoScopeIdentifiers GoScope = "identifiers"
+)
+
+// parseGoScope validates the value of --go-scope.
+func parseGoScope(s string) (scope GoScope, err error) {
+	scope = GoScope(s)
+	switch scope {
+	case GoScopeCode, GoScopeComments, GoScopeStrings, GoScopeIdentifiers:
+	default:
+		err = fmt.Errorf("invalid --go-scope %q (want code, comments, strings or identifiers)", s)
+	}
+	return scope, err
+}
+
+// isGoSource reports whether a file should get Go-aware treatment.
+func isGoSource(filePath string) bool {
+	return strings.HasSuffix(filePath, ".go")
+}
+
+// goTokenRanges are the byte ranges of the tokens a GoScope selects. With
+// exclude set they are instead the ranges a match must stay out of, which is
+// how "code" is expressed: everything but comments and literals.
+type goTokenRanges struct {
+	ranges  [][2]int // Sorted and non-overlapping [start, end) offsets
+	exclude bool
+}
+
+// scanGoScope tokenizes src with go/scanner and collects the ranges for scope.
+// Syntax errors don't stop the scan, so files that don't compile still work.
+func scanGoScope(src string, scope GoScope) (result goTokenRanges) {
+	var s scanner.Scanner
+	var file *token.File
+	var data []byte
+
+	data = []byte(src)
+	file = token.NewFileSet().AddFile("", -1, len(data))
+	s.Init(file, data, nil, scanner.ScanComments)
+	result.exclude = scope == GoScopeCode
+
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		var wanted bool
+		switch scope {
+		case GoScopeCode:
+			wanted = tok == token.COMMENT || tok == token.STRING || tok == token.CHAR
+		case GoScopeComments:
+			wanted = tok == token.COMMENT
+		case GoScopeStrings:
+			wanted = tok == token.STRING || tok == token.CHAR
+		case GoScopeIdentifiers:
+			wanted = tok == token.IDENT
+		}
+		if !wanted {
+			continue
+		}
+		start := file.Offset(pos)
+		result.ranges = append(result.ranges, [2]int{start, goTokenEnd(src, start, lit)})
+	}
+	return result
+}
+
+// goTokenEnd returns the offset just past the token starting at start. The
+// scanner drops carriage returns from comments and raw strings, so their
+// length is taken from the source rather than from lit.
+func goTokenEnd(src string, start int, lit string) (end int) {
+	var i int
+
+	end = start + len(lit)
+	switch {
+	case strings.HasPrefix(src[start:], "//"):
+		i = strings.IndexByte(src[start:], '\n')
+	case strings.HasPrefix(src[start:], "/*"):
+		i = strings.Index(src[start+2:], "*/")
+		if i >= 0 {
+			i += 4
+		}
+	case strings.HasPrefix(src[start:], "`"):
+		i = strings.IndexByte(src[start+1:], '`')
+		if i >= 0 {
+			i += 2
+		}
+	default:
+		goto end
+	}
+	end = len(src)
+	if i >= 0 {
+		end = start + i
+	}
+
+end:
+	return end
+}
+
+// allows reports whether the match [start, end) is inside one selected token,
+// or with exclude set, clear of all of them.
+func (g goTokenRanges) allows(start, end int) bool {
+	// First range that ends after the match starts
+	i := sort.Search(len(g.ranges), func(i int) bool {
+		return g.ranges[i][1] > start
+	})
+	if g.exclude {
+		return i == len(g.ranges) || g.ranges[i][0] >= end
+	}
+	return i < len(g.ranges) && g.ranges[i][0] <= start && end <= g.ranges[i][1]
+}
+
+// filter keeps the matches allowed by the scope. Match offsets are relative to
+// a line starting at offset base in the file.
+func (g goTokenRanges) filter(found [][]int, base int) (result [][]int) {
+	for _, m := range found {
+		if g.allows(base+m[0], base+m[1]) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// goDecl is a top-level declaration that can enclose a match.
+type goDecl struct {
+	start, end int    // Byte offsets covered by the declaration
+	line       int    // Line the declaration starts on
+	name       string // e.g. "func (*DirSearch) Run" or "type Root"
+}
+
+// parseGoDecls lists the functions, methods and types declared in src, in
+// source order. go/parser returns a partial tree for files with syntax errors,
+// and whatever declarations it recovered are still used.
+func parseGoDecls(filePath, src string) (decls []goDecl) {
+	var fset *token.FileSet
+	var file *ast.File
+
+	fset = token.NewFileSet()
+	file, _ = parser.ParseFile(fset, filePath, src, parser.SkipObjectResolution)
+	if file == nil {
+		goto end
+	}
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			name := "func " + d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				name = fmt.Sprintf("func (%s) %s", types.ExprString(d.Recv.List[0].Type), d.Name.Name)
+			}
+			decls = append(decls, newGoDecl(fset, d.Pos(), d.End(), name))
+		case *ast.GenDecl:
+			if d.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range d.Specs {
+				ts := spec.(*ast.TypeSpec)
+				pos := ts.Pos()
+				if !d.Lparen.IsValid() {
+					// Point at the "type" keyword rather than the name
+					pos = d.Pos()
+				}
+				decls = append(decls, newGoDecl(fset, pos, ts.End(), "type "+ts.Name.Name))
+			}
+		}
+	}
+
+end:
+	return decls
+}
+
+// newGoDecl converts positions into offsets and a line number.
+func newGoDecl(fset *token.FileSet, pos, end token.Pos, name string) goDecl {
+	start := fset.Position(pos)
+	return goDecl{start: start.Offset, end: fset.Position(end).Offset, line: start.Line, name: name}
+}
+
+// enclosingDecl returns the declaration containing offset, if any.
+func enclosingDecl(decls []goDecl, offset int) (decl goDecl, ok bool) {
+	i := sort.Search(len(decls), func(i int) bool {
+		return decls[i].end > offset
+	})
+	if i < len(decls) && decls[i].start <= offset {
+		decl, ok = decls[i], true
+	}
+	return decl, ok
+}
+
+// searchGo searches a .go file with --go-scope and --go-enclosing applied. The
+// whole file is read so it can be tokenized and parsed; matches outside the
+// selected scope are dropped and line results are tagged with the function or
+// type they are in.
+func (ds *DirSearch) searchGo(ctx context.Context, filePath string, r io.Reader) (err error) {
+	var data []byte
+	var content string
+	var scope goTokenRanges
+	var decls []goDecl
+	var lineStarts []int
+	var found [][]int
+
+	data, err = io.ReadAll(r)
+	if err != nil {
+		if errors.Is(err, errSizeLimit) {
+			ds.recordSkip(filePath, skipLarge)
+		} else if ds.verbose {
+			fmt.Printf("[TRACE] Cannot read %s: %v\n", filePath, err)
+		}
+		err = nil
+		goto end
+	}
+	content = string(data)
+
+	if ds.opts.GoScope != GoScopeAll {
+		scope = scanGoScope(content, ds.opts.GoScope)
+	}
+	if ds.opts.GoEnclosing {
+		decls = parseGoDecls(filePath, content)
+	}
+	lineStarts = computeLineStarts(content)
+
+	if ds.opts.Multiline {
+		found, err = findAll(ds.pattern, content, -1)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", filePath, err)
+			goto end
+		}
+		found = nonEmptyMatches(ds.filterScope(scope, found, 0))
+		if len(found) == 0 {
+			goto end
+		}
+		if ds.opts.OnlyMatching || ds.opts.Extracting {
+			err = ds.sendMultilineExtracts(ctx, filePath, content, lineStarts, found)
+			goto end
+		}
+		err = ds.sendMultilineMatches(ctx, filePath, content, lineStarts, found)
+		goto end
+	}
+
+	for i, start := range lineStarts {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			goto end
+		default:
+		}
+
+		line := lineText(content, lineStarts, i)
+		found, err = findAll(ds.pattern, line, -1)
+		if err != nil {
+			err = fmt.Errorf("%s: line %d: %w", filePath, i+1, err)
+			goto end
+		}
+		found = ds.filterScope(scope, found, start)
+		if len(found) == 0 {
+			continue
+		}
+
+		if ds.opts.OnlyMatching || ds.opts.Extracting {
+			err = ds.sendExtracts(ctx, filePath, i+1, line, found)
+			if err != nil {
+				goto end
+			}
+			continue
+		}
+
+		match := Match{
+			FilePath:   filePath,
+			LineNumber: i + 1,
+			EndLine:    i + 1,
+			Line:       line,
+			Column:     found[0][0] + 1,
+			Spans:      matchSpans(found),
+			IsMatch:    true,
+		}
+		if i > 0 {
+			match.Before = lineText(content, lineStarts, i-1)
+		}
+		if i+1 < len(lineStarts) {
+			match.After = lineText(content, lineStarts, i+1)
+		}
+		if decl, ok := enclosingDecl(decls, start+found[0][0]); ok {
+			match.Enclosing, match.EnclosingLine = decl.name, decl.line
+		} else if ds.opts.ShowFunction && !ds.opts.GoEnclosing {
+			h := headingAbove(headingRuleFor(filePath), content, lineStarts, i+1)
+			match.Enclosing, match.EnclosingLine = h.text, h.line
+		}
+
+		select {
+		case ds.matchChan <- match:
+		case <-ctx.Done():
+			err = ctx.Err()
+			goto end
+		}
+	}
+
+end:
+	return err
+}
+
+// filterScope applies --go-scope to matches found at offset base, or returns
+// them unchanged when no scope is selected.
+func (ds *DirSearch) filterScope(scope goTokenRanges, found [][]int, base int) [][]int {
+	if ds.opts.GoScope == GoScopeAll {
+		return found
+	}
+	return scope.filter(found, base)
+}
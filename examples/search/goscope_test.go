@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+// TestGoScopeLastLine checks that a match on the last line of a Go file doesn't
+// gain an empty line after it from the file's final newline.
+func TestGoScopeLastLine(t *testing.T) {
+	tests := []struct {
+		content   string
+		multiline bool
+		line      string
+		before    string
+	}{
+		{"package main\n\nvar x = 1\n", false, "var x = 1", ""},
+		{"package main\n\nvar x = 1\r\n", false, "var x = 1", ""},
+		{"package main\n\nvar x = 1", false, "var x = 1", ""},
+		{"package main\n\nvar x = 1\n", true, "var x = 1", ""},
+		{"package main\n\nfunc f() {\n}\nvar x = 1 // x\n", false, "var x = 1 // x", "}"},
+	}
+
+	for _, tt := range tests {
+		dir := writeTree(t, map[string]string{"c.go": tt.content})
+		opts := DefaultOptions()
+		opts.GoScope = GoScopeCode
+		opts.Multiline = tt.multiline
+		matches := collectMatches(t, dir, "x", opts)
+		last := matches[len(matches)-1]
+		if last.Line != tt.line || last.Before != tt.before || last.After != "" {
+			t.Errorf("%q: last match line %q, before %q, after %q; want %q, %q and no after",
+				tt.content, last.Line, last.Before, last.After, tt.line, tt.before)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// heading is the nearest line above a match that starts a function, class or
+// section (-p), like git grep --show-function. A zero line means none was seen.
+type heading struct {
+	line int
+	text string
+}
+
+// Heading patterns shared by several file extensions.
+var (
+	jsHeading       = regexp.MustCompile(`^\s*(export\s+)?(default\s+)?(async\s+)?(function|class)\b`)
+	tsHeading       = regexp.MustCompile(`^\s*(export\s+)?(default\s+)?(abstract\s+)?(async\s+)?(function|class|interface)\b`)
+	shellHeading    = regexp.MustCompile(`^\s*(function\s+\w+|\w+\s*\(\)\s*\{?)`)
+	markdownHeading = regexp.MustCompile(`^#{1,6}\s`)
+	sectionHeading  = regexp.MustCompile(`^\s*\[[^\]]+\]`)
+)
+
+// headingRules recognize heading lines per file extension. Files with other
+// extensions use defaultHeadingRule.
+var headingRules = map[string]*regexp.Regexp{
+	".go":       regexp.MustCompile(`^(func|type)\b`),
+	".py":       regexp.MustCompile(`^\s*(async\s+def|def|class)\s`),
+	".rb":       regexp.MustCompile(`^\s*(def|class|module)\s`),
+	".rs":       regexp.MustCompile(`^\s*(pub(\([\w:]+\))?\s+)?(async\s+)?(fn|struct|enum|trait|impl|mod)\b`),
+	".js":       jsHeading,
+	".jsx":      jsHeading,
+	".mjs":      jsHeading,
+	".ts":       tsHeading,
+	".tsx":      tsHeading,
+	".sh":       shellHeading,
+	".bash":     shellHeading,
+	".md":       markdownHeading,
+	".markdown": markdownHeading,
+	".ini":      sectionHeading,
+	".cfg":      sectionHeading,
+	".conf":     sectionHeading,
+	".toml":     sectionHeading,
+}
+
+// defaultHeadingRule is git's default for languages it knows nothing about: a
+// line starting with a letter, underscore or dollar sign, i.e. not indented,
+// which in C-like languages is usually a function or type definition.
+var defaultHeadingRule = regexp.MustCompile(`^[[:alpha:]_$]`)
+
+// headingRuleFor returns the heading rule for a file, chosen by its extension.
+func headingRuleFor(filePath string) (rule *regexp.Regexp) {
+	var ok bool
+
+	rule, ok = headingRules[strings.ToLower(filepath.Ext(filePath))]
+	if !ok {
+		rule = defaultHeadingRule
+	}
+	return rule
+}
+
+// headingAbove searches backwards for a heading from line, a one-based line
+// number, so the matched line itself is checked first. It is for searches that
+// have the whole content in memory.
+func headingAbove(rule *regexp.Regexp, content string, lineStarts []int, line int) (h heading) {
+	for i := line - 1; i >= 0; i-- {
+		text := lineText(content, lineStarts, i)
+		if rule.MatchString(text) {
+			h = heading{line: i + 1, text: strings.TrimSpace(text)}
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates files of the given content, named by slash-separated paths,
+// in a new temporary directory and returns it.
+func writeTree(t *testing.T, files map[string]string) (dir string) {
+	dir = t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// captureStdout runs f and returns what it printed.
+func captureStdout(t *testing.T, f func() error) (output string, err error) {
+	file, fileErr := os.CreateTemp(t.TempDir(), "stdout")
+	if fileErr != nil {
+		t.Fatal(fileErr)
+	}
+	defer file.Close()
+
+	stdout := os.Stdout
+	os.Stdout = file
+	err = f()
+	os.Stdout = stdout
+
+	if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil {
+		t.Fatal(seekErr)
+	}
+	data, _ := io.ReadAll(file)
+	return string(data), err
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// indexVersion changes whenever the on-disk format does; older indexes are rebuilt.
+const indexVersion = 1
+
+// skipIndexed is recorded for files the index shows can't contain a match.
+const skipIndexed = "ruled out by the trigram index (--index)"
+
+// trigramIndex is the on-disk index of one root directory, built by the index
+// subcommand. Every trigram (three consecutive bytes, with ASCII letters
+// lowercased) of each file's content maps to the sorted IDs of the files that
+// contain it, an ID being the file's position in Files.
+type trigramIndex struct {
+	Version  int
+	Root     string
+	Files    []indexEntry
+	Postings map[uint32][]uint32
+}
+
+// indexEntry describes one indexed file. Size and ModTime tell whether the file
+// changed since it was indexed, in which case the index can't be trusted for it.
+type indexEntry struct {
+	Path      string // Relative to the root
+	Size      int64
+	ModTime   int64 // Unix nanoseconds
+	Unindexed bool  // Content wasn't indexed (binary, not UTF-8, too large) so it's always searched
+}
+
+// indexPath returns where the index for a root directory is kept: in the user's
+// cache directory, named after a hash of the root's absolute path, so indexes
+// never clutter the tree they describe.
+func indexPath(rootDir string) (path string, err error) {
+	var cacheDir string
+	var sum [sha256.Size]byte
+
+	cacheDir, err = os.UserCacheDir()
+	if err != nil {
+		goto end
+	}
+	sum = sha256.Sum256([]byte(absPath(rootDir)))
+	path = filepath.Join(cacheDir, "search", hex.EncodeToString(sum[:8])+".idx")
+
+end:
+	return path, err
+}
+
+// loadIndex reads the index for a root directory. A missing index is reported
+// as an error wrapping fs.ErrNotExist.
+func loadIndex(rootDir string) (index *trigramIndex, err error) {
+	var path string
+	var file *os.File
+
+	path, err = indexPath(rootDir)
+	if err != nil {
+		goto end
+	}
+	file, err = os.Open(path)
+	if err != nil {
+		goto end
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	index = &trigramIndex{}
+	err = gob.NewDecoder(file).Decode(index)
+	if err != nil {
+		err = fmt.Errorf("reading index %s: %w", path, err)
+		goto end
+	}
+	if index.Version != indexVersion || index.Root != absPath(rootDir) {
+		err = fmt.Errorf("index %s is outdated: %w", path, fs.ErrNotExist)
+	}
+
+end:
+	return index, err
+}
+
+// saveIndex writes the index atomically so a search running at the same time
+// never reads a half-written one.
+func saveIndex(index *trigramIndex) (path string, err error) {
+	var tmp *os.File
+
+	path, err = indexPath(index.Root)
+	if err != nil {
+		goto end
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		goto end
+	}
+	tmp, err = os.CreateTemp(filepath.Dir(path), ".index-*")
+	if err != nil {
+		goto end
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	err = gob.NewEncoder(tmp).Encode(index)
+	if err != nil {
+		goto end
+	}
+	err = tmp.Close()
+	if err != nil {
+		goto end
+	}
+	err = os.Rename(tmp.Name(), path)
+
+end:
+	return path, err
+}
+
+// indexStats summarizes what buildIndex did.
+type indexStats struct {
+	files     int // Files in the index
+	reused    int // Unchanged files whose trigrams were taken from the old index
+	unindexed int // Files recorded without content trigrams
+}
+
+// buildIndex indexes every regular file below rootDir that a search would walk,
+// reusing the trigrams of files whose size and modification time haven't changed
+// since the previous index. Changed files are read concurrently, limited to
+// opts.MaxWorkers at a time.
+func buildIndex(ctx context.Context, rootDir string, opts Options) (index *trigramIndex, stats indexStats, err error) {
+	var old *trigramIndex
+	var oldIDs map[string]int
+	var oldTrigrams [][]uint32
+	var fileTrigrams [][]uint32
+	var read map[int]readResult
+	var g *errgroup.Group
+	var mu sync.Mutex
+	var limiter chan struct{}
+
+	index = &trigramIndex{Version: indexVersion, Root: absPath(rootDir), Postings: make(map[uint32][]uint32)}
+
+	old, err = loadIndex(rootDir)
+	if err == nil {
+		oldIDs = make(map[string]int, len(old.Files))
+		for id, entry := range old.Files {
+			oldIDs[entry.Path] = id
+		}
+		oldTrigrams = invertPostings(old)
+	} else if opts.Verbose {
+		fmt.Printf("[TRACE] Building index from scratch: %v\n", err)
+	}
+	err = nil
+
+	g, ctx = errgroup.WithContext(ctx)
+	limiter = make(chan struct{}, opts.MaxWorkers)
+	read = make(map[int]readResult)
+
+	err = filepath.WalkDir(rootDir, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			// Unreadable directories are left out, as a search would skip them
+			return nil
+		}
+		if entry.IsDir() {
+			if path != rootDir && shouldSkipDirectory(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return err
+		}
+
+		id := len(index.Files)
+		index.Files = append(index.Files, indexEntry{Path: rel, Size: info.Size(), ModTime: info.ModTime().UnixNano()})
+		fileTrigrams = append(fileTrigrams, nil)
+
+		if oldID, ok := oldIDs[rel]; ok && !old.Files[oldID].Unindexed && old.Files[oldID].Size == info.Size() && old.Files[oldID].ModTime == info.ModTime().UnixNano() {
+			fileTrigrams[id] = oldTrigrams[oldID]
+			stats.reused++
+			return nil
+		}
+
+		g.Go(func() error {
+			limiter <- struct{}{}
+			defer func() {
+				<-limiter
+			}()
+			trigrams, indexed := readTrigrams(ctx, path, info, opts)
+			mu.Lock()
+			read[id] = readResult{trigrams: trigrams, indexed: indexed}
+			mu.Unlock()
+			return ctx.Err()
+		})
+		return ctx.Err()
+	})
+	if waitErr := g.Wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		goto end
+	}
+
+	for id, result := range read {
+		fileTrigrams[id] = result.trigrams
+		index.Files[id].Unindexed = !result.indexed
+	}
+
+	// IDs are visited in ascending order, so every posting list comes out sorted
+	for id, trigrams := range fileTrigrams {
+		for _, trigram := range trigrams {
+			index.Postings[trigram] = append(index.Postings[trigram], uint32(id))
+		}
+		if index.Files[id].Unindexed {
+			stats.unindexed++
+		}
+	}
+	stats.files = len(index.Files)
+
+end:
+	return index, stats, err
+}
+
+// readResult is what reading one changed file while indexing produced.
+type readResult struct {
+	trigrams []uint32
+	indexed  bool
+}
+
+// invertPostings recovers each file's trigram list from an index's posting lists.
+func invertPostings(index *trigramIndex) (fileTrigrams [][]uint32) {
+	fileTrigrams = make([][]uint32, len(index.Files))
+	for trigram, ids := range index.Postings {
+		for _, id := range ids {
+			if int(id) < len(fileTrigrams) {
+				fileTrigrams[id] = append(fileTrigrams[id], trigram)
+			}
+		}
+	}
+	return fileTrigrams
+}
+
+// readTrigrams reads one file and returns its distinct trigrams. Files that would
+// be skipped as too large or binary, or that aren't stored as UTF-8, aren't
+// indexed (indexed is false): their searchable text differs from their bytes.
+func readTrigrams(ctx context.Context, path string, info os.FileInfo, opts Options) (trigrams []uint32, indexed bool) {
+	var data []byte
+	var err error
+
+	if opts.MaxFileSize > 0 && info.Size() > opts.MaxFileSize {
+		goto end
+	}
+	if ctx.Err() != nil {
+		goto end
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		goto end
+	}
+	if sample := data[:min(len(data), 512)]; !isLikelyText(sample) || bytes.IndexByte(data, 0) >= 0 {
+		goto end
+	} else if enc, bomLen := detectEncoding(sample, opts.Encoding); enc != EncodingUTF8 || bomLen > 0 {
+		goto end
+	}
+	trigrams, indexed = contentTrigrams(data), true
+
+end:
+	return trigrams, indexed
+}
+
+// contentTrigrams returns the distinct trigrams of data in ascending order.
+func contentTrigrams(data []byte) (trigrams []uint32) {
+	var seen map[uint32]struct{}
+
+	seen = make(map[uint32]struct{})
+	for i := 0; i+3 <= len(data); i++ {
+		seen[trigramOf(data[i], data[i+1], data[i+2])] = struct{}{}
+	}
+	trigrams = make([]uint32, 0, len(seen))
+	for trigram := range seen {
+		trigrams = append(trigrams, trigram)
+	}
+	slices.Sort(trigrams)
+	return trigrams
+}
+
+// trigramOf packs three bytes, ASCII letters lowercased, into a trigram key.
+// Lowercasing lets case-insensitive patterns use the index too.
+func trigramOf(a, b, c byte) uint32 {
+	return uint32(lowerASCII(a))<<16 | uint32(lowerASCII(b))<<8 | uint32(lowerASCII(c))
+}
+
+// lowerASCII lowercases an ASCII letter and leaves every other byte alone.
+func lowerASCII(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		b += 'a' - 'A'
+	}
+	return b
+}
+
+// rootIndex is a loaded index narrowed down to the files that may match the
+// current pattern.
+type rootIndex struct {
+	dir        string
+	ids        map[string]int // File ID by path relative to dir
+	files      []indexEntry
+	candidates map[uint32]struct{} // IDs the query allows; nil means every file
+}
+
+// openRootIndex loads the index of rootDir and evaluates query against it.
+func openRootIndex(rootDir string, query *trigramQuery) (ri *rootIndex, err error) {
+	var index *trigramIndex
+
+	index, err = loadIndex(rootDir)
+	if err != nil {
+		goto end
+	}
+
+	ri = &rootIndex{dir: index.Root, ids: make(map[string]int, len(index.Files)), files: index.Files}
+	for id, entry := range index.Files {
+		ri.ids[entry.Path] = id
+	}
+	if ids, all := query.eval(index.Postings); !all {
+		ri.candidates = make(map[uint32]struct{}, len(ids))
+		for _, id := range ids {
+			ri.candidates[id] = struct{}{}
+		}
+	}
+
+end:
+	return ri, err
+}
+
+// mayMatch reports whether a file found while walking has to be searched: it
+// isn't in the index, changed since indexing, wasn't indexed, or contains all
+// the trigrams the pattern needs.
+func (ri *rootIndex) mayMatch(path string, info os.FileInfo) (may bool) {
+	var rel string
+	var err error
+	var id int
+	var ok bool
+	var entry indexEntry
+
+	may = true
+	if ri.candidates == nil {
+		goto end
+	}
+	rel, err = filepath.Rel(ri.dir, absPath(path))
+	if err != nil {
+		goto end
+	}
+	id, ok = ri.ids[rel]
+	if !ok {
+		goto end
+	}
+	entry = ri.files[id]
+	if entry.Unindexed || entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() {
+		goto end
+	}
+	_, may = ri.candidates[uint32(id)]
+
+end:
+	return may
+}
+
+// loadRootIndexes attaches an index to every root that has one (--index). Roots
+// without a usable index are searched by walking them as usual.
+func (ds *DirSearch) loadRootIndexes() {
+	var query *trigramQuery
+
+	query = trigramQueryFor(ds.pattern.String())
+	if ds.verbose {
+		fmt.Printf("[TRACE] Index query for %s: %s\n", ds.pattern.String(), query)
+	}
+	for i := range ds.roots {
+		ri, err := openRootIndex(ds.roots[i].Dir, query)
+		if err != nil {
+			if ds.verbose {
+				fmt.Printf("[TRACE] Not using an index for %s: %v\n", ds.roots[i].Dir, err)
+			}
+			continue
+		}
+		ds.roots[i].index = ri
+	}
+}
+
+// runIndex implements the index subcommand: search index [options] <dir>...
+// It builds or incrementally updates the index of each directory. The options
+// that decide what gets searched (--max-filesize, --encoding) decide what gets
+// indexed too, so use the same ones as for searching.
+func runIndex(args []string) (err error) {
+	var opts Options
+	var dirs []string
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var index *trigramIndex
+	var stats indexStats
+	var path string
+
+	opts = DefaultOptions()
+	dirs, err = parseOptions(args, &opts)
+	if err != nil {
+		goto end
+	}
+	if len(dirs) == 0 {
+		err = fmt.Errorf("usage: %s index [-v] [--max-filesize=SIZE] [--encoding=ENC] <dir>...", os.Args[0])
+		goto end
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	err = setupSignalHandler(cancel)
+	if err != nil {
+		goto end
+	}
+
+	for _, dir := range dirs {
+		dir, err = expandTilde(dir)
+		if err != nil {
+			goto end
+		}
+		index, stats, err = buildIndex(ctx, dir, opts)
+		if err != nil {
+			goto end
+		}
+		path, err = saveIndex(index)
+		if err != nil {
+			goto end
+		}
+		fmt.Printf("Indexed %d files in %s (%d unchanged, %d not indexable) -> %s\n",
+			stats.files, dir, stats.reused, stats.unindexed, path)
+	}
+
+end:
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTrigramQuery(t *testing.T) {
+	tests := []struct {
+		pattern string
+		query   string
+	}{
+		// Literals need all their trigrams, ASCII letters lowercased
+		{"hello", "(hel ell llo)"},
+		{"Hello", "(hel ell llo)"},
+		{"ab", "+"},
+		{"", "+"},
+		{"foo.*bar", "(foo bar)"},
+		{"(abc)+", "abc"},
+
+		// Alternations and small classes need one of their strings
+		{"foo|bar", "(bar|foo)"},
+		{"(?:foo|x)bar", "((foo oob oba bar)|(xba bar))"},
+		{"ab(c|d)ef", "((abc bce cef)|(abd bde def))"},
+		{"[fb]oo", "(boo|foo)"},
+		{"[a-z]+foo", "foo"},
+
+		// Case-insensitive letters whose folds aren't ASCII may match anything
+		{"(?i)Hello", "(hel ell llo)"},
+		{"(?i)kelvin", "(elv lvi vin)"},
+		{"(?i)mismatch", "(mat atc tch)"},
+		{"(?i)ſtop", "top"},
+		{"(?i)héllo", "llo"},
+
+		// Patterns that can't be narrowed down
+		{"h.*o", "+"},
+		{"x*", "+"},
+		{"(abc){0,2}", "+"},
+		{"a.b", "+"},
+		{`\d+`, "+"},
+		{"abc|.", "+"},
+		{"(?<=a)b", "+"},
+	}
+
+	for _, tt := range tests {
+		if query := trigramQueryFor(tt.pattern).String(); query != tt.query {
+			t.Errorf("trigramQueryFor(%q) = %s, want %s", tt.pattern, query, tt.query)
+		}
+	}
+}
+
+// TestTrigramQueryKeepsMatches checks that the query never rules out text the
+// pattern matches, which would drop matches without any error.
+func TestTrigramQueryKeepsMatches(t *testing.T) {
+	patterns := []string{
+		"hello", "foo|bar", "(?:foo|x)bar", "[fb]oo", "(?i)hello", "(?i)kelvin", "(?i)KELVIN",
+		"(?i)mismatch", "(?i)stop", "(?i)ſtop", "(?i)héllo", "héllo", "(?i)[a-z]ey", "(?i)straße", "foo.*bar",
+	}
+	texts := []string{
+		"say hello", "HELLO there", "foobar", "xbar", "boo", "\u212Aelvin", "KELVIN", "kelvin",
+		"MISMATCH", "miſmatch", "ſtop", "STOP", "Héllo", "HÉLLO", "héllo", "Key", "STRASSE", "ſtraße", "foo and bar",
+	}
+
+	for _, pattern := range patterns {
+		re := regexp.MustCompile(pattern)
+		query := trigramQueryFor(pattern)
+		for _, text := range texts {
+			if !re.MatchString(text) {
+				continue
+			}
+			postings := make(map[uint32][]uint32)
+			for _, trigram := range contentTrigrams([]byte(text)) {
+				postings[trigram] = []uint32{0}
+			}
+			if ids, all := query.eval(postings); !all && !slices.Contains(ids, 0) {
+				t.Errorf("query %s of %q rules out %q, which it matches", query, pattern, text)
+			}
+		}
+	}
+}
+
+// TestIndexMayMatch checks which files an index lets a search skip, and that a
+// file changed since indexing is always searched.
+func TestIndexMayMatch(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	t.Setenv("LocalAppData", cache)
+	dir := writeTree(t, map[string]string{
+		"a.txt":   "say hello\n",
+		"b.txt":   "goodbye\n",
+		"c.bin":   "hello\x00binary\n",
+		"d/e.txt": "nothing\n",
+	})
+
+	index, stats, err := buildIndex(context.Background(), dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.files != 4 || stats.unindexed != 1 {
+		t.Errorf("indexed %d files, %d unindexed; want 4 and 1", stats.files, stats.unindexed)
+	}
+	if _, err = saveIndex(index); err != nil {
+		t.Fatal(err)
+	}
+
+	mayMatch := func(pattern, name string) bool {
+		t.Helper()
+		ri, err := openRootIndex(dir, trigramQueryFor(pattern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ri.mayMatch(path, info)
+	}
+
+	for name, want := range map[string]bool{"a.txt": true, "b.txt": false, "c.bin": true, "d/e.txt": false} {
+		if may := mayMatch("hello", name); may != want {
+			t.Errorf("hello may match %s: %v, want %v", name, may, want)
+		}
+	}
+	if !mayMatch("h.*o", "b.txt") {
+		t.Errorf("a pattern without trigrams ruled out b.txt")
+	}
+
+	// A file that isn't in the index
+	if err = os.WriteFile(filepath.Join(dir, "new.txt"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !mayMatch("hello", "new.txt") {
+		t.Errorf("new.txt, which isn't indexed, was ruled out")
+	}
+
+	// Changed size
+	if err = os.WriteFile(filepath.Join(dir, "b.txt"), []byte("hello, goodbye\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !mayMatch("hello", "b.txt") {
+		t.Errorf("b.txt, which grew since indexing, was ruled out")
+	}
+
+	// Same size, changed modification time
+	path := filepath.Join(dir, "d", "e.txt")
+	if err = os.WriteFile(path, []byte("hello!!\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(path, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if !mayMatch("hello", "d/e.txt") {
+		t.Errorf("d/e.txt, which was modified since indexing, was ruled out")
+	}
+}
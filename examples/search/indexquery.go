@@ -0,0 +1,328 @@
+package main
+
+import (
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxExactStrings bounds the set of exact strings tracked while analyzing a
+// pattern; beyond it the analysis falls back to a looser trigram query.
+const maxExactStrings = 64
+
+// queryOp is the kind of a trigramQuery node.
+type queryOp int
+
+const (
+	queryAll     queryOp = iota // Every file may match
+	queryNone                   // No file can match
+	queryTrigram                // Files containing one trigram
+	queryAnd                    // Files matching every sub-query
+	queryOr                     // Files matching any sub-query
+)
+
+// trigramQuery is a boolean combination of trigrams that every file matching a
+// pattern must satisfy, as in Russ Cox's "Regular Expression Matching with a
+// Trigram Index". It is a necessary condition only: files it selects still
+// have to be searched.
+type trigramQuery struct {
+	op      queryOp
+	trigram uint32
+	subs    []*trigramQuery
+}
+
+var (
+	allQuery  = &trigramQuery{op: queryAll}
+	noneQuery = &trigramQuery{op: queryNone}
+)
+
+// trigramQueryFor derives the trigram query for a pattern. Patterns the RE2
+// parser can't handle, such as -P lookarounds, get a query matching everything.
+func trigramQueryFor(expr string) (query *trigramQuery) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return allQuery
+	}
+	return analyzeRegexp(re.Simplify()).query()
+}
+
+// regexpInfo summarizes what a sub-pattern can match: either the exact set of
+// strings (lowercased like the index), or, when that set is unknown or too
+// large, a query every match satisfies.
+type regexpInfo struct {
+	exact   []string
+	isExact bool
+	match   *trigramQuery
+}
+
+// exactInfo describes a sub-pattern matching exactly the given strings.
+func exactInfo(exact ...string) regexpInfo {
+	slices.Sort(exact)
+	return regexpInfo{exact: slices.Compact(exact), isExact: true}
+}
+
+// queryInfo describes a sub-pattern only through a query.
+func queryInfo(query *trigramQuery) regexpInfo {
+	return regexpInfo{match: query}
+}
+
+// analyzeRegexp computes the regexpInfo of a parsed pattern.
+func analyzeRegexp(re *syntax.Regexp) (info regexpInfo) {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		info = exactInfo()
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText,
+		syntax.OpEndText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		info = exactInfo("")
+	case syntax.OpLiteral:
+		info = literalInfo(re)
+	case syntax.OpCharClass:
+		info = charClassInfo(re)
+	case syntax.OpCapture:
+		info = analyzeRegexp(re.Sub[0])
+	case syntax.OpPlus:
+		info = queryInfo(analyzeRegexp(re.Sub[0]).query())
+	case syntax.OpRepeat:
+		if re.Min == 0 {
+			info = queryInfo(allQuery)
+			break
+		}
+		info = queryInfo(analyzeRegexp(re.Sub[0]).query())
+	case syntax.OpConcat:
+		info = exactInfo("")
+		for _, sub := range re.Sub {
+			info = concatInfo(info, analyzeRegexp(sub))
+		}
+	case syntax.OpAlternate:
+		info = exactInfo()
+		for _, sub := range re.Sub {
+			info = alternateInfo(info, analyzeRegexp(sub))
+		}
+	default:
+		// ., *, ? and anything else can match too much to say anything
+		info = queryInfo(allQuery)
+	}
+	return info
+}
+
+// literalInfo handles a literal string. Case folding is free since the index is
+// lowercased, except for letters with non-ASCII folds, such as k (K, the Kelvin
+// sign) and s (ſ), which the index doesn't cover: they may match anything.
+func literalInfo(re *syntax.Regexp) (info regexpInfo) {
+	var start int
+
+	if re.Flags&syntax.FoldCase == 0 {
+		return exactInfo(lowerASCIIString(string(re.Rune)))
+	}
+	info = exactInfo("")
+	for i, r := range re.Rune {
+		if !hasNonASCIIFold(r) {
+			continue
+		}
+		info = concatInfo(info, exactInfo(lowerASCIIString(string(re.Rune[start:i]))))
+		info = concatInfo(info, queryInfo(allQuery))
+		start = i + 1
+	}
+	return concatInfo(info, exactInfo(lowerASCIIString(string(re.Rune[start:]))))
+}
+
+// charClassInfo expands a small character class into its single-character
+// strings; larger classes tell nothing.
+func charClassInfo(re *syntax.Regexp) (info regexpInfo) {
+	var exact []string
+
+	for i := 0; i+1 < len(re.Rune); i += 2 {
+		lo, hi := re.Rune[i], re.Rune[i+1]
+		if hi-lo >= maxExactStrings || len(exact) > maxExactStrings {
+			return queryInfo(allQuery)
+		}
+		for r := lo; r <= hi; r++ {
+			exact = append(exact, lowerASCIIString(string(r)))
+		}
+	}
+	if len(exact) > maxExactStrings {
+		return queryInfo(allQuery)
+	}
+	return exactInfo(exact...)
+}
+
+// concatInfo combines two sub-patterns matched one after the other. Exact sets
+// are multiplied out while small, which keeps trigrams spanning the boundary.
+func concatInfo(a, b regexpInfo) regexpInfo {
+	if a.isExact && b.isExact && len(a.exact)*len(b.exact) <= maxExactStrings {
+		exact := make([]string, 0, len(a.exact)*len(b.exact))
+		for _, x := range a.exact {
+			for _, y := range b.exact {
+				exact = append(exact, x+y)
+			}
+		}
+		return exactInfo(exact...)
+	}
+	return queryInfo(andQuery(a.query(), b.query()))
+}
+
+// alternateInfo combines two alternatives.
+func alternateInfo(a, b regexpInfo) regexpInfo {
+	if a.isExact && b.isExact && len(a.exact)+len(b.exact) <= maxExactStrings {
+		return exactInfo(append(slices.Clone(a.exact), b.exact...)...)
+	}
+	return queryInfo(orQuery(a.query(), b.query()))
+}
+
+// query turns the info into a trigram query: a file must contain every trigram
+// of at least one of the exact strings. A string shorter than three bytes has
+// no trigrams, so it can't rule anything out.
+func (info regexpInfo) query() (query *trigramQuery) {
+	if !info.isExact {
+		return info.match
+	}
+	query = noneQuery
+	for _, s := range info.exact {
+		if len(s) < 3 {
+			return allQuery
+		}
+		and := allQuery
+		for i := 0; i+3 <= len(s); i++ {
+			and = andQuery(and, &trigramQuery{op: queryTrigram, trigram: trigramOf(s[i], s[i+1], s[i+2])})
+		}
+		query = orQuery(query, and)
+	}
+	return query
+}
+
+// andQuery combines queries that must both hold, simplifying trivial cases.
+func andQuery(a, b *trigramQuery) *trigramQuery {
+	switch {
+	case a.op == queryAll || b.op == queryNone:
+		return b
+	case b.op == queryAll || a.op == queryNone:
+		return a
+	}
+	return &trigramQuery{op: queryAnd, subs: slices.Concat(flatten(a, queryAnd), flatten(b, queryAnd))}
+}
+
+// orQuery combines queries of which either may hold, simplifying trivial cases.
+func orQuery(a, b *trigramQuery) *trigramQuery {
+	switch {
+	case a.op == queryNone || b.op == queryAll:
+		return b
+	case b.op == queryNone || a.op == queryAll:
+		return a
+	}
+	return &trigramQuery{op: queryOr, subs: slices.Concat(flatten(a, queryOr), flatten(b, queryOr))}
+}
+
+// flatten returns the operands of q if it is already an op node, so nested
+// ANDs and ORs collapse into one level.
+func flatten(q *trigramQuery, op queryOp) []*trigramQuery {
+	if q.op == op {
+		return q.subs
+	}
+	return []*trigramQuery{q}
+}
+
+// eval returns the sorted IDs of the files satisfying the query, or all set
+// when it doesn't narrow anything down.
+func (q *trigramQuery) eval(postings map[uint32][]uint32) (ids []uint32, all bool) {
+	switch q.op {
+	case queryAll:
+		all = true
+	case queryTrigram:
+		ids = postings[q.trigram]
+	case queryAnd:
+		all = true
+		for _, sub := range q.subs {
+			subIDs, subAll := sub.eval(postings)
+			switch {
+			case subAll:
+			case all:
+				ids, all = subIDs, false
+			default:
+				ids = intersectIDs(ids, subIDs)
+			}
+		}
+	case queryOr:
+		for _, sub := range q.subs {
+			subIDs, subAll := sub.eval(postings)
+			if subAll {
+				return nil, true
+			}
+			ids = unionIDs(ids, subIDs)
+		}
+	}
+	return ids, all
+}
+
+// intersectIDs returns the IDs present in both sorted lists.
+func intersectIDs(a, b []uint32) (result []uint32) {
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// unionIDs returns the IDs present in either sorted list.
+func unionIDs(a, b []uint32) (result []uint32) {
+	result = make([]uint32, 0, len(a)+len(b))
+	result = append(result, a...)
+	result = append(result, b...)
+	slices.Sort(result)
+	return slices.Compact(result)
+}
+
+// String renders the query for tracing, e.g. "((foo oob)|bar)".
+func (q *trigramQuery) String() string {
+	var parts []string
+	var sep string
+
+	switch q.op {
+	case queryAll:
+		return "+"
+	case queryNone:
+		return "-"
+	case queryTrigram:
+		return strings.ToValidUTF8(string([]byte{byte(q.trigram >> 16), byte(q.trigram >> 8), byte(q.trigram)}), "?")
+	case queryAnd:
+		sep = " "
+	case queryOr:
+		sep = "|"
+	}
+	for _, sub := range q.subs {
+		parts = append(parts, sub.String())
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+// hasNonASCIIFold reports whether r, matched case-insensitively, may match a
+// non-ASCII character.
+func hasNonASCIIFold(r rune) bool {
+	if r >= utf8.RuneSelf {
+		return true
+	}
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerASCIIString lowercases the ASCII letters of s, as the index does.
+func lowerASCIIString(s string) string {
+	b := []byte(s)
+	for i := range b {
+		b[i] = lowerASCII(b[i])
+	}
+	return string(b)
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// JSON-RPC and LSP error codes.
+const (
+	rpcParseError       = -32700
+	rpcInvalidRequest   = -32600
+	rpcMethodNotFound   = -32601
+	rpcInvalidParams    = -32602
+	rpcInternalError    = -32603
+	rpcRequestCancelled = -32800
+)
+
+// rpcMessage is any incoming JSON-RPC 2.0 message: a request when it has an ID,
+// otherwise a notification.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse answers a request with either a result or an error.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcNotification is a message sent to the client that expects no answer.
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// rpcError is the error member of a failed response.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// lspInitializeParams holds the parts of the initialize request the server uses.
+type lspInitializeParams struct {
+	RootURI      string `json:"rootUri"`
+	Capabilities struct {
+		General struct {
+			PositionEncodings []string `json:"positionEncodings"`
+		} `json:"general"`
+	} `json:"capabilities"`
+}
+
+// lspSearchParams are the parameters of the "search" request.
+type lspSearchParams struct {
+	Pattern            string          `json:"pattern"`
+	Paths              []string        `json:"paths"`      // File URIs or paths; the workspace root if empty
+	Globs              []string        `json:"globs"`      // File name patterns such as "*.go"; all files if empty
+	PCRE               bool            `json:"pcre"`       // Use the backtracking engine, like -P; needs --allow-pcre
+	Multiline          bool            `json:"multiline"`  // Let matches span lines, like -U
+	MaxResults         int             `json:"maxResults"` // Stop after this many locations; 0 means no limit
+	PartialResultToken json.RawMessage `json:"partialResultToken,omitempty"`
+}
+
+// lspPosition is a zero-based line and character offset. Characters count
+// UTF-16 code units unless UTF-8 was negotiated, as LSP specifies.
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// lspRange is the span of a match, from start up to but not including end.
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+// lspLocation is where one match is.
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+// lspServer answers search requests from an editor over stdin and stdout. Each
+// search runs in its own goroutine so it can be cancelled while others proceed.
+type lspServer struct {
+	opts          Options // Options every search starts from
+	root          string  // Workspace root searched when a request names no paths
+	utf8Positions bool    // Characters are counted in bytes instead of UTF-16 code units
+	shutdown      bool    // A shutdown request was received
+	allowPCRE     bool    // Requests may use the backtracking engine (see newRequestSearch)
+
+	writeMu sync.Mutex // Serializes messages written to out
+	out     io.Writer
+
+	pendingMu sync.Mutex
+	pending   map[string]context.CancelFunc // Running searches by request ID
+	running   sync.WaitGroup
+}
+
+// runLSP implements `search lsp`: a JSON-RPC 2.0 server on stdin and stdout
+// using the Language Server Protocol's framing and cancellation.
+//
+// Besides initialize, shutdown and exit it answers "search" requests with the
+// locations of all matches. Searches are cancelled with $/cancelRequest, and
+// when a request carries a partialResultToken its locations are streamed as
+// $/progress notifications as they are found. Like serve, it only lets requests
+// use the backtracking engine when started with --allow-pcre.
+func runLSP(args []string) (err error) {
+	var opts Options
+	var rest []string
+	var positional []string
+	var s *lspServer
+
+	rest = slices.DeleteFunc(slices.Clone(args), func(arg string) bool { return arg == "--allow-pcre" })
+	opts = DefaultOptions()
+	positional, err = parseOptions(rest, &opts)
+	if err != nil {
+		goto end
+	}
+	switch {
+	case len(positional) > 0:
+		err = fmt.Errorf("usage: %s lsp [--allow-pcre] [-L] [--index] [--max-filesize=SIZE] [--binary=skip|text|without-match] [--encoding=ENC]", os.Args[0])
+	case opts.Verbose:
+		// Tracing goes to stdout, where it would corrupt the protocol
+		err = fmt.Errorf("lsp cannot be combined with -v")
+	case opts.Replacing || opts.OnlyMatching || opts.Extracting || opts.SearchZip || opts.Watch || opts.Tail:
+		err = fmt.Errorf("lsp only reports match locations and cannot be combined with --replace, -o, --extract, -z, --watch or --tail")
+	}
+	if err != nil {
+		goto end
+	}
+
+	opts.AbsolutePaths = true
+	s = &lspServer{opts: opts, out: os.Stdout, pending: make(map[string]context.CancelFunc)}
+	s.allowPCRE = len(rest) < len(args)
+	s.root, err = os.Getwd()
+	if err != nil {
+		goto end
+	}
+	err = s.serve(os.Stdin)
+
+end:
+	return err
+}
+
+// serve reads and handles messages from in until the client exits or closes
+// it, then stops the searches still running.
+func (s *lspServer) serve(in io.Reader) (err error) {
+	var reader *bufio.Reader
+	var body []byte
+	var msg rpcMessage
+
+	reader = bufio.NewReader(in)
+	for {
+		body, err = readRPCMessage(reader)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			goto end
+		}
+
+		msg = rpcMessage{}
+		if jsonErr := json.Unmarshal(body, &msg); jsonErr != nil {
+			s.replyError(json.RawMessage("null"), rpcParseError, jsonErr.Error())
+			continue
+		}
+		if msg.JSONRPC != "2.0" || msg.Method == "" {
+			s.replyError(msg.ID, rpcInvalidRequest, "not a JSON-RPC 2.0 request")
+			continue
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				err = fmt.Errorf("exit requested without shutdown")
+			}
+			goto end
+		}
+		s.dispatch(msg)
+	}
+
+end:
+	s.cancelAll()
+	s.running.Wait()
+	return err
+}
+
+// dispatch handles one message other than exit.
+func (s *lspServer) dispatch(msg rpcMessage) {
+	var isRequest bool
+
+	isRequest = len(msg.ID) > 0 && string(msg.ID) != "null"
+	switch msg.Method {
+	case "initialize":
+		s.initialize(msg)
+	case "initialized":
+	case "search":
+		if !isRequest {
+			break
+		}
+		if s.shutdown {
+			s.replyError(msg.ID, rpcInvalidRequest, "server is shutting down")
+			break
+		}
+		s.start(msg)
+	case "$/cancelRequest":
+		var params struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if json.Unmarshal(msg.Params, &params) == nil {
+			s.cancel(params.ID)
+		}
+	case "shutdown":
+		s.shutdown = true
+		s.cancelAll()
+		s.running.Wait()
+		s.reply(msg.ID, nil)
+	default:
+		// Unknown notifications, including other $/ ones, are ignored
+		if isRequest {
+			s.replyError(msg.ID, rpcMethodNotFound, "unknown method "+msg.Method)
+		}
+	}
+}
+
+// initialize records the workspace root and agrees on how positions are counted.
+func (s *lspServer) initialize(msg rpcMessage) {
+	var params lspInitializeParams
+	var encoding string
+	var result map[string]any
+
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		s.replyError(msg.ID, rpcInvalidParams, err.Error())
+		return
+	}
+	if params.RootURI != "" {
+		if root, err := uriToPath(params.RootURI); err == nil {
+			s.root = root
+		}
+	}
+
+	encoding = "utf-16"
+	if slices.Contains(params.Capabilities.General.PositionEncodings, "utf-8") {
+		encoding, s.utf8Positions = "utf-8", true
+	}
+	result = map[string]any{
+		"capabilities": map[string]any{"positionEncoding": encoding},
+		"serverInfo":   map[string]any{"name": "search"},
+	}
+	s.reply(msg.ID, result)
+}
+
+// start runs a search request in the background.
+func (s *lspServer) start(msg rpcMessage) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	ctx, cancel = context.WithCancel(context.Background())
+	s.pendingMu.Lock()
+	s.pending[string(msg.ID)] = cancel
+	s.pendingMu.Unlock()
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer func() {
+			s.pendingMu.Lock()
+			delete(s.pending, string(msg.ID))
+			s.pendingMu.Unlock()
+			cancel()
+		}()
+		s.search(ctx, msg)
+	}()
+}
+
+// search runs one search and answers with its locations.
+func (s *lspServer) search(ctx context.Context, msg rpcMessage) {
+	var params lspSearchParams
+	var ds *DirSearch
+	var locations []lspLocation
+	var found int
+	var err error
+
+	err = json.Unmarshal(msg.Params, &params)
+	if err == nil {
+		ds, err = s.newSearch(&params)
+	}
+	if err != nil {
+		s.replyError(msg.ID, rpcInvalidParams, err.Error())
+		return
+	}
+
+	locations = []lspLocation{}
+	ds.SetOutput(func(match Match) (err error) {
+		matchLocations := s.locations(match)
+		if params.MaxResults > 0 && found+len(matchLocations) >= params.MaxResults {
+			matchLocations = matchLocations[:params.MaxResults-found]
+			err = errLimitReached
+		}
+		found += len(matchLocations)
+		if len(params.PartialResultToken) > 0 {
+			s.notify("$/progress", map[string]any{"token": params.PartialResultToken, "value": matchLocations})
+		} else {
+			locations = append(locations, matchLocations...)
+		}
+		return err
+	})
+
+	err = ds.Run(ctx)
+	switch {
+	case err == nil, errors.Is(err, errLimitReached):
+		// With partial results everything was already sent and the result is empty
+		s.reply(msg.ID, locations)
+	case ctx.Err() != nil:
+		s.replyError(msg.ID, rpcRequestCancelled, "search cancelled")
+	default:
+		s.replyError(msg.ID, rpcInternalError, err.Error())
+	}
+}
+
+// newSearch sets up the DirSearch of a request.
+func (s *lspServer) newSearch(params *lspSearchParams) (ds *DirSearch, err error) {
+	var opts Options
+	var paths []string
+
+	opts = s.opts
+	opts.PCRE = params.PCRE
+	opts.Multiline = opts.Multiline || params.Multiline
+	if params.MaxResults < 0 {
+		err = fmt.Errorf("invalid maxResults %d (want a non-negative number)", params.MaxResults)
+		goto end
+	}
+
+	paths = params.Paths
+	if len(paths) == 0 {
+		paths = []string{s.root}
+	}
+	ds, err = newRequestSearch(opts, s.allowPCRE, params.Pattern, params.Globs, paths, func(path string) (string, error) {
+		path, err := uriToPath(path)
+		if err == nil && !filepath.IsAbs(path) {
+			path = filepath.Join(s.root, path)
+		}
+		return path, err
+	})
+
+end:
+	return ds, err
+}
+
+// locations converts the spans of a match into locations. Binary matches have
+// no lines to point at and give none.
+func (s *lspServer) locations(match Match) (locations []lspLocation) {
+	var uri string
+
+	if match.Binary {
+		return nil
+	}
+	uri = pathToURI(match.FilePath)
+	for _, span := range match.Spans {
+		locations = append(locations, lspLocation{
+			URI: uri,
+			Range: lspRange{
+				Start: s.position(match.Line, match.LineNumber, span[0]),
+				End:   s.position(match.Line, match.LineNumber, span[1]),
+			},
+		})
+	}
+	return locations
+}
+
+// position converts a byte offset within the text of a match starting on line
+// lineNumber (1-based) into an LSP position. The text of a multiline match
+// holds several lines, so the line is found by counting newlines before offset.
+func (s *lspServer) position(text string, lineNumber, offset int) (pos lspPosition) {
+	var lineStart int
+
+	lineStart = strings.LastIndexByte(text[:offset], '\n') + 1
+	pos.Line = lineNumber - 1 + strings.Count(text[:offset], "\n")
+	if s.utf8Positions {
+		pos.Character = offset - lineStart
+		return pos
+	}
+	for _, r := range text[lineStart:offset] {
+		pos.Character++
+		if r >= 0x10000 && r != utf8.RuneError {
+			// Outside the Basic Multilingual Plane a rune takes a surrogate pair
+			pos.Character++
+		}
+	}
+	return pos
+}
+
+// reply sends the successful result of a request.
+func (s *lspServer) reply(id json.RawMessage, result any) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		s.replyError(id, rpcInternalError, err.Error())
+		return
+	}
+	s.write(rpcResponse{JSONRPC: "2.0", ID: id, Result: data})
+}
+
+// replyError sends the error of a failed request.
+func (s *lspServer) replyError(id json.RawMessage, code int, message string) {
+	if len(id) == 0 {
+		// Notifications are never answered, not even with errors
+		return
+	}
+	s.write(rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}})
+}
+
+// notify sends a notification to the client.
+func (s *lspServer) notify(method string, params any) {
+	s.write(rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// write sends one message with its Content-Length header. A client that went
+// away is noticed when stdin reaches its end, so write errors are ignored.
+func (s *lspServer) write(message any) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, _ = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// cancel stops the search of one request, if it is still running.
+func (s *lspServer) cancel(id json.RawMessage) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if cancel, ok := s.pending[string(id)]; ok {
+		cancel()
+	}
+}
+
+// cancelAll stops every running search.
+func (s *lspServer) cancelAll() {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	for _, cancel := range s.pending {
+		cancel()
+	}
+}
+
+// readRPCMessage reads one message framed by LSP's base protocol: headers, a
+// blank line, then a body of Content-Length bytes.
+func readRPCMessage(reader *bufio.Reader) (body []byte, err error) {
+	var line string
+	var length int
+	var started bool
+
+	length = -1
+	for {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			// Only the end of input between messages is a clean end
+			if errors.Is(err, io.EOF) && (started || line != "") {
+				err = io.ErrUnexpectedEOF
+			}
+			goto end
+		}
+		started = true
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || length < 0 {
+				err = fmt.Errorf("invalid Content-Length header %q", line)
+				goto end
+			}
+		}
+	}
+	if length < 0 {
+		err = fmt.Errorf("message without Content-Length header")
+		goto end
+	}
+
+	body = make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+
+end:
+	return body, err
+}
+
+// pathToURI turns an absolute path into a file URI.
+func pathToURI(path string) string {
+	return fileURL("", path).String()
+}
+
+// fileURL returns the file URL of an absolute path on host ("" for the local
+// machine).
+func fileURL(host, path string) *url.URL {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		// Windows drive letters: C:/x becomes file:///C:/x
+		path = "/" + path
+	}
+	return &url.URL{Scheme: "file", Host: host, Path: path}
+}
+
+// uriToPath turns a file URI into a path. Anything that isn't a URI is taken
+// to be a path already.
+func uriToPath(uri string) (path string, err error) {
+	var parsed *url.URL
+
+	if !strings.HasPrefix(uri, "file:") {
+		path = uri
+		goto end
+	}
+	parsed, err = url.Parse(uri)
+	if err != nil {
+		goto end
+	}
+	path = parsed.Path
+	if runtime.GOOS == "windows" {
+		path = strings.TrimPrefix(path, "/")
+	}
+	path = filepath.FromSlash(path)
+
+end:
+	return path, err
+}
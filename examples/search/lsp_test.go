@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lspReceived is any message the server sends: a response or a notification.
+type lspReceived struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// lspSession drives a server over pipes, as an editor would over stdio.
+type lspSession struct {
+	t    *testing.T
+	in   *io.PipeWriter
+	out  *bufio.Reader
+	done chan error
+}
+
+// startLSP serves a session for a workspace at root.
+func startLSP(t *testing.T, root string, allowPCRE bool) (c *lspSession) {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	opts := DefaultOptions()
+	opts.AbsolutePaths = true
+	s := &lspServer{opts: opts, root: root, out: outWriter, allowPCRE: allowPCRE, pending: make(map[string]context.CancelFunc)}
+
+	c = &lspSession{t: t, in: inWriter, out: bufio.NewReader(outReader), done: make(chan error, 1)}
+	go func() {
+		c.done <- s.serve(inReader)
+		outWriter.Close()
+	}()
+	t.Cleanup(func() { inWriter.Close() })
+	return c
+}
+
+// send writes one framed message.
+func (c *lspSession) send(message string) {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(message), message); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// receive reads one framed message.
+func (c *lspSession) receive() (msg lspReceived) {
+	c.t.Helper()
+	body, err := readRPCMessage(c.out)
+	if err != nil {
+		c.t.Fatalf("reading a message: %v", err)
+	}
+	if err = json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("message %s: %v", body, err)
+	}
+	return msg
+}
+
+func TestLSPSession(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "x😀 needle é needle\n", "b.txt": "hay\n"})
+	c := startLSP(t, root, false)
+
+	c.send(`{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"rootUri": "` + pathToURI(root) + `"}}`)
+	if msg := c.receive(); string(msg.ID) != "1" || !strings.Contains(string(msg.Result), `"positionEncoding":"utf-16"`) {
+		t.Fatalf("initialize answered %+v, want utf-16 positions", msg)
+	}
+	c.send(`{"jsonrpc": "2.0", "method": "initialized", "params": {}}`)
+
+	c.send(`{"jsonrpc": "2.0", "id": "s1", "method": "search", "params": {"pattern": "needle"}}`)
+	msg := c.receive()
+	var locations []lspLocation
+	if err := json.Unmarshal(msg.Result, &locations); err != nil || string(msg.ID) != `"s1"` {
+		t.Fatalf("search answered %+v, %v", msg, err)
+	}
+	want := []lspLocation{
+		{URI: pathToURI(filepath.Join(root, "a.txt")), Range: lspRange{Start: lspPosition{0, 4}, End: lspPosition{0, 10}}},
+		{URI: pathToURI(filepath.Join(root, "a.txt")), Range: lspRange{Start: lspPosition{0, 13}, End: lspPosition{0, 19}}},
+	}
+	if fmt.Sprint(locations) != fmt.Sprint(want) {
+		t.Errorf("locations = %v, want %v", locations, want)
+	}
+
+	// Errors keep the session going
+	c.send(`{"jsonrpc": "2.0", "id": 2, "method": "search", "params": {"pattern": "(?<=x)y", "pcre": true}}`)
+	if msg := c.receive(); msg.Error == nil || msg.Error.Code != rpcInvalidParams || !strings.Contains(msg.Error.Message, "--allow-pcre") {
+		t.Errorf("pcre search answered %+v, want invalid params", msg)
+	}
+	c.send(`{"jsonrpc": "2.0", "id": 3, "method": "nope"}`)
+	if msg := c.receive(); msg.Error == nil || msg.Error.Code != rpcMethodNotFound {
+		t.Errorf("unknown method answered %+v", msg)
+	}
+	c.send(`{"jsonrpc": "2.0", "id": 4, "method": `)
+	if msg := c.receive(); msg.Error == nil || msg.Error.Code != rpcParseError || string(msg.ID) != "null" {
+		t.Errorf("bad JSON answered %+v", msg)
+	}
+	c.send(`{"id": 5, "method": "search"}`)
+	if msg := c.receive(); msg.Error == nil || msg.Error.Code != rpcInvalidRequest {
+		t.Errorf("request without jsonrpc answered %+v", msg)
+	}
+
+	c.send(`{"jsonrpc": "2.0", "id": 6, "method": "shutdown"}`)
+	if msg := c.receive(); string(msg.ID) != "6" || msg.Error != nil {
+		t.Errorf("shutdown answered %+v", msg)
+	}
+	c.send(`{"jsonrpc": "2.0", "method": "exit"}`)
+	if err := <-c.done; err != nil {
+		t.Errorf("serve returned %v after shutdown and exit", err)
+	}
+}
+
+func TestLSPExitWithoutShutdown(t *testing.T) {
+	c := startLSP(t, t.TempDir(), false)
+
+	c.send(`{"jsonrpc": "2.0", "method": "exit"}`)
+	if err := <-c.done; err == nil {
+		t.Errorf("serve returned nil, want an error for exit without shutdown")
+	}
+}
+
+func TestLSPPCRE(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "foobar foobaz\n"})
+	c := startLSP(t, root, true)
+
+	c.send(`{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"capabilities": {"general": {"positionEncodings": ["utf-8"]}}}}`)
+	c.receive()
+	c.send(`{"jsonrpc": "2.0", "id": 2, "method": "search", "params": {"pattern": "foo(?!bar)", "pcre": true}}`)
+	var locations []lspLocation
+	if err := json.Unmarshal(c.receive().Result, &locations); err != nil || len(locations) != 1 || locations[0].Range.Start.Character != 7 {
+		t.Errorf("locations = %v, %v; want one at character 7", locations, err)
+	}
+}
+
+func TestLSPCancel(t *testing.T) {
+	root := writeTree(t, map[string]string{"big.txt": strings.Repeat("needle\n", 100_000)})
+	c := startLSP(t, root, false)
+
+	// The first progress notification stays unread, so the search is blocked
+	// writing it when the cancellation arrives
+	c.send(`{"jsonrpc": "2.0", "id": 7, "method": "search", "params": {"pattern": "needle", "partialResultToken": "p"}}`)
+	c.send(`{"jsonrpc": "2.0", "method": "$/cancelRequest", "params": {"id": 7}}`)
+	progress := 0
+	for {
+		msg := c.receive()
+		if msg.Method == "$/progress" {
+			progress++
+			continue
+		}
+		if string(msg.ID) != "7" || msg.Error == nil || msg.Error.Code != rpcRequestCancelled {
+			t.Errorf("search answered %+v, want a cancelled request", msg)
+		}
+		break
+	}
+	if progress >= 100_000 {
+		t.Errorf("got all %d results, want the search stopped early", progress)
+	}
+
+	// Cancelling a finished or unknown request is ignored
+	c.send(`{"jsonrpc": "2.0", "method": "$/cancelRequest", "params": {"id": 7}}`)
+	c.send(`{"jsonrpc": "2.0", "id": 8, "method": "search", "params": {"pattern": "needle", "maxResults": 2}}`)
+	var locations []lspLocation
+	if err := json.Unmarshal(c.receive().Result, &locations); err != nil || len(locations) != 2 {
+		t.Errorf("locations = %v, %v; want 2", locations, err)
+	}
+}
+
+func TestReadRPCMessage(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+		err   string
+	}{
+		{"Content-Length: 2\r\n\r\n{}", []string{"{}"}, ""},
+		{"Content-Length: 2\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n{}content-length:3\n\n[1]", []string{"{}", "[1]"}, ""},
+		{"Content-Length: 3\r\n\r\né!", []string{"é!"}, ""},
+		{"Content-Type: x\r\n\r\n{}", nil, "without Content-Length"},
+		{"Content-Length: -1\r\n\r\n", nil, "invalid Content-Length"},
+		{"Content-Length: x\r\n\r\n", nil, "invalid Content-Length"},
+		{"Content-Length: 5\r\n\r\n{}", nil, io.ErrUnexpectedEOF.Error()},
+		{"Content-Length: 2\r\n", nil, io.ErrUnexpectedEOF.Error()},
+	}
+
+	for _, tt := range tests {
+		reader := bufio.NewReader(strings.NewReader(tt.input))
+		var got []string
+		var err error
+		for {
+			var body []byte
+			body, err = readRPCMessage(reader)
+			if err != nil {
+				break
+			}
+			got = append(got, string(body))
+		}
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) || (err == nil) != (tt.err == "") || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%q: got %q, %v; want %q, %q", tt.input, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestLSPPosition(t *testing.T) {
+	tests := []struct {
+		text       string
+		lineNumber int
+		offset     int
+		utf16      lspPosition
+		utf8       lspPosition
+	}{
+		{"abc", 1, 2, lspPosition{0, 2}, lspPosition{0, 2}},
+		{"é x", 3, 3, lspPosition{2, 2}, lspPosition{2, 3}},
+		{"😀x", 1, 4, lspPosition{0, 2}, lspPosition{0, 4}},
+		{"a😀😀b", 1, 9, lspPosition{0, 5}, lspPosition{0, 9}},
+		{"ab\n€😀\nc", 10, len("ab\n€😀"), lspPosition{10, 3}, lspPosition{10, 7}},
+		{"ab\ncd", 1, 3, lspPosition{1, 0}, lspPosition{1, 0}},
+	}
+
+	for _, tt := range tests {
+		s := &lspServer{}
+		if got := s.position(tt.text, tt.lineNumber, tt.offset); got != tt.utf16 {
+			t.Errorf("UTF-16 position(%q, %d, %d) = %v, want %v", tt.text, tt.lineNumber, tt.offset, got, tt.utf16)
+		}
+		s.utf8Positions = true
+		if got := s.position(tt.text, tt.lineNumber, tt.offset); got != tt.utf8 {
+			t.Errorf("UTF-8 position(%q, %d, %d) = %v, want %v", tt.text, tt.lineNumber, tt.offset, got, tt.utf8)
+		}
+	}
+}
@@ -7,8 +7,31 @@
 // - Worker limiting to prevent resource exhaustion
 // - Context-based cancellation for clean Ctrl-C handling
 // - Channel-based result coordination
-// - Binary file detection and skipping
-// - Symlink handling
+// - Binary file detection (skip, search as text, or report without lines)
+// - Configurable maximum file size
+// - UTF-16 and single-byte encodings transcoded to UTF-8 (BOM sniffing)
+// - Searches standard input, individual files, or paths listed by other tools
+// - Several directory roots in one run, sharing one worker pool
+// - Optional search inside gzip/bzip2/zlib streams and zip/tar archives (-z)
+// - Symlinks skipped while walking by default, followed with -L (loop-safe)
+// - FIFOs, sockets and devices skipped; optionally stays on one filesystem
+// - Filters by depth, modification time, size and owner before opening files
+// - Search and replace with diff preview, dry run, and atomic rewrites
+// - Only-matching (-o) and capture group extraction (--extract) output
+// - Multiline matching across line boundaries (-U)
+// - Optional backtracking regex engine with lookaround and backreferences (-P)
+// - Go-aware search limited to code, comments, strings or identifiers (--go-scope)
+// - Enclosing function or section heading shown with each match (-p)
+// - Persistent, incrementally updated trigram index (search index, --index)
+// - Watch mode re-searching files as they change (inotify on Linux, else polling)
+// - Tail-follow mode for growing log files, surviving truncation and rotation (-f)
+// - HTTP server streaming search results as NDJSON or Server-Sent Events (search serve)
+// - JSON-RPC 2.0 mode for editors, answering with LSP locations (search lsp)
+// - Default options from config files and SEARCH_OPTS, overridden by the command line
+// - Colored output only on a terminal by default, with a configurable theme
+// - Clickable OSC 8 file links and a path:line:col:text format for editors (--vimgrep)
+// - SARIF 2.1.0 reports for code scanning in CI (--sarif)
+// - Pattern linter checking a YAML or JSON rule set in one traversal (search check)
 //
 // This implementation demonstrates advanced Go concurrency patterns including:
 // - errgroup for coordinated goroutine management
@@ -24,7 +47,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"strings"
 	"syscall"
 )
@@ -40,33 +63,88 @@ var verbose bool
 // Match represents a single search result containing the matched line
 // and its surrounding context (one line before and after).
 type Match struct {
-	FilePath   string // Full path to the file containing the match
-	LineNumber int    // Line number where the match was found (1-based)
-	Line       string // The actual line containing the match
-	Before     string // Line immediately before the match (empty if none)
-	After      string // Line immediately after the match (empty if none)
-	IsMatch    bool   // Always true for actual matches (used for type safety)
+	FilePath   string   // Full path to the file containing the match
+	LineNumber int      // Line number where the match was found (1-based)
+	EndLine    int      // Last line of the match; differs from LineNumber only in multiline mode
+	Line       string   // The actual line containing the match (all spanned lines in multiline mode)
+	Before     string   // Line immediately before the match (empty if none)
+	After      string   // Line immediately after the match (empty if none)
+	Column     int      // Byte column of the first match within its line (1-based); 0 if unknown
+	Spans      [][2]int // Byte offsets [start, end) of each match within Line
+	IsMatch    bool     // Always true for actual matches (used for type safety)
+	Binary     bool     // Match is in a binary file; only the path is meaningful
+
+	// Enclosing names the declaration the match is in (--go-enclosing) or holds
+	// the nearest heading line above it (-p), shown above the match together
+	// with the line it starts on.
+	Enclosing     string
+	EnclosingLine int
+
+	// Set in --replace mode. A changed line carries its new text in Replacement,
+	// while a per-file summary has IsMatch unset and the count in Replacements.
+	Replaced     bool   // Line was changed; Replacement holds the new text
+	Replacement  string // Line after replacement
+	Replacements int    // Number of replacements in the file (summaries only)
+	Written      bool   // Replacements were written back to the file (summaries only)
 }
 
 // main is the entry point. It follows the Clear Path style with minimal nesting
 // and a single error handling path at the end.
 func main() {
 	var err error
-	var pattern *regexp.Regexp
-	var searchDir string
-	var glob string
+	var pattern Matcher
+	var roots []Root
+	var files []string
 	var dirSearch *DirSearch
+	var opts Options
 	var ctx context.Context
 	var cancel context.CancelFunc
+	var report *sarifReport
+
+	// Subcommands take over the rest of the command line. They don't read config
+	// files or SEARCH_OPTS, so a server or CI check behaves the same for everyone
+	if len(os.Args) > 1 && os.Args[1] == "index" {
+		err = runIndex(os.Args[2:])
+		goto end
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		err = runServe(os.Args[2:])
+		goto end
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lsp" {
+		err = runLSP(os.Args[2:])
+		goto end
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		err = runCheck(os.Args[2:])
+		goto end
+	}
 
 	// Parse command line arguments and compile the regex pattern
-	err = parseArgs(&searchDir, &glob, &pattern)
+	opts = DefaultOptions()
+	err = parseArgs(&roots, &files, &pattern, &opts)
 	if err != nil {
 		goto end
 	}
+	colors, err = themeFor(opts)
+	if err != nil {
+		goto end
+	}
+	if opts.Hyperlink {
+		linkHost = hyperlinkHost()
+	}
 
 	// Create DirSearch instance
-	dirSearch = NewDirSearch(searchDir, glob, pattern, maxWorkers, verbose)
+	dirSearch = NewDirSearch(roots, pattern, opts)
+	dirSearch.AddFiles(files...)
+	if opts.SARIF {
+		report = &sarifReport{}
+		report.addRule(pattern.String(), "Matches of the pattern "+pattern.String(), "warning")
+		dirSearch.SetOutput(func(match Match) error {
+			report.add(pattern.String(), "Match of "+pattern.String(), match)
+			return nil
+		})
+	}
 
 	// Set up signal handling
 	ctx = context.Background()
@@ -80,6 +158,19 @@ func main() {
 
 	// Run the search
 	err = dirSearch.Run(ctx)
+	if opts.Tail && ctx.Err() != nil {
+		// Following only ends by being interrupted, which isn't a failure
+		err = nil
+	}
+	if err == nil && report != nil {
+		err = report.write(os.Stdout)
+	}
+	if err != nil || !opts.Watch {
+		goto end
+	}
+
+	// With --watch, keep searching changed files until interrupted
+	err = dirSearch.Watch(ctx)
 
 end:
 	// Clear Path style error handling: single exit point with proper error reporting
@@ -118,65 +209,187 @@ func setupSignalHandler(cancel context.CancelFunc) (err error) {
 	return err
 }
 
-// parseArgs processes command line arguments and handles the -v verbose flag.
-// It supports both directory-only patterns (with trailing slash) and glob patterns.
+// parseArgs processes command line arguments, storing recognized options in opts.
+// The last positional argument is the regex; any before it are paths to search.
+// Paths may be directories (searched recursively), glob patterns, plain files,
+// or "-" for standard input. It supports both directory-only patterns (with
+// trailing slash) and glob patterns.
 // Examples:
 //
-//	search ~/Projects/ "error"           -> search all files in ~/Projects
-//	search ~/Projects/*.go "func"        -> search only .go files
-//	search -v ~/Projects/ "error"        -> same as first, with verbose output
-func parseArgs(searchDir *string, glob *string, pattern **regexp.Regexp) (err error) {
-	var pathPattern string
-	var dir string
-	var file string
-	var expandedPath string
+//	search ~/Projects/ "error"                    -> search all files in ~/Projects
+//	search ~/Projects/*.go "func"                 -> search only .go files
+//	search -v ~/Projects/ "error"                 -> same as first, with verbose output
+//	search --max-filesize=2G ~/Projects/ "error"  -> also search files up to 2 GiB
+//	search --binary=without-match ~/bin/ "main"   -> report binary files that match
+//	search --encoding=latin1 ~/old/ "café"        -> decode files as ISO-8859-1
+//	search -z /var/log/ "panic"                   -> also search .gz logs and zip/tar archives
+//	git diff | search - "TODO"                    -> search standard input
+//	search main.go util.go ~/lib/ "func"          -> mix individual files and a directory
+//	search ~/src/a/ ~/src/b/*.go "TODO"           -> search several roots in one run
+//	search --absolute ~/src/a/ "TODO"             -> report absolute paths
+//	search -L ~/Projects/ "TODO"                  -> follow symlinked files and directories
+//	search --one-file-system / "secret"           -> don't descend into other mounts
+//	search --max-depth=2 ~/src/ "module"          -> only files in ~/src and its children
+//	search --newer=1d ~/src/ "TODO"               -> only files changed in the last day
+//	search --older=go.mod --max-size=1M ./ "x"    -> older than go.mod and at most 1 MiB
+//	search --replace='New$1' ./*.go 'Old(\w+)'    -> preview renaming OldX to NewX
+//	search --replace='New$1' --write ./*.go 'Old(\w+)' -> apply it
+//	search -o ./ 'JIRA-[0-9]+'                    -> print only the matched ticket IDs
+//	search -U ./*.go 'func \w+\(\)\s*\{\s*\}'        -> find empty functions spanning lines
+//	search --extract='$2' ./ 'href="(https?)://([^/"]+)' -> print just the host of each URL
+//	git ls-files -z | search -0 --files-from - "TODO"  -> search exactly the listed files
+//	search -P ./ '(\w+) \1\b'                     -> find doubled words with a backreference
+//	search --go-scope=code --go-enclosing ./*.go 'Println' -> calls only, not comments, with their function
+//	search -p ./docs/*.md "deprecated"            -> show the section each hit is under
+//	search index ~/src/ && search --index ~/src/ "Frobnicate" -> index once, then search fast
+//	search --watch src/ "TODO"                    -> keep showing TODOs as files change
+//	search -f '/var/log/*.log' "ERROR|panic"      -> like tail -F | grep, across rotations
+//	search serve --addr=:7070 ~/src/repo          -> answer POST /search over HTTP
+//	SEARCH_OPTS='--max-filesize=10M -L' search ./ "x" -> defaults for every search
+//	search --no-follow ./ "x"                     -> turn off a -L from the config
+//	search --color=always --colors=match:bold+yellow ./ "x" | less -R -> keep colors in a pager
+//	vim -q <(search --vimgrep ./ "TODO")          -> load the matches into the quickfix list
+//	search --sarif ./ "password\s*=" > results.sarif -> report matches to code scanning
+//	search check --rules=lint.yaml ./            -> fail if any rule's pattern is found
+func parseArgs(roots *[]Root, files *[]string, pattern *Matcher, opts *Options) (err error) {
+	var root Root
+	var isFile bool
+	var listed []string
 	var args []string
+	var cliOpts Options
+	var sources []configSource
+	var startPath string
 
-	// Filter out the -v flag while preserving argument order
-	// This demonstrates slice manipulation and flag processing
-	args = make([]string, 0, len(os.Args))
-	for _, arg := range os.Args {
-		if arg == "-v" {
-			verbose = true
-			continue // Skip the -v flag, don't add it to filtered args
+	// A first pass over the command line finds --no-config and the first path,
+	// which is where the search for a project's .searchrc starts
+	cliOpts = *opts
+	args, err = parseOptions(os.Args[1:], &cliOpts)
+	if err != nil {
+		goto end
+	}
+	if !cliOpts.NoConfig {
+		startPath = "."
+		if len(args) >= 2 && args[0] != "-" {
+			startPath = args[0]
+		}
+		sources, err = loadConfig(startPath)
+		if err != nil {
+			goto end
+		}
+		err = applyConfig(sources, opts)
+		if err != nil {
+			goto end
 		}
-		args = append(args, arg)
 	}
 
-	// Validate we have enough arguments after filtering
-	if len(args) < 3 {
-		err = fmt.Errorf("usage: %s [-v] <path_pattern> <regex_pattern>", os.Args[0])
+	// Pull options out while preserving the order of positional arguments; they
+	// override the defaults from the config
+	args, err = parseOptions(os.Args[1:], opts)
+	if err != nil {
 		goto end
 	}
+	if opts.Verbose {
+		for _, source := range sources {
+			fmt.Printf("[TRACE] Read default options from %s: %s\n", source.name, strings.Join(source.args, " "))
+		}
+	}
 
-	pathPattern = args[1]
+	// Validate we have enough arguments after filtering
+	if len(args) < 2 && !(len(args) == 1 && opts.FilesFrom != "") {
+		err = fmt.Errorf("usage: %s [-v] [-z] [-L] [-U] [-P] [-0] [--absolute] [--one-file-system] [--max-depth=N] [--min-depth=N] [--newer=WHEN] [--older=WHEN] [--min-size=SIZE] [--max-size=SIZE] [--owner=USER] [-o|--extract=TEMPLATE] [--replace=TEMPLATE [--dry-run|--write [--preserve-mtime]]] [--files-from=FILE] [--go-scope=code|comments|strings|identifiers] [--go-enclosing] [-p] [--index] [--watch] [-f] [--max-filesize=SIZE] [--binary=skip|text|without-match] [--encoding=ENC] [--no-config] [--color=auto|always|never] [--colors=PART:STYLE,...] [--vimgrep] [--hyperlink] [--sarif] <path>... <regex_pattern>", os.Args[0])
+		goto end
+	}
 
-	// Handle trailing slash as "search everything in this directory"
-	// This provides a clean UX: ~/Projects/ means "all files in ~/Projects"
-	if strings.HasSuffix(pathPattern, "/") {
-		// Remove trailing slash and expand tilde
-		expandedPath, err = expandTilde(strings.TrimSuffix(pathPattern, "/"))
+	for _, pathPattern := range args[:len(args)-1] {
+		root, isFile, err = splitPathArg(pathPattern)
 		if err != nil {
 			goto end
 		}
-		*searchDir = expandedPath
-		*glob = "*" // Match all filesz
-	} else {
-		// Split path into directory and glob pattern
-		// Example: ~/Projects/*.go -> dir="~/Projects", file="*.go"
-		dir = filepath.Dir(pathPattern)
-		file = filepath.Base(pathPattern)
-		expandedPath, err = expandTilde(dir)
+		if isFile {
+			*files = append(*files, root.Dir)
+			continue
+		}
+		*roots = append(*roots, root)
+	}
+
+	err = validateOptions(opts)
+	if err != nil {
+		goto end
+	}
+	if opts.Write && slices.Contains(*files, "-") {
+		err = fmt.Errorf("--write cannot rewrite standard input")
+		goto end
+	}
+	if opts.Watch && (slices.Contains(*files, "-") || opts.FilesFrom == "-") {
+		err = fmt.Errorf("--watch cannot watch standard input")
+		goto end
+	}
+
+	// Add the paths listed by another tool, e.g. `git ls-files -z`
+	if opts.FilesFrom != "" {
+		if opts.FilesFrom == "-" && slices.Contains(*files, "-") {
+			err = fmt.Errorf("cannot read both the file list and the content to search from standard input")
+			goto end
+		}
+		listed, err = readFileList(opts.FilesFrom, opts.NullSeparated)
 		if err != nil {
 			goto end
 		}
-		*searchDir = expandedPath
-		*glob = file
+		*files = append(*files, listed...)
 	}
 
 	// Compile the regex pattern - this validates it's syntactically correct
-	*pattern, err = regexp.Compile(args[2])
+	*pattern, err = compilePattern(args[len(args)-1], opts.PCRE)
 
 end:
 	return err
 }
+
+// splitPathArg interprets one path argument. Directories (and paths with a trailing
+// slash) mean "all files in this tree", an existing file or "-" is searched as-is
+// (isFile is set and its path returned in root.Dir), and anything else is split
+// into a directory and a glob matched against file names, e.g. ~/Projects/*.go.
+// The directory is displayed in output as typed, before ~ expansion.
+func splitPathArg(pathPattern string) (root Root, isFile bool, err error) {
+	var expandedPath string
+	var stat os.FileInfo
+
+	if pathPattern == "-" {
+		root.Dir, isFile = pathPattern, true
+		goto end
+	}
+
+	// Handle trailing slash as "search everything in this directory"
+	// This provides a clean UX: ~/Projects/ means "all files in ~/Projects"
+	if strings.HasSuffix(pathPattern, "/") && len(pathPattern) > 1 {
+		// Remove trailing slash and expand tilde
+		root.Display = strings.TrimSuffix(pathPattern, "/")
+		root.Dir, err = expandTilde(root.Display)
+		root.Glob = "*" // Match all files
+		goto end
+	}
+
+	expandedPath, err = expandTilde(pathPattern)
+	if err != nil {
+		goto end
+	}
+	stat, err = os.Stat(expandedPath)
+	if err == nil {
+		if stat.IsDir() {
+			root = Root{Dir: expandedPath, Glob: "*", Display: pathPattern}
+		} else {
+			root.Dir, isFile = expandedPath, true
+		}
+		goto end
+	}
+	err = nil
+
+	// Split path into directory and glob pattern
+	// Example: ~/Projects/*.go -> dir="~/Projects", glob="*.go"
+	root.Display = filepath.Dir(pathPattern)
+	root.Dir, err = expandTilde(root.Display)
+	root.Glob = filepath.Base(pathPattern)
+
+end:
+	return root, isFile, err
+}
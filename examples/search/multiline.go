@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// searchMultiline runs the pattern over the whole content at once (-U), so a match
+// may span several lines, e.g. `func \w+\(\)\s*\{\s*\}`. Matches whose lines
+// overlap are reported together as one Match covering LineNumber to EndLine,
+// with Line holding all spanned lines and Before/After the lines around them.
+// The content is read into memory, which --max-filesize keeps bounded.
+func (ds *DirSearch) searchMultiline(ctx context.Context, filePath string, r io.Reader, isBinary bool) (err error) {
+	var data []byte
+	var content string
+	var found [][]int
+	var lineStarts []int
+
+	data, err = io.ReadAll(r)
+	if err != nil {
+		if errors.Is(err, errSizeLimit) {
+			ds.recordSkip(filePath, skipLarge)
+		} else if ds.verbose {
+			fmt.Printf("[TRACE] Cannot read %s: %v\n", filePath, err)
+		}
+		err = nil
+		goto end
+	}
+	content = string(data)
+
+	// The sample only covered the start; check the rest for binary data too
+	if !isBinary && ds.opts.Binary != BinaryText && strings.IndexByte(content, 0) >= 0 {
+		isBinary = true
+		if ds.opts.Binary == BinarySkip {
+			ds.recordSkip(filePath, skipBinary)
+			goto end
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+		goto end
+	default:
+	}
+
+	// Empty matches span nothing and would only produce noise
+	found, err = findAll(ds.pattern, content, -1)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", filePath, err)
+		goto end
+	}
+	found = nonEmptyMatches(found)
+	if len(found) == 0 {
+		goto end
+	}
+
+	if ds.verbose {
+		fmt.Printf("[TRACE] Found %d multiline matches in %s\n", len(found), filePath)
+	}
+
+	if isBinary {
+		err = ds.sendBinaryMatch(ctx, filePath)
+		goto end
+	}
+
+	lineStarts = computeLineStarts(content)
+	if ds.opts.OnlyMatching || ds.opts.Extracting {
+		err = ds.sendMultilineExtracts(ctx, filePath, content, lineStarts, found)
+		goto end
+	}
+	err = ds.sendMultilineMatches(ctx, filePath, content, lineStarts, found)
+
+end:
+	return err
+}
+
+// sendMultilineMatches groups matches whose line ranges touch and sends each group
+// as a single Match, so every spanned line is printed once with all its matches
+// highlighted.
+func (ds *DirSearch) sendMultilineMatches(ctx context.Context, filePath, content string, lineStarts []int, found [][]int) (err error) {
+	var match Match
+	var startLine int
+	var endLine int
+	var blockStart int
+	var blockEnd int
+	var spans [][2]int
+	var i int
+
+	for i < len(found) {
+		startLine = lineIndex(lineStarts, found[i][0])
+		endLine = lineIndex(lineStarts, found[i][1]-1)
+		spans = [][2]int{{found[i][0], found[i][1]}}
+
+		// Pull in following matches that start on a line already covered
+		for i++; i < len(found) && lineIndex(lineStarts, found[i][0]) <= endLine; i++ {
+			endLine = max(endLine, lineIndex(lineStarts, found[i][1]-1))
+			spans = append(spans, [2]int{found[i][0], found[i][1]})
+		}
+
+		blockStart = lineStarts[startLine]
+		blockEnd = lineEnd(content, lineStarts, endLine)
+		for j := range spans {
+			spans[j] = [2]int{spans[j][0] - blockStart, min(spans[j][1], blockEnd) - blockStart}
+		}
+
+		match = Match{
+			FilePath:   filePath,
+			LineNumber: startLine + 1,
+			EndLine:    endLine + 1,
+			Line:       content[blockStart:blockEnd],
+			Column:     spans[0][0] + 1,
+			Spans:      spans,
+			IsMatch:    true,
+		}
+		if ds.opts.ShowFunction {
+			// The first spanned line may itself be the heading
+			h := headingAbove(headingRuleFor(filePath), content, lineStarts, startLine+1)
+			match.Enclosing, match.EnclosingLine = h.text, h.line
+		}
+		// Context is relative to the whole span, not just its first line
+		if startLine > 0 {
+			match.Before = lineText(content, lineStarts, startLine-1)
+		}
+		if endLine+1 < len(lineStarts) {
+			match.After = lineText(content, lineStarts, endLine+1)
+		}
+
+		select {
+		case ds.matchChan <- match:
+		case <-ctx.Done():
+			err = ctx.Err()
+			goto end
+		}
+	}
+
+end:
+	return err
+}
+
+// sendMultilineExtracts is the -o/--extract counterpart of sendMultilineMatches:
+// one result per match, holding the (possibly multi-line) matched text or the
+// expanded template.
+func (ds *DirSearch) sendMultilineExtracts(ctx context.Context, filePath, content string, lineStarts []int, found [][]int) (err error) {
+	var match Match
+
+	for _, m := range found {
+		match = Match{
+			FilePath:   filePath,
+			LineNumber: lineIndex(lineStarts, m[0]) + 1,
+			EndLine:    lineIndex(lineStarts, m[1]-1) + 1,
+			Column:     m[0] - lineStarts[lineIndex(lineStarts, m[0])] + 1,
+			IsMatch:    true,
+		}
+		if ds.opts.Extracting {
+			match.Line = expandTemplate(ds.pattern, ds.opts.Extract, content, m)
+			match.EndLine = match.LineNumber
+		} else {
+			match.Line = content[m[0]:m[1]]
+			match.Spans = [][2]int{{0, len(match.Line)}}
+		}
+
+		select {
+		case ds.matchChan <- match:
+		case <-ctx.Done():
+			err = ctx.Err()
+			goto end
+		}
+	}
+
+end:
+	return err
+}
+
+// nonEmptyMatches drops zero-length matches from FindAll results.
+func nonEmptyMatches(found [][]int) (result [][]int) {
+	result = found[:0]
+	for _, m := range found {
+		if m[1] > m[0] {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// computeLineStarts returns the byte offset at which each line of content starts.
+func computeLineStarts(content string) (starts []int) {
+	starts = append(starts, 0)
+	for i := 0; i < len(content); i++ {
+		if content[i] == '\n' && i+1 < len(content) {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+// lineIndex returns the zero-based line containing the byte at offset.
+func lineIndex(lineStarts []int, offset int) int {
+	return sort.Search(len(lineStarts), func(i int) bool {
+		return lineStarts[i] > offset
+	}) - 1
+}
+
+// lineEnd returns the offset just past the last character of a line, excluding
+// its line terminator. The last line may or may not end with one.
+func lineEnd(content string, lineStarts []int, line int) (end int) {
+	end = len(content)
+	if line+1 < len(lineStarts) {
+		end = lineStarts[line+1]
+	}
+	text := strings.TrimSuffix(content[lineStarts[line]:end], "\n")
+	text = strings.TrimSuffix(text, "\r")
+	return lineStarts[line] + len(text)
+}
+
+// lineText returns a single line of content without its terminator.
+func lineText(content string, lineStarts []int, line int) string {
+	return content[lineStarts[line]:lineEnd(content, lineStarts, line)]
+}
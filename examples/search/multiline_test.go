@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+// collectMatches searches the files of dir for pattern and returns the matches.
+func collectMatches(t *testing.T, dir, pattern string, opts Options) (matches []Match) {
+	ds := NewDirSearch([]Root{{Dir: dir, Glob: "*", Display: dir}}, regexp.MustCompile(pattern), opts)
+	ds.SetOutput(func(match Match) error {
+		matches = append(matches, match)
+		return nil
+	})
+	if err := ds.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestLineText(t *testing.T) {
+	tests := []struct {
+		content string
+		lines   []string
+	}{
+		{"", []string{""}},
+		{"a", []string{"a"}},
+		{"a\n", []string{"a"}},
+		{"a\nb", []string{"a", "b"}},
+		{"a\r\nb\r\n", []string{"a", "b"}},
+		{"a\n\n", []string{"a", ""}},
+		{"\n", []string{""}},
+	}
+
+	for _, tt := range tests {
+		lineStarts := computeLineStarts(tt.content)
+		var lines []string
+		for i := range lineStarts {
+			lines = append(lines, lineText(tt.content, lineStarts, i))
+		}
+		if !slices.Equal(lines, tt.lines) {
+			t.Errorf("lines of %q = %q, want %q", tt.content, lines, tt.lines)
+		}
+	}
+}
+
+// TestMultilineLastLine checks that a match on the last line doesn't gain an
+// empty line after it from the file's final newline.
+func TestMultilineLastLine(t *testing.T) {
+	tests := []struct {
+		content string
+		pattern string
+		line    string
+		before  string
+		after   string
+	}{
+		{"x\nfunc a() {\n}\nb\n", "b", "b", "}", ""},
+		{"x\nb", "b", "b", "x", ""},
+		{"x\r\nb\r\n", "b", "b", "x", ""},
+		{"x\ny\nz\n", `y\nz\n`, "y\nz", "x", ""},
+		{"x\ny\nz\n", `x\ny`, "x\ny", "", "z"},
+	}
+
+	for _, tt := range tests {
+		dir := writeTree(t, map[string]string{"a.txt": tt.content})
+		opts := DefaultOptions()
+		opts.Multiline = true
+		matches := collectMatches(t, dir, tt.pattern, opts)
+		if len(matches) != 1 {
+			t.Errorf("%q in %q: %d matches, want 1", tt.pattern, tt.content, len(matches))
+			continue
+		}
+		match := matches[0]
+		if match.Line != tt.line || match.Before != tt.before || match.After != tt.after || match.FilePath != filepath.Join(dir, "a.txt") {
+			t.Errorf("%q in %q: line %q, before %q, after %q; want %q, %q, %q", tt.pattern, tt.content, match.Line, match.Before, match.After, tt.line, tt.before, tt.after)
+		}
+	}
+}
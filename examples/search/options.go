@@ -0,0 +1,419 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaxFileSize is the largest file searched when --max-filesize is not given.
+const defaultMaxFileSize = 50 * 1024 * 1024
+
+// BinaryMode controls how files that look binary are handled.
+type BinaryMode string
+
+const (
+	// BinarySkip ignores binary files entirely (the default).
+	BinarySkip BinaryMode = "skip"
+	// BinaryText searches binary files as if they were text.
+	BinaryText BinaryMode = "text"
+	// BinaryWithoutMatch reports "Binary file X matches" instead of printing lines, like grep.
+	BinaryWithoutMatch BinaryMode = "without-match"
+)
+
+// Options holds the tunable settings of a DirSearch.
+// Use DefaultOptions to get a value with sensible defaults filled in.
+type Options struct {
+	MaxWorkers  int        // Maximum number of concurrent file searches
+	Verbose     bool       // Enables [TRACE] output
+	MaxFileSize int64      // Files larger than this are skipped; 0 means no limit
+	Binary      BinaryMode // What to do with files that look binary
+	Encoding    Encoding   // How file content is decoded before matching
+	SearchZip   bool       // Search inside compressed files and archives (-z)
+	Multiline   bool       // Match against whole files so matches can span lines (-U)
+	PCRE        bool       // Use the backtracking engine for lookaround and backreferences (-P)
+
+	// FollowSymlinks descends into symlinked directories and searches symlinked
+	// files found while walking (-L). Without it both kinds of symlink are skipped.
+	// Paths given explicitly are always followed. Loops are detected either way.
+	FollowSymlinks bool
+
+	// OneFileSystem stops the walk at mount points, so a search from / doesn't
+	// wander into /proc or network mounts (compares st_dev with the root's).
+	OneFileSystem bool
+
+	// MaxDepth and MinDepth limit which files are searched by how deep they are
+	// below their root; files directly in a root have depth 1. A MaxDepth of 0
+	// means no limit, so --max-depth=0, which would leave nothing to search, is
+	// rejected. Explicitly added files are not affected.
+	MaxDepth int
+	MinDepth int
+
+	// Metadata filters applied to files found while walking, before they are
+	// opened. Zero times and sizes mean no limit; an Owner of -1 means any owner.
+	// MaxSize only selects files, unlike MaxFileSize which guards against huge ones.
+	Newer   time.Time // Only files modified after this time
+	Older   time.Time // Only files modified before this time
+	MinSize int64     // Only files of at least this many bytes
+	MaxSize int64     // Only files of at most this many bytes
+	Owner   int       // Only files owned by this user ID
+
+	// Replace mode rewrites matches using a template with $1 or ${name} capture
+	// references. Without Write the changes are only previewed as a diff, and
+	// DryRun reduces that to a count of replacements per file.
+	Replacing     bool   // Set by --replace; Replace may be empty to delete matches
+	Replace       string // Replacement template
+	Write         bool   // Write the changes back to the files atomically
+	DryRun        bool   // Only summarize files and replacement counts
+	PreserveMtime bool   // Keep each rewritten file's modification time
+
+	// OnlyMatching prints each match instead of the whole line (-o), and
+	// Extract prints a template built from each match's capture groups instead.
+	OnlyMatching bool
+	Extracting   bool   // Set by --extract
+	Extract      string // Template with $1 or ${name} capture references
+
+	// AbsolutePaths reports absolute file paths instead of paths relative to the
+	// root (as given on the command line) that they were found under.
+	AbsolutePaths bool
+
+	// FilesFrom names a file listing paths to search, one per line ("-" for stdin).
+	// NullSeparated switches the list to NUL-delimited, as produced by `git ls-files -z`.
+	FilesFrom     string
+	NullSeparated bool
+
+	// GoScope limits matches in .go files to code, comments, string literals or
+	// identifiers, using go/scanner. GoEnclosing tags each matching line in a .go
+	// file with the function or type declaration it is in, using go/parser.
+	GoScope     GoScope
+	GoEnclosing bool
+
+	// ShowFunction shows the nearest function, class or section heading above
+	// each match (-p), found with per-language line patterns.
+	ShowFunction bool
+
+	// UseIndex skips files that the trigram index built by `search index` shows
+	// can't match (--index). Files changed since indexing are still searched.
+	UseIndex bool
+
+	// Watch keeps running after the search and searches files again as they are
+	// created, modified or removed, printing matches that appear or disappear.
+	Watch bool
+
+	// Tail keeps reading files as they grow, like `tail -F | grep`, starting over
+	// when one is truncated or replaced by rotation, until interrupted (-f/--tail).
+	// Each followed file holds a worker, so at most MaxWorkers files are followed.
+	Tail bool
+
+	// NoConfig ignores the config files and SEARCH_OPTS (--no-config).
+	NoConfig bool
+
+	// Color decides when output is colored, and Colors changes the colors used
+	// for paths, line numbers, matches and context (see parseColorSpecs).
+	// Repeated --colors options are combined, later entries winning.
+	Color  ColorMode
+	Colors string
+
+	// Vimgrep prints each match as path:line:column:text for editors' quickfix
+	// lists and problem matchers, and Hyperlink makes the paths printed OSC 8
+	// terminal hyperlinks to file://host/path#line.
+	Vimgrep   bool
+	Hyperlink bool
+
+	// SARIF prints all matches at the end as one SARIF 2.1.0 document, for code
+	// scanning services (--sarif).
+	SARIF bool
+}
+
+// DefaultOptions returns the options used when nothing is configured.
+func DefaultOptions() Options {
+	return Options{
+		MaxWorkers:  maxWorkers,
+		MaxFileSize: defaultMaxFileSize,
+		Binary:      BinarySkip,
+		Encoding:    EncodingAuto,
+		Owner:       -1,
+		Color:       ColorAuto,
+	}
+}
+
+// parseOptions consumes the options it recognizes from args, storing their values
+// in opts, and returns the remaining positional arguments in their original order.
+// Options taking a value accept both "--name=value" and "--name value" forms, and
+// a bare "--" ends option processing so patterns may start with a dash. Boolean
+// options have a --no- form turning them off again, to override a config file.
+func parseOptions(args []string, opts *Options) (positional []string, err error) {
+	var name string
+	var value string
+	var hasValue bool
+
+	positional = make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+
+		// Anything not starting with a dash (or a lone dash) is positional
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name, value, hasValue = strings.Cut(arg, "=")
+		switch name {
+		case "-v":
+			opts.Verbose = true
+			verbose = true
+		case "-z", "--search-zip", "--no-search-zip":
+			opts.SearchZip = name != "--no-search-zip"
+		case "-L", "--follow", "--no-follow":
+			opts.FollowSymlinks = name != "--no-follow"
+		case "--one-file-system", "--no-one-file-system":
+			opts.OneFileSystem = name != "--no-one-file-system"
+		case "--max-depth", "--min-depth":
+			value, err = optionValue(args, &i, name, value, hasValue)
+			if err != nil {
+				goto end
+			}
+			if name == "--max-depth" {
+				opts.MaxDepth, err = parseDepth(name, value)
+			} else {
+				opts.MinDepth, err = parseDepth(name, value)
+			}
+		case "--newer", "--older":
+			value, err = optionValue(args, &i, name, value, hasValue)
+			if err != nil {
+				goto end
+			}
+			if name == "--newer" {
+				opts.Newer, err = parseTimeSpec(value)
+			} else {
+				opts.Older, err = parseTimeSpec(value)
+			}
+		case "--min-size", "--max-size":
+			value, err = optionValue(args, &i, name, value, hasValue)
+			if err != nil {
+				goto end
+			}
+			if name == "--min-size" {
+				opts.MinSize, err = parseSize(value)
+			} else {
+				opts.MaxSize, err = parseSize(value)
+			}
+		case "--owner":
+			value, err = optionValue(args, &i, name, value, hasValue)
+			if err != nil {
+				goto end
+			}
+			opts.Owner, err = parseOwner(value)
+		case "--replace":
+			opts.Replace, err = optionValue(args, &i, name, value, hasValue)
+			opts.Replacing = true
+		case "-U", "--multiline", "--no-multiline":
+			opts.Multiline = name != "--no-multiline"
+		case "-P", "--pcre", "--no-pcre":
+			opts.PCRE = name != "--no-pcre"
+		case "-o", "--only-matching", "--no-only-matching":
+			opts.OnlyMatching = name != "--no-only-matching"
+		case "--extract":
+			opts.Extract, err = optionValue(args, &i, name, value, hasValue)
+			opts.Extracting = true
+		case "--write":
+			opts.Write = true
+		case "--dry-run":
+			opts.DryRun = true
+		case "--preserve-mtime":
+			opts.PreserveMtime = true
+		case "--absolute", "--no-absolute":
+			opts.AbsolutePaths = name != "--no-absolute"
+		case "-0", "--null", "--no-null":
+			opts.NullSeparated = name != "--no-null"
+		case "--files-from":
+			opts.FilesFrom, err = optionValue(args, &i, name, value, hasValue)
+		case "--go-scope":
+			value, err = optionValue(args, &i, name, value, hasValue)
+			if err != nil {
+				goto end
+			}
+			opts.GoScope, err = parseGoScope(value)
+		case "--go-enclosing", "--no-go-enclosing":
+			opts.GoEnclosing = name != "--no-go-enclosing"
+		case "-p", "--show-function", "--no-show-function":
+			opts.ShowFunction = name != "--no-show-function"
+		case "--index", "--no-index":
+			opts.UseIndex = name != "--no-index"
+		case "--watch", "--no-watch":
+			opts.Watch = name != "--no-watch"
+		case "-f", "--tail", "--no-tail":
+			opts.Tail = name != "--no-tail"
+		case "--no-config":
+			opts.NoConfig = true
+		case "--vimgrep", "--no-vimgrep":
+			opts.Vimgrep = name != "--no-vimgrep"
+		case "--hyperlink", "--no-hyperlink":
+			opts.Hyperlink = name != "--no-hyperlink"
+		case "--sarif", "--no-sarif":
+			opts.SARIF = name != "--no-sarif"
+		case "--color":
+			value, err = optionValue(args, &i, name, value, hasValue)
+			if err == nil {
+				opts.Color, err = parseColorMode(value)
+			}
+		case "--colors":
+			value, err = optionValue(args, &i, name, value, hasValue)
+			if err == nil {
+				err = parseColorSpecs(value, &colorTheme{})
+			}
+			if err == nil {
+				opts.Colors = strings.Trim(opts.Colors+","+value, ",")
+			}
+		case "--max-filesize":
+			value, err = optionValue(args, &i, name, value, hasValue)
+			if err != nil {
+				goto end
+			}
+			opts.MaxFileSize, err = parseSize(value)
+		case "--binary":
+			value, err = optionValue(args, &i, name, value, hasValue)
+			if err != nil {
+				goto end
+			}
+			opts.Binary, err = parseBinaryMode(value)
+		case "--encoding":
+			value, err = optionValue(args, &i, name, value, hasValue)
+			if err != nil {
+				goto end
+			}
+			opts.Encoding, err = parseEncoding(value)
+		default:
+			err = fmt.Errorf("unknown option: %s", arg)
+		}
+		if err != nil {
+			goto end
+		}
+	}
+
+end:
+	return positional, err
+}
+
+// optionValue returns the value of an option, either the one given inline after "="
+// or the next argument, advancing the caller's index in the latter case.
+func optionValue(args []string, i *int, name, value string, hasValue bool) (result string, err error) {
+	if hasValue {
+		result = value
+		goto end
+	}
+	if *i+1 >= len(args) {
+		err = fmt.Errorf("option %s requires a value", name)
+		goto end
+	}
+	*i++
+	result = args[*i]
+
+end:
+	return result, err
+}
+
+// parseSize converts a human-readable size such as "512", "10K", "10M" or "2G"
+// into bytes. Units are binary (K = 1024) and an optional trailing "B" is allowed.
+func parseSize(s string) (size int64, err error) {
+	var multiplier int64 = 1
+	var number string
+
+	number = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	if number == "" {
+		err = fmt.Errorf("invalid size: %q", s)
+		goto end
+	}
+
+	switch number[len(number)-1] {
+	case 'K':
+		multiplier = 1 << 10
+	case 'M':
+		multiplier = 1 << 20
+	case 'G':
+		multiplier = 1 << 30
+	case 'T':
+		multiplier = 1 << 40
+	}
+	if multiplier != 1 {
+		number = number[:len(number)-1]
+	}
+
+	size, err = strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		err = fmt.Errorf("invalid size: %q", s)
+		goto end
+	}
+	if size > math.MaxInt64/multiplier {
+		err = fmt.Errorf("invalid size: %q (too large)", s)
+		goto end
+	}
+	size *= multiplier
+
+end:
+	return size, err
+}
+
+// parseBinaryMode validates the value of the --binary option.
+func parseBinaryMode(s string) (mode BinaryMode, err error) {
+	mode = BinaryMode(s)
+	switch mode {
+	case BinarySkip, BinaryText, BinaryWithoutMatch:
+	default:
+		err = fmt.Errorf("invalid --binary mode %q (want skip, text or without-match)", s)
+	}
+	return mode, err
+}
+
+// parseDepth validates the value of --max-depth or --min-depth.
+func parseDepth(name, s string) (depth int, err error) {
+	depth, err = strconv.Atoi(s)
+	switch {
+	case err != nil || depth < 0:
+		err = fmt.Errorf("invalid %s %q (want a non-negative number)", name, s)
+	case depth == 0 && name == "--max-depth":
+		err = fmt.Errorf("invalid --max-depth 0 (files directly in a root have depth 1)")
+	}
+	return depth, err
+}
+
+// validateOptions checks for options that don't make sense together.
+func validateOptions(opts *Options) (err error) {
+	switch {
+	case (opts.Write || opts.DryRun || opts.PreserveMtime) && !opts.Replacing:
+		err = fmt.Errorf("--write, --dry-run and --preserve-mtime require --replace")
+	case opts.Write && opts.DryRun:
+		err = fmt.Errorf("--write and --dry-run cannot be combined")
+	case opts.Replacing && (opts.OnlyMatching || opts.Extracting):
+		err = fmt.Errorf("--replace cannot be combined with -o or --extract")
+	case opts.Replacing && opts.Multiline:
+		err = fmt.Errorf("--replace works line by line and cannot be combined with -U")
+	case opts.Replacing && opts.SearchZip:
+		err = fmt.Errorf("--replace cannot rewrite compressed files (-z)")
+	case opts.Replacing && opts.GoScope != GoScopeAll:
+		err = fmt.Errorf("--replace cannot be combined with --go-scope")
+	case opts.UseIndex && opts.SearchZip:
+		err = fmt.Errorf("--index only covers uncompressed content and cannot be combined with -z")
+	case opts.Vimgrep && opts.Replacing:
+		err = fmt.Errorf("--vimgrep cannot be combined with --replace")
+	case opts.SARIF && (opts.Verbose || opts.Vimgrep):
+		// Both would mix other text into the JSON document
+		err = fmt.Errorf("--sarif cannot be combined with -v or --vimgrep")
+	case opts.SARIF && (opts.Replacing || opts.OnlyMatching || opts.Extracting || opts.Watch || opts.Tail):
+		err = fmt.Errorf("--sarif reports match locations once and cannot be combined with --replace, -o, --extract, --watch or --tail")
+	case opts.Watch && opts.Replacing:
+		err = fmt.Errorf("--watch cannot be combined with --replace")
+	case opts.Tail && (opts.Replacing || opts.Watch):
+		err = fmt.Errorf("-f/--tail cannot be combined with --replace or --watch")
+	case opts.Tail && (opts.Multiline || opts.SearchZip || opts.GoScope != GoScopeAll || opts.GoEnclosing):
+		err = fmt.Errorf("-f/--tail reads line by line and cannot be combined with -U, -z, --go-scope or --go-enclosing")
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		size int64
+		err  string
+	}{
+		{"0", 0, ""},
+		{"512", 512, ""},
+		{"10K", 10 << 10, ""},
+		{"10kb", 10 << 10, ""},
+		{" 2M ", 2 << 20, ""},
+		{"3G", 3 << 30, ""},
+		{"1T", 1 << 40, ""},
+		{"8388607T", 8388607 << 40, ""},
+		{"9223372036854775807", 1<<63 - 1, ""},
+		{"", 0, "invalid size"},
+		{"K", 0, "invalid size"},
+		{"-1", 0, "invalid size"},
+		{"1.5M", 0, "invalid size"},
+		{"8388608T", 0, "too large"},
+		{"99999999999G", 0, "too large"},
+		{"9223372036854775808", 0, "invalid size"},
+	}
+
+	for _, tt := range tests {
+		size, err := parseSize(tt.s)
+		if tt.err == "" && (err != nil || size != tt.size) {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", tt.s, size, err, tt.size)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("parseSize(%q) = %d, %v; want an error containing %q", tt.s, size, err, tt.err)
+		}
+	}
+}
+
+func TestParseDepth(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		depth int
+		err   string
+	}{
+		{"--max-depth", "1", 1, ""},
+		{"--max-depth", "3", 3, ""},
+		{"--max-depth", "0", 0, "depth 1"},
+		{"--max-depth", "-1", 0, "non-negative"},
+		{"--min-depth", "0", 0, ""},
+		{"--min-depth", "2", 2, ""},
+		{"--min-depth", "x", 0, "non-negative"},
+	}
+
+	for _, tt := range tests {
+		depth, err := parseDepth(tt.name, tt.s)
+		if tt.err == "" && (err != nil || depth != tt.depth) {
+			t.Errorf("parseDepth(%s, %q) = %d, %v; want %d", tt.name, tt.s, depth, err, tt.depth)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("parseDepth(%s, %q) = %d, %v; want an error containing %q", tt.name, tt.s, depth, err, tt.err)
+		}
+	}
+}
@@ -3,29 +3,66 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 )
 
-// printHighlightedLine prints a line with ANSI color highlighting.
-// In a production version, you'd re-apply the regex pattern to highlight
-// only the matching portions, but this simplified version highlights the entire line.
-func printHighlightedLine(lineNum int, line string) (err error) {
+// printHighlightedLine prints a line with ANSI color highlighting of the
+// matched portions given by spans, using the colors theme. A multiline match's text is printed one
+// numbered line at a time, each with its part of the spans highlighted.
+func printHighlightedLine(lineNum int, line string, spans [][2]int) (err error) {
 	var highlighted string
+	var offset int
 
-	// Apply ANSI color codes for highlighting
-	highlighted = highlightMatch(line)
-	fmt.Printf("%d:  %s\n", lineNum, highlighted)
+	for i, text := range strings.Split(line, "\n") {
+		trimmed := strings.TrimSuffix(text, "\r")
+
+		// Apply ANSI color codes for highlighting
+		highlighted = highlightMatch(trimmed, clipSpans(spans, offset, offset+len(trimmed)))
+		fmt.Printf("%s:  %s\n", paint(colors.lineNumber, strconv.Itoa(lineNum+i)), highlighted)
+
+		offset += len(text) + 1
+	}
 
 	return err
 }
 
-// highlightMatch applies ANSI color codes to highlight text.
-// Uses red color (code 31) with reset (code 0) afterward.
-// In a more sophisticated version, this would re-apply the regex pattern
-// to highlight only the matching portions within the line.
-func highlightMatch(line string) (result string) {
-	// Simple highlighting: make entire line red
-	// \033[31m = red text, \033[0m = reset to normal
-	result = fmt.Sprintf("\033[31m%s\033[0m", line)
+// clipSpans returns the parts of spans that fall within [start, end), shifted
+// so they are relative to start.
+func clipSpans(spans [][2]int, start, end int) (clipped [][2]int) {
+	for _, span := range spans {
+		from, to := max(span[0], start), min(span[1], end)
+		if from < to {
+			clipped = append(clipped, [2]int{from - start, to - start})
+		}
+	}
+	return clipped
+}
+
+// highlightMatch applies ANSI color codes to highlight the matched portions of
+// a line in the theme's match color (red by default), or leaves the line as is
+// when output isn't colored. spans are the byte offsets of each match, in order
+// and not overlapping, as returned by the regexp FindAll functions.
+func highlightMatch(line string, spans [][2]int) (result string) {
+	var sb strings.Builder
+	var last int
+
+	if colors.match == "" {
+		return line
+	}
+	for _, span := range spans {
+		// Empty matches have nothing to color
+		if span[0] == span[1] {
+			continue
+		}
+		sb.WriteString(line[last:span[0]])
+		sb.WriteString(paint(colors.match, line[span[0]:span[1]]))
+		last = span[1]
+	}
+	sb.WriteString(line[last:])
+	result = sb.String()
 	return result
 }
 
@@ -33,29 +70,156 @@ func highlightMatch(line string) (result string) {
 // It shows the file path, context lines, and highlights the matching line.
 // The format mimics grep's output style for familiarity.
 func printMatch(match Match) (err error) {
+	var inView bool
+
+	// Per-file replacement summaries aren't lines at all
+	if !match.IsMatch {
+		printReplaceSummary(match)
+		goto end
+	}
+
+	// Replacements are shown as a diff of the old and new line
+	if match.Replaced {
+		printReplacement(match)
+		goto end
+	}
+
+	// Binary files only get a one-line notice, like grep
+	if match.Binary {
+		fmt.Printf("\nBinary file %s matches\n", linkPath(match.FilePath, 0, paint(colors.path, match.FilePath)))
+		goto end
+	}
+
 	// Print file path header
-	fmt.Printf("\n%s:\n", match.FilePath)
+	fmt.Printf("\n%s:\n", linkPath(match.FilePath, match.LineNumber, paint(colors.path, match.FilePath)))
+
+	// Show the enclosing declaration like git grep -p, unless it's already in view
+	inView = match.EnclosingLine == match.LineNumber || (match.Before != "" && match.EnclosingLine == match.LineNumber-1)
+	if match.Enclosing != "" && !inView {
+		fmt.Printf("%s=  %s\n", paint(colors.lineNumber, strconv.Itoa(match.EnclosingLine)), match.Enclosing)
+	}
 
 	// Print line before match (if exists)
 	if match.Before != "" {
-		fmt.Printf("%d-  %s\n", match.LineNumber-1, match.Before)
+		fmt.Printf("%s-  %s\n", paint(colors.lineNumber, strconv.Itoa(match.LineNumber-1)), paint(colors.context, match.Before))
 	}
 
 	// Print the matching line with highlighting
-	err = printHighlightedLine(match.LineNumber, match.Line)
+	err = printHighlightedLine(match.LineNumber, match.Line, match.Spans)
 	if err != nil {
 		goto end
 	}
 
 	// Print line after match (if exists)
 	if match.After != "" {
-		fmt.Printf("%d+  %s\n", match.LineNumber+1, match.After)
+		fmt.Printf("%s+  %s\n", paint(colors.lineNumber, strconv.Itoa(max(match.EndLine, match.LineNumber)+1)), paint(colors.context, match.After))
+	}
+
+end:
+	return err
+}
+
+// printVimgrep prints a match on one line as path:line:column:text, the format
+// of vim's 'grepformat' and of VS Code problem matchers. Only the first line of
+// a multiline match is shown.
+func printVimgrep(match Match) (err error) {
+	var first string
+
+	if !match.IsMatch || match.Binary {
+		// Nothing to jump to; keep the usual notices
+		err = printMatch(match)
+		goto end
 	}
 
+	first, _, _ = strings.Cut(match.Line, "\n")
+	first = strings.TrimSuffix(first, "\r")
+	fmt.Printf("%s:%s:%s:%s\n",
+		linkPath(match.FilePath, match.LineNumber, paint(colors.path, match.FilePath)),
+		paint(colors.lineNumber, strconv.Itoa(match.LineNumber)),
+		paint(colors.lineNumber, strconv.Itoa(max(match.Column, 1))),
+		highlightMatch(first, clipSpans(match.Spans, 0, len(first))))
+
 end:
 	return err
 }
 
+// linkHost is the host named in hyperlinks to files. It is set by main with
+// --hyperlink; while it is empty no hyperlinks are printed.
+var linkHost string
+
+// hyperlinkHost returns the host to name in hyperlinks, falling back to
+// localhost when the host name is unknown.
+func hyperlinkHost() (host string) {
+	var err error
+
+	host, err = os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return host
+}
+
+// linkPath makes text an OSC 8 terminal hyperlink to line of the file at path,
+// or returns it unchanged when hyperlinks are off or there is no file to link
+// to. Files inside archives link to the archive.
+func linkPath(path string, line int, text string) string {
+	var file string
+	var target *url.URL
+
+	if linkHost == "" || path == stdinName {
+		return text
+	}
+	file, _, _ = strings.Cut(path, "!/")
+	target = fileURL(linkHost, absPath(file))
+	if line > 0 {
+		target.Fragment = strconv.Itoa(line)
+	}
+	return "\033]8;;" + target.String() + "\033\\" + text + "\033]8;;\033\\"
+}
+
+// printReplacement shows one changed line as a diff: the old line prefixed with
+// "-" (red when colored) and the new line prefixed with "+" (green).
+func printReplacement(match Match) {
+	fmt.Printf("\n%s:\n", paint(colors.path, match.FilePath))
+	fmt.Println(paint(colors.removed, fmt.Sprintf("-%d:  %s", match.LineNumber, match.Line)))
+	fmt.Println(paint(colors.added, fmt.Sprintf("+%d:  %s", match.LineNumber, match.Replacement)))
+}
+
+// printRemovedMatches shows the matches of one file that disappeared in --watch
+// mode, prefixed with "-" (and red when colored) like the old side of a replacement.
+func printRemovedMatches(filePath string, matches []Match) {
+	var lineNumber int
+
+	fmt.Printf("\n%s:\n", paint(colors.path, filePath))
+	for _, match := range matches {
+		lineNumber = match.LineNumber
+		for line := range strings.SplitSeq(match.Line, "\n") {
+			fmt.Println(paint(colors.removed, fmt.Sprintf("-%d:  %s", lineNumber, line)))
+			lineNumber++
+		}
+	}
+}
+
+// printReplaceSummary prints how many replacements were made in one file.
+func printReplaceSummary(match Match) {
+	var action string
+
+	if match.Written {
+		action = " written"
+	}
+	fmt.Printf("\n%s: %d replacements%s\n", paint(colors.path, match.FilePath), match.Replacements, action)
+}
+
+// printReplaceTotal prints the total replacements across all files at the end
+// of a --replace run, reminding the user when nothing was written.
+func printReplaceTotal(files, replacements int, written bool) {
+	if written {
+		fmt.Printf("\n%d replacements written in %d files\n", replacements, files)
+		return
+	}
+	fmt.Printf("\n%d replacements in %d files (nothing written, use --write to apply)\n", replacements, files)
+}
+
 // outputHandler is responsible for receiving match results and printing them.
 // It runs in its own goroutine and provides a centralized place for output
 // formatting, which prevents garbled output from concurrent goroutines.
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Skip reasons specific to replace mode. Non-UTF-8 files are left alone because
+// writing transcoded content back would silently change their encoding.
+const (
+	skipNotUTF8        = "not UTF-8, not rewritten"
+	skipAlreadyWritten = "same file already rewritten through another path"
+)
+
+// replaceFile applies --replace to one file. When previewing, every changed line
+// is sent to the output handler as a diff, and in all modes a per-file summary
+// follows. With --write the new content replaces the file atomically. Binary and
+// non-UTF-8 files are never modified.
+func (ds *DirSearch) replaceFile(ctx context.Context, filePath, displayPath string, stat os.FileInfo) (err error) {
+	var data []byte
+	var replaced []byte
+	var count int
+	var target string
+
+	// With -L the same file can be reached through several paths; rewriting it
+	// from each of them concurrently could apply the replacement twice
+	if ds.opts.Write && !ds.firstVisit(filePath) {
+		ds.recordSkip(filePath, skipAlreadyWritten)
+		goto end
+	}
+
+	data, err = os.ReadFile(filePath)
+	if err != nil {
+		if ds.verbose {
+			fmt.Printf("[TRACE] Cannot read file %s: %v\n", filePath, err)
+		}
+		err = nil
+		goto end
+	}
+
+	replaced, count, err = ds.replaceContent(ctx, displayPath, data)
+	if err != nil || count == 0 {
+		goto end
+	}
+
+	if ds.opts.Write {
+		// Write through symlinks instead of replacing the link with a regular file
+		target, err = filepath.EvalSymlinks(filePath)
+		if err != nil {
+			goto end
+		}
+		err = writeFileAtomic(target, replaced, stat, ds.opts.PreserveMtime)
+		if err != nil {
+			err = fmt.Errorf("writing %s: %w", displayPath, err)
+			goto end
+		}
+	}
+
+	err = ds.sendReplaceSummary(ctx, displayPath, count)
+
+end:
+	return err
+}
+
+// replaceStdin previews replacements on standard input. It can't be written back.
+func (ds *DirSearch) replaceStdin(ctx context.Context) (err error) {
+	var data []byte
+	var count int
+
+	data, err = io.ReadAll(os.Stdin)
+	if err != nil {
+		goto end
+	}
+	_, count, err = ds.replaceContent(ctx, stdinName, data)
+	if err != nil || count == 0 {
+		goto end
+	}
+	err = ds.sendReplaceSummary(ctx, stdinName, count)
+
+end:
+	return err
+}
+
+// replaceContent runs the pattern over each line of data and expands the
+// --replace template ($1, ${name}) for every match, returning the new content
+// and the number of replacements. Line terminators are kept exactly as they
+// were. When only previewing (neither --dry-run nor --write), each changed line
+// is sent to the output handler so a diff can be shown.
+func (ds *DirSearch) replaceContent(ctx context.Context, displayPath string, data []byte) (result []byte, count int, err error) {
+	var sample []byte
+	var lineNum int
+	var rest []byte
+
+	sample = data[:min(len(data), 512)]
+	if !isLikelyText(sample) || bytes.IndexByte(data, 0) >= 0 {
+		ds.recordSkip(displayPath, skipBinary)
+		goto end
+	}
+	if enc, _ := detectEncoding(sample, ds.opts.Encoding); enc != EncodingUTF8 {
+		ds.recordSkip(displayPath, skipNotUTF8)
+		goto end
+	}
+
+	result = make([]byte, 0, len(data))
+	rest = data
+	for len(rest) > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			goto end
+		default:
+		}
+
+		lineNum++
+		line, terminator := splitLine(rest)
+		rest = rest[len(line)+len(terminator):]
+
+		newLine, replacements, findErr := replaceAllTemplate(ds.pattern, line, ds.opts.Replace)
+		if findErr != nil {
+			err = fmt.Errorf("%s: line %d: %w", displayPath, lineNum, findErr)
+			goto end
+		}
+		if replacements == 0 {
+			result = append(result, line...)
+			result = append(result, terminator...)
+			continue
+		}
+
+		count += replacements
+		result = append(result, newLine...)
+		result = append(result, terminator...)
+
+		if ds.opts.DryRun || ds.opts.Write {
+			continue
+		}
+		err = ds.sendReplacement(ctx, displayPath, lineNum, line, newLine)
+		if err != nil {
+			goto end
+		}
+	}
+
+end:
+	return result, count, err
+}
+
+// splitLine returns the first line of data without its terminator, and the
+// terminator itself ("\n", "\r\n" or empty at end of input).
+func splitLine(data []byte) (line string, terminator string) {
+	var i int
+
+	i = bytes.IndexByte(data, '\n')
+	if i < 0 {
+		line = string(data)
+		goto end
+	}
+	terminator = "\n"
+	if i > 0 && data[i-1] == '\r' {
+		i--
+		terminator = "\r\n"
+	}
+	line = string(data[:i])
+
+end:
+	return line, terminator
+}
+
+// sendReplacement sends one changed line to the output handler for the diff preview.
+func (ds *DirSearch) sendReplacement(ctx context.Context, displayPath string, lineNum int, line, newLine string) (err error) {
+	select {
+	case ds.matchChan <- Match{FilePath: displayPath, LineNumber: lineNum, Line: line, Replacement: newLine, Replaced: true, IsMatch: true}:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return err
+}
+
+// sendReplaceSummary sends the per-file replacement count to the output handler.
+// It travels as a Match with IsMatch unset since it doesn't describe a line.
+func (ds *DirSearch) sendReplaceSummary(ctx context.Context, displayPath string, count int) (err error) {
+	select {
+	case ds.matchChan <- Match{FilePath: displayPath, Replacements: count, Written: ds.opts.Write}:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return err
+}
+
+// writeFileAtomic replaces path with data so readers never see a partly written
+// file: the data goes to a temporary file in the same directory, which then
+// takes the original's permissions (and optionally its modification time) and
+// is renamed over it.
+func writeFileAtomic(path string, data []byte, original os.FileInfo, preserveMtime bool) (err error) {
+	var tmp *os.File
+
+	tmp, err = os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".search-*")
+	if err != nil {
+		goto end
+	}
+	defer func() {
+		if err != nil {
+			// Don't leave the temporary file behind on failure
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		goto end
+	}
+	err = tmp.Sync()
+	if err != nil {
+		goto end
+	}
+	err = tmp.Chmod(original.Mode().Perm())
+	if err != nil {
+		goto end
+	}
+	err = tmp.Close()
+	if err != nil {
+		goto end
+	}
+
+	if preserveMtime {
+		// A zero access time leaves it unchanged
+		err = os.Chtimes(tmp.Name(), time.Time{}, original.ModTime())
+		if err != nil {
+			goto end
+		}
+	}
+
+	err = os.Rename(tmp.Name(), path)
+
+end:
+	return err
+}
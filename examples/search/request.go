@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// newRequestSearch sets up the DirSearch for a search that a client of serve or
+// lsp asked for, starting from the server's options with opts.PCRE set from the
+// request. Each path is turned into one to search by resolve, which may reject
+// it; directories are searched for files matching any of globs (all files if
+// none are given), other paths as single files.
+//
+// Requests may only use the backtracking engine when the server was started with
+// --allow-pcre. It is off by default because any client could otherwise send
+// patterns that backtrack for the whole step budget on every line.
+func newRequestSearch(opts Options, allowPCRE bool, expr string, globs []string, paths []string, resolve func(string) (string, error)) (ds *DirSearch, err error) {
+	var pattern Matcher
+	var roots []Root
+	var files []string
+	var path string
+	var stat os.FileInfo
+
+	switch {
+	case expr == "":
+		err = fmt.Errorf("pattern is required")
+	case opts.PCRE && !allowPCRE:
+		err = fmt.Errorf("pcre is not enabled on this server (start it with --allow-pcre)")
+	}
+	if err != nil {
+		goto end
+	}
+	pattern, err = compilePattern(expr, opts.PCRE)
+	if err != nil {
+		goto end
+	}
+
+	if len(globs) == 0 {
+		globs = []string{"*"}
+	}
+	for _, glob := range globs {
+		if _, matchErr := filepath.Match(glob, ""); matchErr != nil || strings.ContainsAny(glob, `/\`) {
+			err = fmt.Errorf("invalid glob %q (want a file name pattern such as *.go)", glob)
+			goto end
+		}
+	}
+
+	for _, requested := range paths {
+		path, err = resolve(requested)
+		if err != nil {
+			goto end
+		}
+		stat, err = os.Stat(path)
+		if err != nil {
+			goto end
+		}
+		if !stat.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		for _, glob := range globs {
+			roots = append(roots, Root{Dir: path, Glob: glob})
+		}
+	}
+
+	ds = NewDirSearch(roots, pattern, opts)
+	ds.AddFiles(files...)
+
+end:
+	return ds, err
+}
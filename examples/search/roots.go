@@ -0,0 +1,157 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Root is one directory tree to search. A DirSearch can search several roots
+// in a single run, sharing its worker pool and output handler between them.
+type Root struct {
+	Dir     string // Directory to walk, with ~ already expanded
+	Glob    string // Pattern matched against file names; "*" matches all files
+	Display string // How Dir is shown in output, usually as the user typed it; defaults to Dir
+
+	dev    uint64     // Device Dir is on, set when searching with OneFileSystem
+	hasDev bool       // Whether dev could be determined
+	index  *rootIndex // Trigram index narrowing the files to search (--index), if one exists
+	inner  []Root     // Other roots that search some of this root's files instead (see dedupeRoots)
+}
+
+// dedupeRoots removes roots that would only repeat work done by another root:
+// exact duplicates, and roots nested inside another root whose glob already
+// selects the same files (the outer glob is "*" or identical). Depth limits are
+// counted from each root, so with a maxDepth the outer walk may stop above
+// files a nested root reaches, and the nested root is kept.
+//
+// Roots that overlap in other ways are all searched, but each file only by one
+// of them: the deepest root that selects it, the first given among equals. The
+// others list that root in inner and leave the file to it.
+func dedupeRoots(roots []Root, maxDepth int) (result []Root) {
+	var absDirs []string
+	var kept []int
+
+	absDirs = make([]string, len(roots))
+	for i, root := range roots {
+		absDirs[i] = absPath(root.Dir)
+	}
+
+	for i, root := range roots {
+		covered := false
+		for j, other := range roots {
+			if i == j || (other.Glob != "*" && other.Glob != root.Glob) {
+				continue
+			}
+			if !isWithin(absDirs[i], absDirs[j]) || (absDirs[i] != absDirs[j] && maxDepth > 0) {
+				continue
+			}
+			// Of two identical roots keep the first one
+			if absDirs[i] == absDirs[j] && other.Glob == root.Glob && j > i {
+				continue
+			}
+			covered = true
+			break
+		}
+		if !covered {
+			kept = append(kept, i)
+		}
+	}
+
+	for _, i := range kept {
+		root := roots[i]
+		root.inner = nil
+		for _, j := range kept {
+			nested := absDirs[j] != absDirs[i] && isWithin(absDirs[j], absDirs[i])
+			if nested || (absDirs[j] == absDirs[i] && j < i) {
+				root.inner = append(root.inner, Root{Dir: absDirs[j], Glob: roots[j].Glob})
+			}
+		}
+		result = append(result, root)
+	}
+	return result
+}
+
+// selects reports whether walking root would search filePath, judging by its
+// path alone: it must be below root.Dir but not in a skipped directory, within
+// the depth limits counted from root.Dir, and match the glob.
+func (root Root) selects(filePath string, minDepth, maxDepth int) (selected bool) {
+	var abs string
+	var dir string
+	var rel string
+	var parts []string
+	var err error
+
+	abs, dir = absPath(filePath), absPath(root.Dir)
+	if abs == dir || !isWithin(abs, dir) {
+		goto end
+	}
+	rel, err = filepath.Rel(dir, abs)
+	if err != nil {
+		goto end
+	}
+	parts = strings.Split(rel, string(filepath.Separator))
+	if slices.ContainsFunc(parts[:len(parts)-1], shouldSkipDirectory) {
+		goto end
+	}
+	if depth := len(parts); depth < minDepth || (maxDepth > 0 && depth > maxDepth) {
+		goto end
+	}
+	selected, _ = filepath.Match(root.Glob, filepath.Base(filePath))
+
+end:
+	return selected
+}
+
+// leaves reports whether a file root selects is searched by one of its inner
+// roots instead.
+func (root Root) leaves(filePath string, minDepth, maxDepth int) bool {
+	return slices.ContainsFunc(root.inner, func(inner Root) bool { return inner.selects(filePath, minDepth, maxDepth) })
+}
+
+// displayPath returns how a file found under the root is shown in output: relative
+// to the root as the user gave it, or absolute when requested.
+func (root Root) displayPath(filePath string, absolute bool) (display string) {
+	var rel string
+	var err error
+
+	if absolute {
+		display = absPath(filePath)
+		goto end
+	}
+
+	display = filePath
+	if root.Display == "" || root.Display == root.Dir {
+		goto end
+	}
+	rel, err = filepath.Rel(root.Dir, filePath)
+	if err != nil {
+		goto end
+	}
+	display = filepath.Join(root.Display, rel)
+
+end:
+	return display
+}
+
+// absPath returns the absolute, cleaned form of path, or path itself if the
+// working directory can't be determined.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return abs
+}
+
+// isWithin reports whether path is dir itself or somewhere below it.
+// Both paths must be absolute and clean.
+func isWithin(path, dir string) bool {
+	if path == dir {
+		return true
+	}
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// searchedFiles runs a search for "needle" and returns the displayed paths of
+// the files it matched in, sorted, failing if one was reported twice.
+func searchedFiles(t *testing.T, roots []Root, files []string, opts Options) (paths []string) {
+	ds := NewDirSearch(roots, regexp.MustCompile("needle"), opts)
+	ds.AddFiles(files...)
+	ds.SetOutput(func(match Match) error {
+		if slices.Contains(paths, match.FilePath) {
+			t.Errorf("%s reported twice", match.FilePath)
+		}
+		paths = append(paths, match.FilePath)
+		return nil
+	})
+	if err := ds.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func TestNestedRoots(t *testing.T) {
+	t.Chdir(writeTree(t, map[string]string{
+		"top.txt":              "needle\n",
+		"vendor/c.go":          "needle\n",
+		"vendor/lib/a.txt":     "needle\n",
+		"vendor/lib/deep/b.go": "needle\n",
+	}))
+	root := func(dir, glob string) Root { return Root{Dir: dir, Glob: glob, Display: dir} }
+
+	tests := []struct {
+		name     string
+		roots    []Root
+		files    []string
+		maxDepth int
+		minDepth int
+		want     []string
+	}{
+		{
+			name:  "nested root without limits is covered",
+			roots: []Root{root(".", "*"), root("vendor/lib", "*")},
+			want:  []string{"top.txt", "vendor/c.go", "vendor/lib/a.txt", "vendor/lib/deep/b.go"},
+		},
+		{
+			// Each root counts depth from itself
+			name:     "nested root deeper than --max-depth",
+			roots:    []Root{root(".", "*"), root("vendor/lib", "*")},
+			maxDepth: 1,
+			want:     []string{"top.txt", "vendor/lib/a.txt"},
+		},
+		{
+			name:     "overlapping roots search shared files once",
+			roots:    []Root{root(".", "*"), root("vendor", "*")},
+			maxDepth: 2,
+			want:     []string{"top.txt", "vendor/c.go", "vendor/lib/a.txt"},
+		},
+		{
+			name:     "inner root listed first",
+			roots:    []Root{root("vendor", "*"), root(".", "*")},
+			maxDepth: 2,
+			want:     []string{"top.txt", "vendor/c.go", "vendor/lib/a.txt"},
+		},
+		{
+			name:     "minimum depth",
+			roots:    []Root{root(".", "*"), root("vendor/lib", "*")},
+			minDepth: 3,
+			want:     []string{"vendor/lib/a.txt", "vendor/lib/deep/b.go"},
+		},
+		{
+			name:  "outer glob narrower than the nested root's",
+			roots: []Root{root(".", "*.go"), root("vendor", "*")},
+			want:  []string{"vendor/c.go", "vendor/lib/a.txt", "vendor/lib/deep/b.go"},
+		},
+		{
+			name:  "same directory with different globs",
+			roots: []Root{root("vendor", "*.go"), root("vendor", "c.*")},
+			want:  []string{"vendor/c.go", "vendor/lib/deep/b.go"},
+		},
+		{
+			name:     "explicit file below --max-depth",
+			roots:    []Root{root(".", "*")},
+			files:    []string{"vendor/lib/deep/b.go", "top.txt"},
+			maxDepth: 1,
+			want:     []string{"top.txt", "vendor/lib/deep/b.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.MaxDepth, opts.MinDepth = tt.maxDepth, tt.minDepth
+			got := searchedFiles(t, tt.roots, tt.files, opts)
+			for i := range got {
+				got[i] = filepath.ToSlash(got[i])
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("searched %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDedupeRoots(t *testing.T) {
+	roots := []Root{{Dir: "a", Glob: "*"}, {Dir: "a/b", Glob: "*"}, {Dir: "a", Glob: "*"}, {Dir: "a/c", Glob: "*.go"}, {Dir: "d", Glob: "*.go"}}
+
+	if got := dedupeRoots(roots, 0); len(got) != 2 || got[0].Dir != "a" || got[1].Dir != "d" || len(got[0].inner) != 0 {
+		t.Errorf("without a depth limit: %+v, want a and d", got)
+	}
+	got := dedupeRoots(roots, 2)
+	if len(got) != 4 || got[0].Dir != "a" || len(got[0].inner) != 2 || got[1].Dir != "a/b" || len(got[1].inner) != 0 {
+		t.Errorf("with a depth limit: %+v, want a with inner a/b and a/c, then a/b, a/c and d", got)
+	}
+}
+
+// TestExplicitFilesUnderRoots checks that files given explicitly are searched
+// even where walking a root that contains them would skip them, and only once
+// where it wouldn't.
+func TestExplicitFilesUnderRoots(t *testing.T) {
+	t.Chdir(writeTree(t, map[string]string{
+		"d/a.txt":          "needle\n",
+		"d/tiny.txt":       "needle\n",
+		"out/target.txt":   "needle\n",
+		"d/big/needle.txt": "needle " + strings.Repeat("x", 2000) + "\n",
+	}))
+	if err := os.Symlink(filepath.Join("..", "out", "target.txt"), filepath.Join("d", "link.txt")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	root := Root{Dir: "d", Glob: "*", Display: "d"}
+
+	for _, follow := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.FollowSymlinks = follow
+		opts.MinSize = 1000
+		got := searchedFiles(t, []Root{root}, []string{"d/link.txt", "d/tiny.txt", "d/big/needle.txt"}, opts)
+		for i := range got {
+			got[i] = filepath.ToSlash(got[i])
+		}
+		want := []string{"d/big/needle.txt", "d/link.txt", "d/tiny.txt"}
+		if !slices.Equal(got, want) {
+			t.Errorf("with FollowSymlinks %v: searched %q, want %q", follow, got, want)
+		}
+	}
+}
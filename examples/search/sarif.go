@@ -0,0 +1,231 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"io"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// SARIF 2.1.0 identifiers written at the top of every report.
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// The parts of the SARIF 2.1.0 object model a report uses. Field names follow
+// the specification; optional members are omitted when empty.
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+
+	sarifRun struct {
+		Tool       sarifTool     `json:"tool"`
+		ColumnKind string        `json:"columnKind"`
+		Results    []sarifResult `json:"results"`
+	}
+
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+
+	sarifDriver struct {
+		Name  string      `json:"name"`
+		Rules []sarifRule `json:"rules"`
+	}
+
+	sarifRule struct {
+		ID                   string              `json:"id"`
+		ShortDescription     sarifMessage        `json:"shortDescription"`
+		DefaultConfiguration *sarifConfiguration `json:"defaultConfiguration,omitempty"`
+	}
+
+	sarifConfiguration struct {
+		Level string `json:"level"`
+	}
+
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		RuleIndex int             `json:"ruleIndex"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations"`
+	}
+
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           *sarifRegion          `json:"region,omitempty"`
+		ContextRegion    *sarifRegion          `json:"contextRegion,omitempty"`
+	}
+
+	sarifArtifactLocation struct {
+		URI string `json:"uri"`
+	}
+
+	sarifRegion struct {
+		StartLine   int           `json:"startLine"`
+		StartColumn int           `json:"startColumn,omitempty"`
+		EndLine     int           `json:"endLine,omitempty"`
+		EndColumn   int           `json:"endColumn,omitempty"`
+		Snippet     *sarifSnippet `json:"snippet,omitempty"`
+	}
+
+	sarifSnippet struct {
+		Text string `json:"text"`
+	}
+)
+
+// sarifReport collects matches as SARIF results, to be written as one document
+// once the search is done. Like any output function its methods are only called
+// from the output handler goroutine, so it needs no locking.
+type sarifReport struct {
+	rules   []sarifRule
+	results []sarifResult
+}
+
+// addRule declares a rule results can refer to by its ID. level is the SARIF
+// level of its results: "error", "warning" or "note".
+func (r *sarifReport) addRule(id, description, level string) {
+	r.rules = append(r.rules, sarifRule{
+		ID:                   id,
+		ShortDescription:     sarifMessage{Text: description},
+		DefaultConfiguration: &sarifConfiguration{Level: level},
+	})
+}
+
+// add records a match as a result of the rule with the given ID. A rule that
+// wasn't added is added as a warning described by its ID. The region covers the
+// first match on the line (or the lines, in multiline mode) and the context
+// region the lines around it. Summaries and other non-matches are ignored.
+func (r *sarifReport) add(ruleID, message string, match Match) {
+	var index int
+	var location sarifPhysicalLocation
+	var context string
+
+	if !match.IsMatch {
+		return
+	}
+	index = slices.IndexFunc(r.rules, func(rule sarifRule) bool { return rule.ID == ruleID })
+	if index < 0 {
+		index = len(r.rules)
+		r.addRule(ruleID, ruleID, "warning")
+	}
+
+	location.ArtifactLocation.URI = sarifURI(match.FilePath)
+	if !match.Binary {
+		location.Region = &sarifRegion{StartLine: match.LineNumber, Snippet: &sarifSnippet{Text: match.Line}}
+		if len(match.Spans) > 0 {
+			location.Region.StartLine, location.Region.StartColumn = sarifPosition(match, match.Spans[0][0])
+			location.Region.EndLine, location.Region.EndColumn = sarifPosition(match, match.Spans[0][1])
+		}
+		if match.Before != "" || match.After != "" {
+			location.ContextRegion = &sarifRegion{StartLine: match.LineNumber, EndLine: max(match.EndLine, match.LineNumber)}
+			context = match.Line
+			if match.Before != "" {
+				location.ContextRegion.StartLine--
+				context = match.Before + "\n" + context
+			}
+			if match.After != "" {
+				location.ContextRegion.EndLine++
+				context += "\n" + match.After
+			}
+			location.ContextRegion.Snippet = &sarifSnippet{Text: context}
+		}
+	}
+
+	r.results = append(r.results, sarifResult{
+		RuleID:    ruleID,
+		RuleIndex: index,
+		Level:     r.rules[index].DefaultConfiguration.Level,
+		Message:   sarifMessage{Text: message},
+		Locations: []sarifLocation{{PhysicalLocation: location}},
+	})
+}
+
+// write writes the report as indented JSON. Results are sorted by file and
+// position, so the same tree always gives the same document regardless of the
+// order the concurrent search found them in.
+func (r *sarifReport) write(w io.Writer) (err error) {
+	var log sarifLog
+	var encoder *json.Encoder
+
+	slices.SortStableFunc(r.results, func(a, b sarifResult) int {
+		pa, pb := a.Locations[0].PhysicalLocation, b.Locations[0].PhysicalLocation
+		if c := strings.Compare(pa.ArtifactLocation.URI, pb.ArtifactLocation.URI); c != 0 {
+			return c
+		}
+		if pa.Region == nil || pb.Region == nil {
+			return cmp.Compare(btoi(pa.Region != nil), btoi(pb.Region != nil))
+		}
+		return cmp.Or(
+			cmp.Compare(pa.Region.StartLine, pb.Region.StartLine),
+			cmp.Compare(pa.Region.StartColumn, pb.Region.StartColumn),
+			cmp.Compare(a.RuleIndex, b.RuleIndex))
+	})
+
+	log = sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs: []sarifRun{{
+			Tool:       sarifTool{Driver: sarifDriver{Name: "search", Rules: r.rules}},
+			ColumnKind: "unicodeCodePoints",
+			Results:    r.results,
+		}},
+	}
+	if log.Runs[0].Results == nil {
+		// An empty run still needs its results array
+		log.Runs[0].Results = []sarifResult{}
+	}
+
+	encoder = json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(log)
+	return err
+}
+
+// sarifPosition converts a byte offset within match.Line to a 1-based line and
+// column, counting columns in code points as the report declares.
+func sarifPosition(match Match, offset int) (line, column int) {
+	var lineStart int
+
+	lineStart = strings.LastIndexByte(match.Line[:offset], '\n') + 1
+	line = match.LineNumber + strings.Count(match.Line[:lineStart], "\n")
+	column = utf8.RuneCountInString(match.Line[lineStart:offset]) + 1
+	return line, column
+}
+
+// sarifURI returns the artifact URI of a displayed path: a file URI if it is
+// absolute and otherwise a relative reference, which code scanning services
+// resolve against the checkout. Files inside archives are reported as the
+// archive.
+func sarifURI(path string) string {
+	path, _, _ = strings.Cut(path, "!/")
+	if filepath.IsAbs(path) {
+		return fileURL("", path).String()
+	}
+	return (&url.URL{Path: filepath.ToSlash(path)}).String()
+}
+
+// btoi returns 1 for true and 0 for false.
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestSARIFGolden compares reports with testdata/*.sarif. Run the tests with
+// -update to rewrite the files after an intended change.
+func TestSARIFGolden(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   [][3]string // ID, description, level
+		matches []Match
+		ruleIDs []string // Rule of each match; the first rule if nil
+	}{
+		{
+			name:  "empty",
+			rules: [][3]string{{"needle", "Matches of the pattern needle", "warning"}},
+		},
+		{
+			name:  "context",
+			rules: [][3]string{{"needle", "Matches of the pattern needle", "warning"}},
+			matches: []Match{
+				// Added out of order, as concurrent searches find them
+				{FilePath: "b.txt", LineNumber: 1, EndLine: 1, Line: "needle", After: "after", Spans: [][2]int{{0, 6}}, IsMatch: true},
+				{FilePath: "a.txt", LineNumber: 7, EndLine: 7, Line: "a needle", Before: "before", After: "after", Spans: [][2]int{{2, 8}}, IsMatch: true},
+				{FilePath: "a.txt", LineNumber: 3, EndLine: 3, Line: "needle here", Before: "before", Spans: [][2]int{{0, 6}}, IsMatch: true},
+				{FilePath: "a.txt", LineNumber: 3, Replacements: 2},
+			},
+		},
+		{
+			name:  "multibyte",
+			rules: [][3]string{{"needle", "Matches of the pattern needle", "error"}},
+			matches: []Match{
+				{FilePath: "unicode/é.txt", LineNumber: 2, EndLine: 2, Line: "héllo 😀 needle", Spans: [][2]int{{12, 18}}, IsMatch: true},
+				{FilePath: "dir with space/a#b.txt", LineNumber: 1, EndLine: 1, Line: "\tneedle", Spans: [][2]int{{1, 7}}, IsMatch: true},
+			},
+		},
+		{
+			name:  "multiline",
+			rules: [][3]string{{`func\(\) \{\s*\}`, "Empty functions", "note"}},
+			matches: []Match{
+				{FilePath: "main.go", LineNumber: 3, EndLine: 5, Line: "var f = func() {\n\n}", Before: "package main", Spans: [][2]int{{8, 19}}, IsMatch: true},
+			},
+		},
+		{
+			name:  "archive",
+			rules: [][3]string{{"needle", "Matches of the pattern needle", "warning"}},
+			matches: []Match{
+				{FilePath: "logs/app.zip!/var/log/app.log", LineNumber: 4, EndLine: 4, Line: "needle", Spans: [][2]int{{0, 6}}, IsMatch: true},
+				{FilePath: "logs/old.tar.gz!/bin/tool", Binary: true, IsMatch: true},
+			},
+		},
+		{
+			name:  "undeclared",
+			rules: [][3]string{{"declared", "A declared rule", "error"}},
+			matches: []Match{
+				{FilePath: "a.txt", LineNumber: 1, EndLine: 1, Line: "x", Spans: [][2]int{{0, 1}}, IsMatch: true},
+				{FilePath: "a.txt", LineNumber: 2, EndLine: 2, Line: "y", Spans: [][2]int{{0, 1}}, IsMatch: true},
+			},
+			ruleIDs: []string{"declared", "undeclared"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var report sarifReport
+			var got bytes.Buffer
+
+			for _, rule := range tt.rules {
+				report.addRule(rule[0], rule[1], rule[2])
+			}
+			for i, match := range tt.matches {
+				ruleID := tt.rules[0][0]
+				if tt.ruleIDs != nil {
+					ruleID = tt.ruleIDs[i]
+				}
+				report.add(ruleID, "Match of "+ruleID, match)
+			}
+			if err := report.write(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", tt.name+".sarif")
+			if *updateGolden {
+				if err := os.WriteFile(golden, got.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("report differs from %s:\n%s", golden, got.Bytes())
+			}
+		})
+	}
+}
+
+func TestSARIFURI(t *testing.T) {
+	tests := []struct {
+		path string
+		uri  string
+	}{
+		{"a.txt", "a.txt"},
+		{"src/main.go", "src/main.go"},
+		{"dir with space/%.txt", "dir%20with%20space/%25.txt"},
+		{"a.zip!/inner/b.txt", "a.zip"},
+	}
+
+	for _, tt := range tests {
+		if uri := sarifURI(filepath.FromSlash(tt.path)); uri != tt.uri {
+			t.Errorf("sarifURI(%q) = %q, want %q", tt.path, uri, tt.uri)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of the serve subcommand.
+const (
+	defaultServeAddr     = "localhost:7070"
+	defaultServeRequests = 4
+	defaultServeTimeout  = 30 * time.Second
+	maxRequestBody       = 1 << 20
+	shutdownGrace        = 5 * time.Second
+)
+
+// errLimitReached stops a search once a request's limit on results was reached.
+var errLimitReached = errors.New("match limit reached")
+
+// serveConfig holds the options only the serve subcommand has.
+type serveConfig struct {
+	addr        string
+	maxRequests int
+	timeout     time.Duration
+	allowPCRE   bool // Let requests ask for the backtracking engine (--allow-pcre)
+}
+
+// server answers search requests against one directory tree. Each request gets
+// its own DirSearch, started from the options given on the command line.
+type server struct {
+	root     string        // Absolute directory that request paths are relative to
+	realRoot string        // root with symlinks resolved, to keep requests inside it
+	opts     Options       // Options every search starts from
+	limiter  chan struct{} // Semaphore bounding simultaneous searches
+	timeout  time.Duration // Longest a single search may run
+
+	allowPCRE bool // Requests may use the backtracking engine (see newRequestSearch)
+}
+
+// searchRequest is the JSON body of POST /search.
+type searchRequest struct {
+	Pattern     string   `json:"pattern"`
+	Paths       []string `json:"paths"`        // Relative to the served directory; all of it if empty
+	Globs       []string `json:"globs"`        // File name patterns such as "*.go"; all files if empty
+	PCRE        bool     `json:"pcre"`         // Use the backtracking engine, like -P; needs --allow-pcre
+	Context     bool     `json:"context"`      // Include the lines before and after each match
+	MaxMatches  int      `json:"max_matches"`  // Stop after this many matches; 0 means no limit
+	MaxDepth    int      `json:"max_depth"`    // Like --max-depth; 0 means no limit
+	MaxFileSize string   `json:"max_filesize"` // Like --max-filesize, e.g. "10M"
+}
+
+// searchEvent is one line of NDJSON or the data of one Server-Sent Event. A
+// search sends a "match" event per match and always ends with an "end" event.
+type searchEvent struct {
+	Type      string   `json:"type"`
+	Path      string   `json:"path,omitempty"` // Relative to the served directory, with forward slashes
+	Line      int      `json:"line,omitempty"`
+	EndLine   int      `json:"end_line,omitempty"`
+	Text      string   `json:"text,omitempty"`
+	Before    string   `json:"before,omitempty"`
+	After     string   `json:"after,omitempty"`
+	Spans     [][2]int `json:"spans,omitempty"` // Byte offsets of each match within text
+	Binary    bool     `json:"binary,omitempty"`
+	Enclosing string   `json:"enclosing,omitempty"`
+
+	// Set on the end event
+	Matches   int    `json:"matches,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"` // Stopped by max_matches or the timeout
+	Error     string `json:"error,omitempty"`
+}
+
+// eventStream writes events to a response as they happen, either as NDJSON or,
+// when the client asks for text/event-stream, as Server-Sent Events.
+type eventStream struct {
+	w          http.ResponseWriter
+	controller *http.ResponseController
+	sse        bool
+}
+
+// runServe implements `search serve`: an HTTP server searching one directory.
+//
+//	search serve [--addr=HOST:PORT] [--max-requests=N] [--timeout=DURATION] [--allow-pcre] [options] <dir>
+//
+// POST /search takes a searchRequest and streams back searchEvents.
+func runServe(args []string) (err error) {
+	var config serveConfig
+	var opts Options
+	var rest []string
+	var dirs []string
+	var s *server
+	var srv *http.Server
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var mux *http.ServeMux
+
+	config = serveConfig{addr: defaultServeAddr, maxRequests: defaultServeRequests, timeout: defaultServeTimeout}
+	rest, err = parseServeOptions(args, &config)
+	if err != nil {
+		goto end
+	}
+	opts = DefaultOptions()
+	dirs, err = parseOptions(rest, &opts)
+	if err != nil {
+		goto end
+	}
+	if len(dirs) != 1 {
+		err = fmt.Errorf("usage: %s serve [--addr=HOST:PORT] [--max-requests=N] [--timeout=DURATION] [--allow-pcre] [-v] [--index] [--max-filesize=SIZE] [--binary=skip|text|without-match] [--encoding=ENC] <dir>", os.Args[0])
+		goto end
+	}
+	if opts.Replacing || opts.Watch || opts.Tail {
+		err = fmt.Errorf("serve only searches and cannot be combined with --replace, --watch or --tail")
+		goto end
+	}
+	if opts.FollowSymlinks {
+		// Request paths are checked after resolving symlinks, but the walk below
+		// them isn't, so following links could reach files outside the directory
+		err = fmt.Errorf("serve cannot follow symlinks (-L), which could lead outside the served directory")
+		goto end
+	}
+
+	s, err = newServer(dirs[0], opts, config)
+	if err != nil {
+		goto end
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	err = setupSignalHandler(cancel)
+	if err != nil {
+		goto end
+	}
+
+	mux = http.NewServeMux()
+	mux.HandleFunc("POST /search", s.handleSearch)
+	srv = &http.Server{
+		Addr:              config.addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		// Requests inherit ctx, so stopping the server also stops running searches
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownGrace)
+		defer shutdownCancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Serving searches of %s on http://%s/search", s.root, config.addr)
+	err = srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+end:
+	return err
+}
+
+// parseServeOptions takes the serve subcommand's own options out of args and
+// returns the rest, which are parsed like those of a search.
+func parseServeOptions(args []string, config *serveConfig) (rest []string, err error) {
+	var name string
+	var value string
+	var hasValue bool
+
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue = strings.Cut(args[i], "=")
+		switch name {
+		case "--":
+			rest = append(rest, args[i:]...)
+			goto end
+		case "--addr":
+			config.addr, err = optionValue(args, &i, name, value, hasValue)
+		case "--max-requests":
+			value, err = optionValue(args, &i, name, value, hasValue)
+			if err == nil {
+				config.maxRequests, err = strconv.Atoi(value)
+				if err != nil || config.maxRequests < 1 {
+					err = fmt.Errorf("invalid --max-requests %q (want a positive number)", value)
+				}
+			}
+		case "--timeout":
+			value, err = optionValue(args, &i, name, value, hasValue)
+			if err == nil {
+				config.timeout, err = time.ParseDuration(value)
+				if err != nil || config.timeout <= 0 {
+					err = fmt.Errorf("invalid --timeout %q (want a duration such as 30s)", value)
+				}
+			}
+		case "--allow-pcre":
+			config.allowPCRE = true
+		default:
+			rest = append(rest, args[i])
+		}
+		if err != nil {
+			goto end
+		}
+	}
+
+end:
+	return rest, err
+}
+
+// newServer prepares serving dir.
+func newServer(dir string, opts Options, config serveConfig) (s *server, err error) {
+	var stat os.FileInfo
+
+	dir, err = expandTilde(dir)
+	if err != nil {
+		goto end
+	}
+	stat, err = os.Stat(dir)
+	if err != nil {
+		goto end
+	}
+	if !stat.IsDir() {
+		err = fmt.Errorf("%s is not a directory", dir)
+		goto end
+	}
+
+	s = &server{
+		root:      absPath(dir),
+		opts:      opts,
+		limiter:   make(chan struct{}, config.maxRequests),
+		timeout:   config.timeout,
+		allowPCRE: config.allowPCRE,
+	}
+	s.realRoot, err = filepath.EvalSymlinks(s.root)
+
+end:
+	return s, err
+}
+
+// handleSearch runs one search and streams its matches. The search is cancelled
+// when the client goes away or the timeout passes; requests beyond the
+// concurrency limit are turned away with 503 rather than queued.
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var req searchRequest
+	var decoder *json.Decoder
+	var ds *DirSearch
+	var stream *eventStream
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var matches int
+	var started time.Time
+	var end searchEvent
+	var err error
+
+	select {
+	case s.limiter <- struct{}{}:
+		defer func() { <-s.limiter }()
+	default:
+		w.Header().Set("Retry-After", "1")
+		writeJSONError(w, http.StatusServiceUnavailable, "too many searches in progress")
+		return
+	}
+
+	decoder = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	ds, err = s.newSearch(&req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel = context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+
+	stream = newEventStream(w, r)
+	ds.SetOutput(func(match Match) (err error) {
+		err = stream.send(s.matchEvent(match, req.Context))
+		if err != nil {
+			return err
+		}
+		matches++
+		if req.MaxMatches > 0 && matches >= req.MaxMatches {
+			err = errLimitReached
+		}
+		return err
+	})
+
+	started = time.Now()
+	err = ds.Run(ctx)
+
+	end = searchEvent{Type: "end", Matches: matches}
+	switch {
+	case err == nil:
+	case errors.Is(err, errLimitReached):
+		end.Truncated = true
+	case r.Context().Err() != nil:
+		// The client is gone, so there is no one to tell
+		log.Printf("Search for %q cancelled by the client after %d matches", req.Pattern, matches)
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		end.Truncated, end.Error = true, fmt.Sprintf("search timed out after %v", s.timeout)
+	default:
+		end.Error = err.Error()
+	}
+	_ = stream.send(end)
+
+	if s.opts.Verbose {
+		log.Printf("Search for %q: %d matches in %v", req.Pattern, matches, time.Since(started).Round(time.Millisecond))
+	}
+}
+
+// newSearch validates a request and sets up its DirSearch. Paths must stay
+// inside the served directory, also after resolving symlinks.
+func (s *server) newSearch(req *searchRequest) (ds *DirSearch, err error) {
+	var opts Options
+	var paths []string
+
+	opts = s.opts
+	opts.PCRE = req.PCRE
+	switch {
+	case req.MaxMatches < 0:
+		err = fmt.Errorf("invalid max_matches %d (want a non-negative number)", req.MaxMatches)
+	case req.MaxDepth < 0:
+		err = fmt.Errorf("invalid max_depth %d (want a non-negative number)", req.MaxDepth)
+	}
+	if err != nil {
+		goto end
+	}
+	if req.MaxDepth > 0 {
+		opts.MaxDepth = req.MaxDepth
+	}
+	if req.MaxFileSize != "" {
+		opts.MaxFileSize, err = parseSize(req.MaxFileSize)
+		if err != nil {
+			goto end
+		}
+	}
+
+	paths = req.Paths
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	ds, err = newRequestSearch(opts, s.allowPCRE, req.Pattern, req.Globs, paths, func(path string) (string, error) {
+		full := filepath.Join(s.root, filepath.FromSlash(path))
+		resolved, resolveErr := filepath.EvalSymlinks(full)
+		if filepath.IsAbs(path) || resolveErr != nil || !isWithin(resolved, s.realRoot) {
+			return "", fmt.Errorf("path %q does not exist in the served directory", path)
+		}
+		return full, nil
+	})
+
+end:
+	return ds, err
+}
+
+// matchEvent converts a match into its event, with the path relative to the
+// served directory.
+func (s *server) matchEvent(match Match, withContext bool) (event searchEvent) {
+	var path string
+
+	path = match.FilePath
+	if rel, err := filepath.Rel(s.root, path); err == nil {
+		path = rel
+	}
+	event = searchEvent{
+		Type:      "match",
+		Path:      filepath.ToSlash(path),
+		Line:      match.LineNumber,
+		EndLine:   match.EndLine,
+		Text:      match.Line,
+		Spans:     match.Spans,
+		Binary:    match.Binary,
+		Enclosing: match.Enclosing,
+	}
+	if withContext {
+		event.Before, event.After = match.Before, match.After
+	}
+	return event
+}
+
+// newEventStream starts a streamed response in the format the client accepts.
+func newEventStream(w http.ResponseWriter, r *http.Request) (stream *eventStream) {
+	stream = &eventStream{
+		w:          w,
+		controller: http.NewResponseController(w),
+		sse:        strings.Contains(r.Header.Get("Accept"), "text/event-stream"),
+	}
+	if stream.sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	return stream
+}
+
+// send writes one event and flushes it to the client right away.
+func (stream *eventStream) send(event searchEvent) (err error) {
+	var data []byte
+
+	data, err = json.Marshal(event)
+	if err != nil {
+		goto end
+	}
+	if stream.sse {
+		_, err = fmt.Fprintf(stream.w, "event: %s\ndata: %s\n\n", event.Type, data)
+	} else {
+		_, err = stream.w.Write(append(data, '\n'))
+	}
+	if err != nil {
+		goto end
+	}
+	err = stream.controller.Flush()
+
+end:
+	return err
+}
+
+// writeJSONError answers a request that can't be searched with {"error": ...}.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer serves a small tree holding "needle" in a.txt and sub/b.go,
+// with a symlink "out" to a directory outside it that holds one too.
+func newTestServer(t *testing.T, config serveConfig) (s *server) {
+	var err error
+
+	dir := writeTree(t, map[string]string{
+		"root/a.txt":         "hay\nneedle\nhay\n",
+		"root/sub/b.go":      "package sub // needle\n",
+		"outside/secret.txt": "needle\n",
+	})
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside")
+	if err = os.Symlink(outside, filepath.Join(root, "out")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if config.maxRequests == 0 {
+		config.maxRequests = 1
+	}
+	if config.timeout == 0 {
+		config.timeout = time.Minute
+	}
+	s, err = newServer(root, DefaultOptions(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// postSearch sends body to the search handler and returns the response.
+func postSearch(s *server, body string, accept string) (rec *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(body))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec = httptest.NewRecorder()
+	s.handleSearch(rec, req)
+	return rec
+}
+
+// readNDJSON decodes every line of an NDJSON response.
+func readNDJSON(t *testing.T, rec *httptest.ResponseRecorder) (events []searchEvent) {
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("Content-Type = %q, want application/x-ndjson", ct)
+	}
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var event searchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %q is not JSON: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestServeNDJSON(t *testing.T) {
+	s := newTestServer(t, serveConfig{})
+
+	rec := postSearch(s, `{"pattern": "needle", "context": true}`, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	events := readNDJSON(t, rec)
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 2 matches and the end: %+v", len(events), events)
+	}
+	paths := []string{events[0].Path, events[1].Path}
+	if !(paths[0] == "a.txt" && paths[1] == "sub/b.go" || paths[0] == "sub/b.go" && paths[1] == "a.txt") {
+		t.Errorf("match paths = %q, want a.txt and sub/b.go, relative and without the symlinked tree", paths)
+	}
+	for _, event := range events[:2] {
+		if event.Type != "match" {
+			t.Errorf("event %+v, want a match", event)
+		}
+		if event.Path == "a.txt" && (event.Line != 2 || event.Before != "hay") {
+			t.Errorf("a.txt match = %+v, want line 2 with context", event)
+		}
+	}
+	if end := events[2]; end.Type != "end" || end.Matches != 2 || end.Truncated || end.Error != "" {
+		t.Errorf("end event = %+v, want 2 matches", end)
+	}
+}
+
+func TestServeSSE(t *testing.T) {
+	s := newTestServer(t, serveConfig{})
+
+	rec := postSearch(s, `{"pattern": "needle", "paths": ["a.txt"]}`, "text/event-stream")
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+	frames := strings.Split(rec.Body.String(), "\n\n")
+	if len(frames) != 3 || frames[2] != "" {
+		t.Fatalf("body %q, want two events each ending in a blank line", rec.Body)
+	}
+	for i, typ := range []string{"match", "end"} {
+		var event searchEvent
+		name, data, ok := strings.Cut(frames[i], "\n")
+		if !ok || name != "event: "+typ || !strings.HasPrefix(data, "data: ") {
+			t.Fatalf("frame %q, want event: %s and one data line", frames[i], typ)
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &event); err != nil || event.Type != typ {
+			t.Errorf("frame %q data = %+v, %v", frames[i], event, err)
+		}
+	}
+}
+
+func TestServeRejectsPathsOutsideRoot(t *testing.T) {
+	s := newTestServer(t, serveConfig{})
+
+	for _, path := range []string{"..", "../outside", "sub/../..", "/etc", "out", "out/secret.txt", "missing"} {
+		body, _ := json.Marshal(searchRequest{Pattern: "needle", Paths: []string{path}})
+		rec := postSearch(s, string(body), "")
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "does not exist in the served directory") {
+			t.Errorf("path %q: status %d %s, want 400", path, rec.Code, rec.Body)
+		}
+	}
+	for _, glob := range []string{"../*", "sub/*.go", "["} {
+		body, _ := json.Marshal(searchRequest{Pattern: "needle", Globs: []string{glob}})
+		if rec := postSearch(s, string(body), ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("glob %q: status %d, want 400", glob, rec.Code)
+		}
+	}
+
+	rec := postSearch(s, `{"pattern": "needle", "paths": ["sub"], "globs": ["*.go"]}`, "")
+	if events := readNDJSON(t, rec); len(events) != 2 || events[0].Path != "sub/b.go" {
+		t.Errorf("search of sub = %+v, want the match in sub/b.go", events)
+	}
+}
+
+// TestServeRejectsFollow checks that a server can't be started with -L, as the
+// out symlink would then lead the walk outside the served directory.
+func TestServeRejectsFollow(t *testing.T) {
+	s := newTestServer(t, serveConfig{})
+
+	for _, args := range [][]string{{"-L", s.root}, {"--follow", s.root}, {"--addr=localhost:0", s.root, "-L"}} {
+		if err := runServe(args); err == nil || !strings.Contains(err.Error(), "cannot follow symlinks") {
+			t.Errorf("runServe(%q) = %v, want a -L error", args, err)
+		}
+	}
+
+	// Without it the walk skips the symlink
+	rec := postSearch(s, `{"pattern": "needle"}`, "")
+	for _, event := range readNDJSON(t, rec) {
+		if strings.HasPrefix(event.Path, "out/") {
+			t.Errorf("search of the served directory returned %s", event.Path)
+		}
+	}
+}
+
+func TestServeBadRequests(t *testing.T) {
+	s := newTestServer(t, serveConfig{})
+
+	for _, body := range []string{
+		``, `{`, `{"pattern": ""}`, `{"pattern": "("}`, `{"pattern": "x", "unknown": 1}`,
+		`{"pattern": "x", "max_matches": -1}`, `{"pattern": "x", "max_filesize": "lots"}`,
+		`{"pattern": "(\\w)\\1", "pcre": true}`,
+	} {
+		rec := postSearch(s, body, "")
+		if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("body %q: status %d, want a 400 JSON error", body, rec.Code)
+		}
+	}
+}
+
+func TestServePCRE(t *testing.T) {
+	s := newTestServer(t, serveConfig{allowPCRE: true})
+
+	rec := postSearch(s, `{"pattern": "ne(?=edle)", "pcre": true, "paths": ["a.txt"]}`, "")
+	if events := readNDJSON(t, rec); len(events) != 2 || events[0].Spans[0] != [2]int{0, 2} {
+		t.Errorf("pcre search = %+v, want one lookahead match", events)
+	}
+
+	// Running out of backtracking steps ends the search with an error
+	if err := os.WriteFile(filepath.Join(s.root, "evil.txt"), []byte(strings.Repeat("a", 40)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rec = postSearch(s, `{"pattern": "(a|a)+b", "pcre": true, "paths": ["evil.txt"]}`, "")
+	events := readNDJSON(t, rec)
+	if end := events[len(events)-1]; end.Type != "end" || !strings.Contains(end.Error, "evil.txt: line 1: pattern needs too much backtracking") {
+		t.Errorf("end event = %+v, want the backtracking error", end)
+	}
+}
+
+func TestServeBusy(t *testing.T) {
+	s := newTestServer(t, serveConfig{maxRequests: 1})
+
+	s.limiter <- struct{}{}
+	rec := postSearch(s, `{"pattern": "needle"}`, "")
+	<-s.limiter
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("status %d, Retry-After %q; want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	rec = postSearch(s, `{"pattern": "needle"}`, "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d after the search finished, want 200", rec.Code)
+	}
+}
+
+func TestServeTimeout(t *testing.T) {
+	s := newTestServer(t, serveConfig{timeout: time.Nanosecond})
+
+	events := readNDJSON(t, postSearch(s, `{"pattern": "needle"}`, ""))
+	end := events[len(events)-1]
+	if end.Type != "end" || !end.Truncated || !strings.Contains(end.Error, "timed out") {
+		t.Errorf("end event = %+v, want a truncated search that timed out", end)
+	}
+}
+
+func TestServeMaxMatches(t *testing.T) {
+	s := newTestServer(t, serveConfig{})
+
+	events := readNDJSON(t, postSearch(s, `{"pattern": "needle", "max_matches": 1}`, ""))
+	if len(events) != 2 || events[1].Matches != 1 || !events[1].Truncated || events[1].Error != "" {
+		t.Errorf("events = %+v, want one match and a truncated end", events)
+	}
+}
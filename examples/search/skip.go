@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+)
+
+// Reasons recorded by recordSkip. They are fixed strings so Skipped can count them.
+const (
+	skipSymlink         = "symlink (use -L to follow)"
+	skipBrokenSymlink   = "broken symlink"
+	skipVisited         = "directory already visited (symlink loop)"
+	skipOtherFilesystem = "on another filesystem (--one-file-system)"
+	skipLarge           = "larger than --max-filesize"
+	skipBinary          = "binary file"
+	skipLongLine        = "line longer than 1MB"
+	skipNestedArchive   = "archive nested too deeply"
+	skipNamedPipe       = "named pipe"
+	skipSocket          = "socket"
+	skipDevice          = "device file"
+	skipIrregular       = "not a regular file"
+)
+
+// specialFileReason returns the skip reason for anything that isn't a regular
+// file or directory, or "" if mode is one of those. Reading FIFOs can block
+// forever and devices can be endless, so they are never searched while walking.
+func specialFileReason(mode os.FileMode) (reason string) {
+	switch {
+	case mode.IsRegular(), mode.IsDir():
+	case mode&os.ModeNamedPipe != 0:
+		reason = skipNamedPipe
+	case mode&os.ModeSocket != 0:
+		reason = skipSocket
+	case mode&(os.ModeDevice|os.ModeCharDevice) != 0:
+		reason = skipDevice
+	default:
+		reason = skipIrregular
+	}
+	return reason
+}
+
+// recordSkip notes that path was not searched and why. Each skip is traced in
+// verbose mode and counted per reason; see Skipped.
+func (ds *DirSearch) recordSkip(path, reason string) {
+	if ds.verbose {
+		fmt.Printf("[TRACE] Skipping %s: %s\n", path, reason)
+	}
+	ds.skippedMu.Lock()
+	ds.skipped[reason]++
+	ds.skippedMu.Unlock()
+}
+
+// Skipped returns how many files and directories were skipped for each reason
+// during Run, keyed by a short human-readable description.
+func (ds *DirSearch) Skipped() map[string]int {
+	ds.skippedMu.Lock()
+	defer ds.skippedMu.Unlock()
+	return maps.Clone(ds.skipped)
+}
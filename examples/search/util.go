@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -66,38 +67,66 @@ end:
 	return result, err
 }
 
-// isLikelyTextFile determines if a file is likely to contain text by examining
-// the first 512 bytes for null characters. Binary files typically contain many
-// null bytes, while text files contain very few or none.
+// isLikelyText determines if a sample of file content is likely to be text by
+// counting null characters. Binary files typically contain many null bytes,
+// while text files contain very few or none. An empty sample counts as text.
 //
 // This is a heuristic approach - not 100% accurate but works well in practice.
-func isLikelyTextFile(file *os.File) (isText bool, err error) {
-	var buf [512]byte
-	var n int
+// searchFile applies it to the first 512 bytes and then keeps watching for
+// null bytes as it scans, since many files have text headers followed by binary data.
+func isLikelyText(sample []byte) (isText bool) {
 	var nullCount int
 
-	// Read first 512 bytes of file
-	n, err = file.Read(buf[:])
-	if err != nil && n == 0 {
+	if len(sample) == 0 {
+		isText = true
 		goto end
 	}
 
-	// Reset error if we got some data (EOF after reading is normal)
-	if n > 0 {
-		err = nil
-	}
-
 	// Count null bytes in the sample
-	for i := 0; i < n; i++ {
-		if buf[i] == 0 {
+	for _, b := range sample {
+		if b == 0 {
 			nullCount++
 		}
 	}
 
 	// If more than 1% null bytes, probably binary
 	// This threshold works well for most text vs binary classification
-	isText = (nullCount * 100 / n) < 1
+	isText = (nullCount * 100 / len(sample)) < 1
+
+end:
+	return isText
+}
+
+// readFileList reads the paths listed in a file ("-" for standard input), one per
+// line or NUL-delimited when nullSeparated is set. Blank entries are ignored and
+// a trailing carriage return is stripped from newline-delimited entries.
+func readFileList(listPath string, nullSeparated bool) (paths []string, err error) {
+	var data []byte
+	var separator string
+
+	if listPath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(listPath)
+	}
+	if err != nil {
+		goto end
+	}
+
+	separator = "\n"
+	if nullSeparated {
+		separator = "\x00"
+	}
+	for _, path := range strings.Split(string(data), separator) {
+		if !nullSeparated {
+			path = strings.TrimSuffix(path, "\r")
+		}
+		if path == "" {
+			continue
+		}
+		paths = append(paths, path)
+	}
 
 end:
-	return isText, err
+	return paths, err
 }
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Timing of watch mode. Editors often write a file in several steps, so changes
+// are collected until things have been quiet for watchDebounce before searching.
+// A file that keeps changing, such as a log, is still searched once its oldest
+// pending change is watchMaxDelay old.
+const (
+	watchDebounce     = 150 * time.Millisecond
+	watchMaxDelay     = time.Second
+	watchPollInterval = time.Second
+)
+
+// Watch keeps searching after Run: whenever a file the search covers is created,
+// modified or removed it is searched again and the difference from its previous
+// results is printed, new matches as usual and removed ones marked with "-".
+// Changes come from inotify on Linux, and from polling elsewhere or when inotify
+// is unavailable. It returns when ctx is cancelled.
+func (ds *DirSearch) Watch(ctx context.Context) (err error) {
+	var changes chan string
+	var pending map[string]struct{}
+	var oldest time.Time
+	var flush <-chan time.Time
+
+	changes = make(chan string, 256)
+	err = startNativeWatch(ctx, ds, changes)
+	if err != nil {
+		if ds.verbose {
+			fmt.Printf("[TRACE] Falling back to polling for changes: %v\n", err)
+		}
+		err = nil
+		go ds.pollChanges(ctx, changes)
+	}
+
+	fmt.Printf("\nWatching for changes (Ctrl-C to stop)...\n")
+	pending = make(map[string]struct{})
+	for {
+		select {
+		case path := <-changes:
+			if len(pending) == 0 {
+				oldest = time.Now()
+			}
+			pending[path] = struct{}{}
+			flush = time.After(flushDelay(oldest, time.Now()))
+		case <-flush:
+			paths := make([]string, 0, len(pending))
+			for path := range pending {
+				paths = append(paths, path)
+			}
+			slices.Sort(paths)
+			clear(pending)
+			for _, path := range paths {
+				err = ds.rescan(ctx, path)
+				if err != nil {
+					goto end
+				}
+			}
+		case <-ctx.Done():
+			goto end
+		}
+	}
+
+end:
+	if ctx.Err() != nil {
+		// Being stopped is how watch mode ends, not a failure
+		err = nil
+	}
+	return err
+}
+
+// flushDelay returns how long to wait after a change before searching the
+// pending paths, the oldest of which changed at oldest.
+func flushDelay(oldest, now time.Time) (delay time.Duration) {
+	delay = min(watchDebounce, oldest.Add(watchMaxDelay).Sub(now))
+	return max(delay, 0)
+}
+
+// rescan searches one changed path again and prints how its results changed.
+// When the path is gone, results of files below it are dropped too, in case it
+// was a directory.
+func (ds *DirSearch) rescan(ctx context.Context, path string) (err error) {
+	var display string
+	var ok bool
+	var matches []Match
+	var added []Match
+	var removed []Match
+
+	if _, statErr := os.Lstat(path); errors.Is(statErr, fs.ErrNotExist) {
+		ds.dropResultsUnder(path)
+	}
+
+	display, ok = ds.watchTarget(path)
+	if !ok {
+		goto end
+	}
+	if ds.verbose {
+		fmt.Printf("[TRACE] Change in %s, searching it again\n", path)
+	}
+
+	matches, err = ds.collectFile(ctx, path, display)
+	if err != nil {
+		goto end
+	}
+	added, removed = diffMatches(ds.results[display], matches)
+	ds.results[display] = matches
+
+	if len(removed) > 0 {
+		printRemovedMatches(display, removed)
+	}
+	for _, match := range added {
+		err = ds.output(match)
+		if err != nil {
+			goto end
+		}
+	}
+
+end:
+	return err
+}
+
+// collectFile searches a single file and returns its matches instead of printing
+// them. Watch mode only calls it after Run has finished, one file at a time, so
+// it can take over the match channel.
+func (ds *DirSearch) collectFile(ctx context.Context, path, display string) (matches []Match, err error) {
+	var done chan struct{}
+
+	ds.matchChan = make(chan Match, ds.opts.MaxWorkers)
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for match := range ds.matchChan {
+			matches = append(matches, match)
+		}
+	}()
+
+	err = ds.searchFile(ctx, path, display)
+	close(ds.matchChan)
+	<-done
+	return matches, err
+}
+
+// diffMatches compares a file's old and new results by line text, so matches
+// that only moved because lines were inserted above them aren't reported.
+func diffMatches(old, current []Match) (added, removed []Match) {
+	var counts map[string]int
+
+	counts = make(map[string]int)
+	for _, match := range old {
+		counts[match.Line]++
+	}
+	for _, match := range current {
+		if counts[match.Line] > 0 {
+			counts[match.Line]--
+			continue
+		}
+		added = append(added, match)
+	}
+	// Whatever wasn't matched up by the new results is gone
+	for _, match := range slices.Backward(old) {
+		if counts[match.Line] > 0 {
+			counts[match.Line]--
+			removed = append(removed, match)
+		}
+	}
+	slices.Reverse(removed)
+	return added, removed
+}
+
+// watchTarget decides whether a changed path is one the search covers, applying
+// the same glob, depth, directory, symlink and metadata rules as the initial walk,
+// and returns how it is displayed. Removed files pass so their matches can be dropped.
+func (ds *DirSearch) watchTarget(path string) (display string, ok bool) {
+	var abs string
+	var stat os.FileInfo
+	var err error
+
+	abs = absPath(path)
+	for _, file := range ds.files {
+		if file != "-" && absPath(file) == abs {
+			// Explicitly added files are followed even when they are symlinks
+			stat, err = os.Stat(path)
+			if err == nil && !stat.Mode().IsRegular() {
+				goto end
+			}
+			display, ok = ds.fileDisplayPath(file), true
+			goto end
+		}
+	}
+
+	// Like the walk, only follow symlinks with -L; a link whose target is gone
+	// counts as removed
+	stat, err = os.Lstat(path)
+	if err == nil && stat.Mode()&os.ModeSymlink != 0 {
+		if !ds.opts.FollowSymlinks {
+			goto end
+		}
+		stat, err = os.Stat(path)
+	}
+	if err == nil && !stat.Mode().IsRegular() {
+		goto end
+	}
+
+	for i := range ds.roots {
+		root := &ds.roots[i]
+		if !root.selects(path, ds.opts.MinDepth, ds.opts.MaxDepth) || root.leaves(path, ds.opts.MinDepth, ds.opts.MaxDepth) {
+			continue
+		}
+		if stat != nil && !ds.keepFile(stat) {
+			continue
+		}
+		display, ok = root.displayPath(path, ds.opts.AbsolutePaths), true
+		break
+	}
+
+end:
+	return display, ok
+}
+
+// dropResultsUnder forgets the results of every file below dir, which was
+// removed or moved away, and prints them as removed. Nothing reports those
+// files one by one when their directory goes as a whole.
+func (ds *DirSearch) dropResultsUnder(dir string) {
+	var abs string
+	var prefixes []string
+	var displays []string
+
+	abs = absPath(dir)
+	prefixes = append(prefixes, ds.fileDisplayPath(dir)+string(filepath.Separator))
+	for _, root := range ds.roots {
+		if isWithin(abs, absPath(root.Dir)) {
+			prefixes = append(prefixes, root.displayPath(dir, ds.opts.AbsolutePaths)+string(filepath.Separator))
+		}
+	}
+
+	for display := range ds.results {
+		if slices.ContainsFunc(prefixes, func(prefix string) bool { return strings.HasPrefix(display, prefix) }) {
+			displays = append(displays, display)
+		}
+	}
+	slices.Sort(displays)
+	for _, display := range displays {
+		if len(ds.results[display]) > 0 {
+			printRemovedMatches(display, ds.results[display])
+		}
+		delete(ds.results, display)
+	}
+}
+
+// walkWatched calls fn for every directory and regular file a watch has to keep
+// an eye on: the trees below the roots (see walkWatchedDir) and the explicitly
+// added files.
+func (ds *DirSearch) walkWatched(fn func(path string, isDir bool)) {
+	var seen map[fileID]struct{}
+
+	seen = make(map[fileID]struct{})
+	for _, root := range ds.roots {
+		ds.walkWatchedDir(root.Dir, 0, seen, fn)
+	}
+	for _, file := range ds.files {
+		if file != "-" {
+			fn(file, false)
+		}
+	}
+}
+
+// walkWatchedDir calls fn for dir, which is depth levels below its root, and for
+// what it contains, entering the same directories as searchDirectory: skipped
+// directories and anything beyond --max-depth are left out, and symlinks are
+// only followed with -L. seen holds the directories already walked, so links
+// back up the tree don't loop.
+func (ds *DirSearch) walkWatchedDir(dir string, depth int, seen map[fileID]struct{}, fn func(path string, isDir bool)) {
+	var entries []os.DirEntry
+	var id fileID
+	var err error
+
+	if ds.opts.FollowSymlinks {
+		id, err = fileIDOf(dir)
+		if err == nil {
+			if _, ok := seen[id]; ok {
+				return
+			}
+			seen[id] = struct{}{}
+		}
+	}
+
+	entries, err = os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	fn(dir, true)
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		mode := entry.Type()
+		if mode&os.ModeSymlink != 0 {
+			if !ds.opts.FollowSymlinks {
+				continue
+			}
+			stat, statErr := os.Stat(path)
+			if statErr != nil {
+				continue
+			}
+			mode = stat.Mode()
+		}
+
+		switch {
+		case mode.IsDir():
+			if !shouldSkipDirectory(entry.Name()) && (ds.opts.MaxDepth == 0 || depth+1 < ds.opts.MaxDepth) {
+				ds.walkWatchedDir(path, depth+1, seen, fn)
+			}
+		case mode.IsRegular():
+			fn(path, false)
+		}
+	}
+}
+
+// watchDepth returns how far dir is below the nearest root containing it, or -1
+// if no root does.
+func (ds *DirSearch) watchDepth(dir string) (depth int) {
+	var abs string
+
+	depth = -1
+	abs = absPath(dir)
+	for _, root := range ds.roots {
+		rootAbs := absPath(root.Dir)
+		if !isWithin(abs, rootAbs) {
+			continue
+		}
+		rel, _ := filepath.Rel(rootAbs, abs)
+		d := 0
+		if rel != "." {
+			d = len(strings.Split(rel, string(filepath.Separator)))
+		}
+		if depth < 0 || d < depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+// fileState is what polling compares to notice a change.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// pollChanges is the portable fallback for native change notification: every
+// watchPollInterval it lists the watched files and reports any whose size or
+// modification time changed, that appeared, or that disappeared.
+func (ds *DirSearch) pollChanges(ctx context.Context, changes chan<- string) {
+	var previous map[string]fileState
+	var current map[string]fileState
+	var ticker *time.Ticker
+
+	snapshot := func() (states map[string]fileState) {
+		states = make(map[string]fileState)
+		ds.walkWatched(func(path string, isDir bool) {
+			if isDir {
+				return
+			}
+			if stat, err := os.Stat(path); err == nil {
+				states[path] = fileState{size: stat.Size(), modTime: stat.ModTime()}
+			}
+		})
+		return states
+	}
+
+	previous = snapshot()
+	ticker = time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		current = snapshot()
+		for path, state := range current {
+			if old, ok := previous[path]; !ok || old != state {
+				select {
+				case changes <- path:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		for path := range previous {
+			if _, ok := current[path]; !ok {
+				select {
+				case changes <- path:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		previous = current
+	}
+}
@@ -0,0 +1,184 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask selects the events that can change a file's matches.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotifyWatcher turns inotify events for the watched directories into paths.
+type inotifyWatcher struct {
+	ds   *DirSearch
+	file *os.File         // The inotify descriptor, non-blocking so Close interrupts Read
+	dirs map[int32]string // Directory of each watch descriptor
+}
+
+// startNativeWatch watches every directory of the search with inotify and sends
+// the paths of changed files to changes until ctx is cancelled. Directories
+// created later are watched as they appear.
+func startNativeWatch(ctx context.Context, ds *DirSearch, changes chan<- string) (err error) {
+	var fd int
+	var w *inotifyWatcher
+
+	fd, err = syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		err = fmt.Errorf("inotify: %w", err)
+		goto end
+	}
+	w = &inotifyWatcher{ds: ds, file: os.NewFile(uintptr(fd), "inotify"), dirs: make(map[int32]string)}
+
+	ds.walkWatched(func(path string, isDir bool) {
+		if isDir {
+			err = errors.Join(err, w.add(path))
+		} else if !w.watching(filepath.Dir(path)) {
+			// Explicitly added files are watched through their directory
+			err = errors.Join(err, w.add(filepath.Dir(path)))
+		}
+	})
+	if err != nil {
+		// Most likely fs.inotify.max_user_watches; polling has no such limit
+		_ = w.file.Close()
+		goto end
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = w.file.Close()
+	}()
+	go w.run(ctx, changes)
+
+end:
+	return err
+}
+
+// add starts watching one directory.
+func (w *inotifyWatcher) add(dir string) (err error) {
+	var wd int
+
+	wd, err = syscall.InotifyAddWatch(int(w.file.Fd()), dir, inotifyMask)
+	if err != nil {
+		err = fmt.Errorf("watching %s: %w", dir, err)
+		goto end
+	}
+	w.dirs[int32(wd)] = dir
+
+end:
+	return err
+}
+
+// remove stops watching dir and every directory below it.
+func (w *inotifyWatcher) remove(dir string) {
+	for wd, watched := range w.dirs {
+		if watched == dir || strings.HasPrefix(watched, dir+string(filepath.Separator)) {
+			// Fails harmlessly when the kernel already dropped the watch of a deleted directory
+			_, _ = syscall.InotifyRmWatch(int(w.file.Fd()), uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// watching reports whether dir already has a watch.
+func (w *inotifyWatcher) watching(dir string) bool {
+	for _, watched := range w.dirs {
+		if watched == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// run reads events until the descriptor is closed.
+func (w *inotifyWatcher) run(ctx context.Context, changes chan<- string) {
+	var buf [64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)]byte
+
+	for {
+		n, err := w.file.Read(buf[:])
+		if err != nil {
+			if w.ds.verbose && !errors.Is(err, fs.ErrClosed) {
+				fmt.Printf("[TRACE] Reading inotify events: %v\n", err)
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			dir, ok := w.dirs[event.Wd]
+			if !ok || event.Len == 0 {
+				continue
+			}
+			path := filepath.Join(dir, cString(nameBytes))
+
+			gone := event.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0
+			created := event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0
+			isDir := event.Mask&syscall.IN_ISDIR != 0
+			if created && !isDir && w.ds.opts.FollowSymlinks {
+				// With -L a new link to a directory brings in a whole tree
+				stat, statErr := os.Stat(path)
+				isDir = statErr == nil && stat.IsDir()
+			}
+			switch {
+			case gone:
+				// A directory that went away takes its watches along; searching
+				// its path again drops the results of its files
+				w.remove(path)
+			case isDir:
+				if created {
+					w.addTree(ctx, path, changes)
+				}
+				continue
+			}
+			select {
+			case changes <- path:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// addTree watches a directory that appeared after the watch started, and reports
+// the files already in it since their creation events were missed.
+func (w *inotifyWatcher) addTree(ctx context.Context, dir string, changes chan<- string) {
+	depth := w.ds.watchDepth(dir)
+	if depth < 0 || shouldSkipDirectory(filepath.Base(dir)) || (w.ds.opts.MaxDepth > 0 && depth >= w.ds.opts.MaxDepth) {
+		return
+	}
+
+	w.ds.walkWatchedDir(dir, depth, make(map[fileID]struct{}), func(path string, isDir bool) {
+		if isDir {
+			if err := w.add(path); err != nil && w.ds.verbose {
+				fmt.Printf("[TRACE] %v\n", err)
+			}
+			return
+		}
+		select {
+		case changes <- path:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// cString converts a NUL-padded name from an inotify event into a string.
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// startNativeWatch has no implementation outside Linux, so watch mode polls.
+func startNativeWatch(ctx context.Context, ds *DirSearch, changes chan<- string) error {
+	return errors.New("native file watching is only implemented for Linux")
+}
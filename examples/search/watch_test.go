@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlushDelay(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		age   time.Duration
+		delay time.Duration
+	}{
+		{0, watchDebounce},
+		{watchMaxDelay - watchDebounce, watchDebounce},
+		{watchMaxDelay - watchDebounce/3, watchDebounce / 3},
+		{watchMaxDelay, 0},
+		{2 * watchMaxDelay, 0},
+	}
+
+	for _, tt := range tests {
+		if delay := flushDelay(now.Add(-tt.age), now); delay != tt.delay {
+			t.Errorf("flushDelay for a change %v old = %v, want %v", tt.age, delay, tt.delay)
+		}
+	}
+}
+
+// TestWatchBusyFile checks that a file written more often than watchDebounce
+// is still searched again while it keeps changing.
+func TestWatchBusyFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "busy.log")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	opts := DefaultOptions()
+	opts.Watch = true
+	ds := NewDirSearch([]Root{{Dir: dir, Glob: "*", Display: dir}}, regexp.MustCompile("needle"), opts)
+	found := make(chan Match, 1)
+	ds.SetOutput(func(match Match) error {
+		select {
+		case found <- match:
+		default:
+		}
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err = ds.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	watched := make(chan error, 1)
+	_, err = captureStdout(t, func() error {
+		go func() { watched <- ds.Watch(ctx) }()
+
+		// Writes every watchDebounce/3 never leave the file quiet for long enough
+		ticker := time.NewTicker(watchDebounce / 3)
+		defer ticker.Stop()
+		timeout := time.After(watchMaxDelay + 5*watchPollInterval)
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := file.WriteString("needle\n"); err != nil {
+					t.Fatal(err)
+				}
+			case <-found:
+				cancel()
+				return <-watched
+			case <-timeout:
+				t.Errorf("no results while the file kept changing")
+				cancel()
+				return <-watched
+			}
+		}
+	})
+	if err != nil {
+		t.Errorf("Watch returned %v", err)
+	}
+}
+
+// TestWatchSymlinks checks that watch mode follows symlinks only with -L, as
+// the initial walk does, and that a link back up the tree doesn't loop.
+func TestWatchSymlinks(t *testing.T) {
+	t.Chdir(writeTree(t, map[string]string{
+		"d/a.txt":         "needle\n",
+		"out/target.txt":  "needle\n",
+		"out/sub/b.txt":   "needle\n",
+		"explicit/ex.txt": "needle\n",
+	}))
+	for link, target := range map[string]string{"d/link.txt": "../out/target.txt", "d/dir": "../out/sub", "d/loop": "."} {
+		if err := os.Symlink(target, filepath.FromSlash(link)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join("..", "out", "target.txt"), filepath.Join("explicit", "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, follow := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.FollowSymlinks = follow
+		ds := NewDirSearch([]Root{{Dir: "d", Glob: "*", Display: "d"}}, regexp.MustCompile("needle"), opts)
+		ds.AddFiles(filepath.Join("explicit", "link.txt"))
+
+		var walked []string
+		ds.walkWatched(func(path string, isDir bool) {
+			if !isDir {
+				walked = append(walked, filepath.ToSlash(path))
+			}
+		})
+		slices.Sort(walked)
+		want := []string{"d/a.txt", "explicit/link.txt"}
+		if follow {
+			want = []string{"d/a.txt", "d/dir/b.txt", "d/link.txt", "explicit/link.txt"}
+		}
+		if !slices.Equal(walked, want) {
+			t.Errorf("with FollowSymlinks %v: walked %q, want %q", follow, walked, want)
+		}
+
+		for path, wantOK := range map[string]bool{"d/a.txt": true, "d/link.txt": follow, "explicit/link.txt": true, "d/gone.txt": true, "d/dir": false} {
+			if _, ok := ds.watchTarget(filepath.FromSlash(path)); ok != wantOK {
+				t.Errorf("with FollowSymlinks %v: watchTarget(%s) = %v, want %v", follow, path, ok, wantOK)
+			}
+		}
+	}
+}
+
+// TestRescanDirectoryMovedAway checks that the results of files in a directory
+// moved out of the tree are dropped once its path is searched again.
+func TestRescanDirectoryMovedAway(t *testing.T) {
+	t.Chdir(writeTree(t, map[string]string{
+		"d/a.txt":       "needle\n",
+		"d/sub/b.txt":   "needle\n",
+		"d/sub/c/e.txt": "needle\n",
+		"d/subway.txt":  "needle\n",
+	}))
+	opts := DefaultOptions()
+	opts.Watch = true
+	ds := NewDirSearch([]Root{{Dir: "d", Glob: "*", Display: "d"}}, regexp.MustCompile("needle"), opts)
+	ds.SetOutput(func(Match) error { return nil })
+	if err := ds.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join("d", "sub"), "moved"); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := captureStdout(t, func() error { return ds.rescan(context.Background(), filepath.Join("d", "sub")) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for display, matches := range ds.results {
+		if len(matches) > 0 {
+			kept = append(kept, filepath.ToSlash(display))
+		}
+	}
+	slices.Sort(kept)
+	if want := []string{"d/a.txt", "d/subway.txt"}; !slices.Equal(kept, want) {
+		t.Errorf("results kept for %q, want %q", kept, want)
+	}
+	if strings.Count(output, "-1:  needle") != 2 || !strings.Contains(output, filepath.Join("d", "sub", "c", "e.txt")) {
+		t.Errorf("removed matches printed as %q, want those of b.txt and e.txt", output)
+	}
+}
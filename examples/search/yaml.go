@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is one significant line of a YAML document.
+type yamlLine struct {
+	number int    // 1-based, for messages
+	indent int    // Leading spaces
+	text   string // The rest, without comment and trailing whitespace
+}
+
+// yamlParser builds values from the lines of a document, consuming them from pos.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML parses the subset of YAML that rules files need: block mappings and
+// block sequences nested by indentation, flow sequences such as ["*.go", "*.s"],
+// and plain, single-quoted and double-quoted scalars, with # comments. Scalars
+// are always strings and an empty value is nil. Anchors, tags, flow mappings,
+// multi-line scalars and multiple documents are not supported.
+//
+// The result is made of map[string]any, []any and string values, so it can be
+// turned into JSON and decoded like a JSON document.
+func parseYAML(data []byte) (value any, err error) {
+	var p yamlParser
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || (i == 0 && text == "---") {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			err = fmt.Errorf("line %d: tabs cannot be used for indentation", i+1)
+			goto end
+		}
+		p.lines = append(p.lines, yamlLine{number: i + 1, indent: len(line) - len(text), text: text})
+	}
+	if len(p.lines) == 0 {
+		goto end
+	}
+
+	value, err = p.node(p.lines[0].indent)
+	if err == nil && p.pos < len(p.lines) {
+		err = p.errorf("unexpected indentation")
+	}
+
+end:
+	return value, err
+}
+
+// node parses the mapping or sequence starting at the current line.
+func (p *yamlParser) node(indent int) (value any, err error) {
+	if isYAMLItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+// sequence parses the "- " items at indent.
+func (p *yamlParser) sequence(indent int) (list []any, err error) {
+	var line *yamlLine
+	var rest string
+	var item any
+
+	list = []any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text) {
+		line = &p.lines[p.pos]
+		rest = strings.TrimLeft(line.text[1:], " ")
+		switch {
+		case rest == "":
+			p.pos++
+			item, err = p.blockValue(indent, false)
+		case isYAMLEntry(rest):
+			// "- key: value" starts a mapping whose keys line up with key
+			line.indent, line.text = indent+len(line.text)-len(rest), rest
+			item, err = p.mapping(line.indent)
+		default:
+			item, err = parseYAMLValue(rest)
+			if err != nil {
+				err = p.errorf("%v", err)
+			}
+			p.pos++
+		}
+		if err != nil {
+			goto end
+		}
+		list = append(list, item)
+	}
+
+end:
+	return list, err
+}
+
+// mapping parses the "key: value" entries at indent.
+func (p *yamlParser) mapping(indent int) (m map[string]any, err error) {
+	var key string
+	var value string
+	var ok bool
+	var exists bool
+
+	m = map[string]any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		key, value, ok = cutYAMLEntry(p.lines[p.pos].text)
+		if !ok {
+			err = p.errorf("expected \"key: value\"")
+			goto end
+		}
+		if _, exists = m[key]; exists {
+			err = p.errorf("duplicate key %q", key)
+			goto end
+		}
+		p.pos++
+
+		if value == "" {
+			m[key], err = p.blockValue(indent, true)
+		} else if m[key], err = parseYAMLValue(value); err != nil {
+			err = fmt.Errorf("line %d: %w", p.lines[p.pos-1].number, err)
+		}
+		if err != nil {
+			goto end
+		}
+	}
+
+end:
+	return m, err
+}
+
+// blockValue parses the value of an entry or item that continues on the next
+// lines: a more indented node or, after a mapping key, a sequence at the same
+// indentation. Without either the value is empty.
+func (p *yamlParser) blockValue(indent int, afterKey bool) (value any, err error) {
+	var next yamlLine
+
+	if p.pos == len(p.lines) {
+		goto end
+	}
+	next = p.lines[p.pos]
+	if next.indent > indent || (afterKey && next.indent == indent && isYAMLItem(next.text)) {
+		value, err = p.node(next.indent)
+	}
+
+end:
+	return value, err
+}
+
+// errorf returns an error about the current line.
+func (p *yamlParser) errorf(format string, args ...any) error {
+	var number int
+
+	number = p.lines[len(p.lines)-1].number
+	if p.pos < len(p.lines) {
+		number = p.lines[p.pos].number
+	}
+	return fmt.Errorf("line %d: %s", number, fmt.Sprintf(format, args...))
+}
+
+// isYAMLItem reports whether text is a sequence item.
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// isYAMLEntry reports whether text is a mapping entry rather than a scalar.
+func isYAMLEntry(text string) bool {
+	_, _, ok := cutYAMLEntry(text)
+	return ok
+}
+
+// cutYAMLEntry splits a "key: value" line. Keys are plain scalars; quoted or
+// flow text is a value.
+func cutYAMLEntry(text string) (key, value string, ok bool) {
+	if strings.ContainsRune("'\"[{", rune(text[0])) {
+		goto end
+	}
+	if key, ok = strings.CutSuffix(text, ":"); !ok {
+		key, value, ok = strings.Cut(text, ": ")
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	ok = ok && key != ""
+
+end:
+	return key, value, ok
+}
+
+// parseYAMLValue parses an inline value: a flow sequence of scalars or a scalar.
+func parseYAMLValue(text string) (value any, err error) {
+	var inner string
+	var list []any
+	var item any
+
+	switch text[0] {
+	case '[':
+		if !strings.HasSuffix(text, "]") {
+			err = fmt.Errorf("unterminated flow sequence %s", text)
+			goto end
+		}
+		list = []any{}
+		inner = strings.TrimSpace(text[1 : len(text)-1])
+		if inner == "" {
+			value = list
+			goto end
+		}
+		for _, part := range splitYAMLFlow(inner) {
+			item, err = parseYAMLScalar(strings.TrimSpace(part))
+			if err != nil {
+				goto end
+			}
+			list = append(list, item)
+		}
+		value = list
+	case '{', '|', '>', '&', '*', '!':
+		err = fmt.Errorf("unsupported YAML syntax %s (use quotes for a string starting with %c)", text, text[0])
+	default:
+		value, err = parseYAMLScalar(text)
+	}
+
+end:
+	return value, err
+}
+
+// parseYAMLScalar parses a scalar. In single quotes a doubled quote stands for
+// one and nothing else is special, which suits regular expressions; double
+// quotes take backslash escapes such as \n and \".
+func parseYAMLScalar(text string) (s string, err error) {
+	switch {
+	case text == "":
+		err = fmt.Errorf("empty flow sequence item")
+	case text[0] == '\'':
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			err = fmt.Errorf("unterminated single-quoted string %s", text)
+			break
+		}
+		s = strings.ReplaceAll(text[1:len(text)-1], "''", "'")
+	case text[0] == '"':
+		s, err = strconv.Unquote(text)
+		if err != nil {
+			err = fmt.Errorf("invalid double-quoted string %s (single quotes need no escaping)", text)
+		}
+	default:
+		s = text
+	}
+	return s, err
+}
+
+// splitYAMLFlow splits the inside of a flow sequence at commas outside quotes.
+func splitYAMLFlow(s string) (parts []string) {
+	var quote byte
+	var start int
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '\'' || s[i] == '"':
+			quote = s[i]
+		case s[i] == ',':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// stripYAMLComment removes a # comment from line. A # only starts a comment at
+// the beginning of the line or after whitespace, and not inside a quoted scalar;
+// quotes only count at the start of a scalar, so plain text like it's is fine.
+func stripYAMLComment(line string) string {
+	var quote byte
+	var prev byte
+
+	prev = ' '
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+			continue
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '\'' || c == '"') && strings.IndexByte(" \t:-[,", prev) >= 0:
+			quote = c
+		case c == '#' && (prev == ' ' || prev == '\t'):
+			return line[:i]
+		}
+		prev = c
+	}
+	return line
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  any
+	}{
+		{"empty", "# nothing\n\n", nil},
+		{"scalar values", "a: 1\nb: two words\nc:\n", map[string]any{"a": "1", "b": "two words", "c": nil}},
+		{"document marker", "---\na: b\n", map[string]any{"a": "b"}},
+		{"comments", "a: b # note\nc: 'd # e' # f\ng: it's#not\n", map[string]any{"a": "b", "c": "d # e", "g": "it's#not"}},
+		{"quotes", `a: 'it''s \d'` + "\n" + `b: "tab\there \"x\""` + "\n", map[string]any{"a": `it's \d`, "b": "tab\there \"x\""}},
+		{"flow sequence", `a: ["*.go", '*.s', plain, "x,y"]` + "\nb: []\n", map[string]any{"a": []any{"*.go", "*.s", "plain", "x,y"}, "b": []any{}}},
+		{"block sequence", "- a\n- 'b'\n-\n  - c\n", []any{"a", "b", []any{"c"}}},
+		{
+			"sequence under key at same indent",
+			"rules:\n- id: x\n  regex: y\n- id: z\n",
+			map[string]any{"rules": []any{map[string]any{"id": "x", "regex": "y"}, map[string]any{"id": "z"}}},
+		},
+		{
+			"rules file",
+			"rules:\n  - id: no-println\n    regex: 'fmt\\.Print(ln|f)?\\('\n    include: \"*.go\"\n    exclude:\n      - \"*_test.go\"\n      - cmd/**\n    severity: warning\n",
+			map[string]any{"rules": []any{map[string]any{
+				"id": "no-println", "regex": `fmt\.Print(ln|f)?\(`, "include": "*.go",
+				"exclude": []any{"*_test.go", "cmd/**"}, "severity": "warning",
+			}}},
+		},
+		{"nested mappings", "a:\n  b:\n    c: d\n  e: f\n", map[string]any{"a": map[string]any{"b": map[string]any{"c": "d"}, "e": "f"}}},
+		{"CRLF", "a: b\r\nc: d\r\n", map[string]any{"a": "b", "c": "d"}},
+	}
+
+	for _, tt := range tests {
+		got, err := parseYAML([]byte(tt.input))
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseYAML(%q) = %#v, %v; want %#v", tt.name, tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"a: b\na: c\n", `line 2: duplicate key "a"`},
+		{"a: b\n\tc: d\n", "line 2: tabs cannot be used for indentation"},
+		{"a: b\n    c: d\n", "line 2: unexpected indentation"},
+		{"a: b\njust text\n", `line 2: expected "key: value"`},
+		{"a: [x, y\n", "line 1: unterminated flow sequence"},
+		{"a: [x, , y]\n", "line 1: empty flow sequence item"},
+		{"a: 'x\n", "line 1: unterminated single-quoted string"},
+		{`a: "\q"` + "\n", "line 1: invalid double-quoted string"},
+		{"a: {b: c}\n", "line 1: unsupported YAML syntax"},
+		{"a: &anchor x\n", "line 1: unsupported YAML syntax"},
+		{"a: |\n  text\n", "line 1: unsupported YAML syntax"},
+		{"- a\n- [b\n", "line 2: unterminated flow sequence"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseYAML([]byte(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseYAML(%q) error = %v, want %q", tt.input, err, tt.err)
+		}
+	}
+}